@@ -1,33 +1,48 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"os/exec"
-	"path"
+	"os/signal"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/creativeprojects/go-selfupdate"
+	"github.com/nicknickel/gossh/internal/clipboard"
 	"github.com/nicknickel/gossh/internal/connection"
 	"github.com/nicknickel/gossh/internal/encryption"
+	"github.com/nicknickel/gossh/internal/facts"
+	"github.com/nicknickel/gossh/internal/hostkey"
 	"github.com/nicknickel/gossh/internal/log"
 	"github.com/nicknickel/gossh/internal/menus"
+	"github.com/nicknickel/gossh/internal/rotate"
+	"github.com/nicknickel/gossh/internal/rsync"
 	"github.com/nicknickel/gossh/internal/runcommand"
+	"github.com/nicknickel/gossh/internal/secret"
+	"github.com/nicknickel/gossh/internal/sshagent"
+	"github.com/nicknickel/gossh/internal/totp"
+	"github.com/nicknickel/gossh/internal/transfer"
+	"github.com/nicknickel/gossh/internal/tunnel"
+	"github.com/nicknickel/gossh/internal/usage"
 )
 
 var updateVersion bool
 var version string = "dev"
 var initialFilter string
+var printAuth bool
 
 func init() {
 	log.Init()
 	flag.BoolVar(&updateVersion, "update", false, "Pass this flag to update the gossh version to latest github release and exit")
 	flag.StringVar(&initialFilter, "filter", "", "Pass this flag to filter the initial list of connections")
 	flag.StringVar(&initialFilter, "f", "", "shorthand for filter")
+	flag.BoolVar(&printAuth, "print-auth", false, "Pass this flag to print passwords in show-auth instead of copying them to the clipboard")
 }
 
 func updateExecutable() error {
@@ -82,30 +97,163 @@ func HandleTmux(name string) error {
 	return nil
 }
 
-func GetAuthentication(i connection.Item) string {
+// GetAuthentication describes how to authenticate to the connection. The
+// password is only included in the output when reveal is set, otherwise it is
+// returned separately so it can be copied to the clipboard.
+func GetAuthentication(i connection.Item, reveal bool) (string, string) {
 	var output string
-	if i.Conn.PassFile != "" {
+	var password string
+	if i.Conn.Password != "" {
+		pw, err := encryption.GetSecretPassword(i.Conn.Password)
+		if err != nil {
+			output = fmt.Sprintf("Password could not be read from %v: %v", i.Conn.Password, err)
+		} else if reveal {
+			output = fmt.Sprintf("Password is %v", pw)
+		} else {
+			output = fmt.Sprintf("Password read from %v", i.Conn.Password)
+			password = pw
+		}
+	} else if i.Conn.PassFile != "" {
 		pw := encryption.GetEncryptedContents(i.Conn.PassFile)
 		if pw == "" {
 			output = fmt.Sprintf("Password can be found in %v", i.Conn.PassFile)
-		} else {
+		} else if reveal {
 			output = fmt.Sprintf("Password is %v", strings.TrimSpace(pw))
+		} else {
+			output = fmt.Sprintf("Password decrypted from %v", i.Conn.PassFile)
+			password = strings.TrimSpace(pw)
 		}
 	} else if i.Conn.IdentityFile != "" {
-		tempIdFile := encryption.GetEncryptedIdentity(i.Conn.IdentityFile)
-		if tempIdFile != "" {
-			output = fmt.Sprintf("Temporary identity file is %v (remove when done)", tempIdFile)
+		lifetime, err := sshagent.AddToRunningAgent(i.Conn.IdentityFile)
+		if err == nil && lifetime == 0 {
+			output = fmt.Sprintf("Identity file %v is already in ssh-agent", i.Conn.IdentityFile)
+		} else if err == nil {
+			output = fmt.Sprintf("Identity file %v loaded into ssh-agent for %v seconds", i.Conn.IdentityFile, lifetime)
 		} else {
 			output = fmt.Sprintf("Identity file is %v", i.Conn.IdentityFile)
 		}
 	}
 
-	return output
+	if i.Conn.Totp != "" {
+		code, remaining, err := totp.GetCode(i.Conn.Totp)
+		if err != nil {
+			output += fmt.Sprintf("\n\tTOTP code could not be generated from %v: %v", i.Conn.Totp, err)
+		} else {
+			output += fmt.Sprintf("\n\tTOTP code is %v (valid for %v seconds)", code, remaining)
+		}
+	}
+
+	return output, password
+}
+
+// ErrInterrupted is returned by CopyToClipboard when ctrl+c cleared the
+// clipboard, so no further passwords are copied
+var ErrInterrupted = errors.New("interrupted")
+
+// CopyToClipboard copies the password and clears it again after the timeout,
+// when enter is pressed or on ctrl+c, whichever comes first
+func CopyToClipboard(pw string, enter <-chan struct{}, interrupt <-chan os.Signal) error {
+	method := clipboard.GetMethod()
+	if err := clipboard.Copy(method, pw); err != nil {
+		return err
+	}
+
+	timeout := clipboard.GetTimeout()
+	fmt.Printf("\tPassword copied to clipboard (%v), clearing in %v. Press enter to clear now.\n", method, timeout)
+
+	interrupted := false
+	select {
+	case <-enter:
+	case <-interrupt:
+		interrupted = true
+	case <-time.After(timeout):
+	}
+
+	if err := clipboard.Clear(method, pw); err != nil {
+		return err
+	}
+	if interrupted {
+		return ErrInterrupted
+	}
+	return nil
+}
+
+func RunTransfers(jobs []*transfer.Job) {
+	if err := menus.TransferProgress(jobs); err != nil {
+		fmt.Printf("Could not show transfer progress: %v\n", err)
+	}
+
+	for _, job := range jobs {
+		switch {
+		case !job.Finished():
+			fmt.Printf("%v: cancelled\n", job.Item.WindowName())
+		case job.Err != nil:
+			fmt.Printf("%v: %v\n", job.Item.WindowName(), job.Err)
+			for _, p := range job.Mismatches {
+				fmt.Printf("  checksum mismatch: %v\n", p)
+			}
+		default:
+			fmt.Printf("%v: copied (%v verified, %v resumed, %v unchanged)\n",
+				job.Item.WindowName(), job.Verified, job.Resumed, job.Skipped)
+			for _, p := range job.Paths {
+				fmt.Printf("  %v -> %v\n", p.Src, p.Dest)
+			}
+		}
+	}
+}
+
+// ConfirmTransfers previews the jobs and runs the ones that can run once the
+// user agrees
+func ConfirmTransfers(jobs []*transfer.Job) {
+	fmt.Print(menus.TransferPreview(jobs))
+
+	var ready []*transfer.Job
+	for _, job := range jobs {
+		if job.Err == nil {
+			ready = append(ready, job)
+		}
+	}
+	if len(ready) == 0 {
+		fmt.Println("Nothing to transfer.")
+		return
+	}
+
+	fmt.Print("Transfer these files? [y/N] ")
+	var answer string
+	fmt.Scanln(&answer)
+	if strings.ToLower(answer) != "y" {
+		return
+	}
+	RunTransfers(ready)
 }
 
 func main() {
 	flag.Parse()
 
+	if flag.Arg(0) == "secret" {
+		if err := secret.Run(flag.Args()[1:]); err != nil {
+			fmt.Printf("secret: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if flag.Arg(0) == "tunnel" {
+		if err := tunnel.Run(flag.Args()[1:]); err != nil {
+			fmt.Printf("tunnel: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if flag.Arg(0) == "keyscan" {
+		if err := hostkey.Run(flag.Args()[1:]); err != nil {
+			fmt.Printf("keyscan: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	if updateVersion {
 		if err := updateExecutable(); err != nil {
 			fmt.Printf("Could not update to latest version: %v\n", err)
@@ -114,7 +262,17 @@ func main() {
 		os.Exit(0)
 	}
 
-	lm, err := menus.ConnectionList(initialFilter)
+	// adding, editing or deleting connections returns to the list
+	var status string
+	lm, err := menus.ConnectionList(initialFilter, status)
+	for err == nil && menus.IsManageAction(lm.Action) {
+		status, err = menus.Manage(lm)
+		if err != nil {
+			log.Logger.Error("Could not change the connections", "err", err)
+			status = err.Error()
+		}
+		lm, err = menus.ConnectionList(initialFilter, status)
+	}
 	if err != nil {
 		log.Logger.Error("Error running program: ", err)
 		os.Exit(1)
@@ -126,11 +284,42 @@ func main() {
 	}
 
 	connItems := lm.GetCheckedItems()
+	if lm.Action != "" {
+		if err := usage.Record(connItems, lm.Action, time.Now()); err != nil {
+			log.Logger.Error("Could not record usage", "err", err)
+		}
+	}
 
 	switch lm.Action {
 	case "ShowAuth":
+		enter := make(chan struct{})
+		go func() {
+			reader := bufio.NewReader(os.Stdin)
+			for {
+				if _, err := reader.ReadString('\n'); err != nil {
+					return
+				}
+				enter <- struct{}{}
+			}
+		}()
+
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		defer signal.Stop(sig)
+
 		for _, val := range connItems {
-			fmt.Printf("%v: %v\n", val.WindowName(), GetAuthentication(val))
+			output, pw := GetAuthentication(val, printAuth)
+			fmt.Printf("%v: %v\n", val.WindowName(), output)
+			if pw != "" {
+				err := CopyToClipboard(pw, enter, sig)
+				if errors.Is(err, ErrInterrupted) {
+					fmt.Println("\tClipboard cleared, stopping")
+					break
+				}
+				if err != nil {
+					fmt.Printf("\tCould not use clipboard (%v), rerun with -print-auth to print the password\n", err)
+				}
+			}
 		}
 	case "Connect":
 		c := connItems[0]
@@ -150,27 +339,35 @@ func main() {
 			fmt.Printf("\nCould not reset tmux window: %v\n", err)
 		}
 	case "ReceiveFile":
-		remoteSrc, dest, err := menus.SendReceive()
+		req, err := menus.SendReceive(connItems, transfer.Receive)
 
-		if remoteSrc == "" || dest == "" || err != nil {
+		if len(req.Sources) == 0 || req.Dest == "" || err != nil {
 			break
 		}
 
-		destName := path.Clean(path.Join(dest, path.Base(remoteSrc)))
-		osCommand := []string{"scp", "-rp", "{{.FinalAddr}}:" + remoteSrc, destName + "_{{.CleanTitle}}"}
-		title := fmt.Sprintf("Copying %v on {{.WindowName}} to %v_{{.CleanTitle}}", remoteSrc, destName)
-		runcommand.RunConcurrentCommandWithOutput(connItems, title, osCommand)
+		jobs, warnings, err := transfer.PlanReceive(connItems, req.Sources, req.Dest, req.Layout, req.Globs, time.Now())
+		for _, warning := range warnings {
+			fmt.Printf("Warning: %v\n", warning)
+		}
+		if err != nil {
+			fmt.Printf("Cannot receive: %v\n", err)
+			break
+		}
+		ConfirmTransfers(jobs)
 
 	case "SendFile":
-		src, remoteDest, err := menus.SendReceive()
+		req, err := menus.SendReceive(connItems, transfer.Send)
 
-		if src == "" || remoteDest == "" || err != nil {
+		if len(req.Sources) == 0 || req.Dest == "" || err != nil {
 			break
 		}
 
-		osCommand := []string{"scp", "-rp", src, "{{.FinalAddr}}:" + remoteDest}
-		title := fmt.Sprintf("Copying %v to %v on {{.WindowName}}", src, remoteDest)
-		runcommand.RunConcurrentCommandWithOutput(connItems, title, osCommand)
+		jobs, err := transfer.PlanSend(connItems, req.Sources, req.Dest, req.Globs)
+		if err != nil {
+			fmt.Printf("Cannot send: %v\n", err)
+			break
+		}
+		ConfirmTransfers(jobs)
 
 	case "RunCommand":
 		// get command to run
@@ -185,6 +382,71 @@ func main() {
 		title := fmt.Sprintf("running %v on {{.WindowName}}", cmdToRun)
 		runcommand.RunConcurrentCommandWithOutput(connItems, title, osCommand)
 
+	case "Sync":
+		opts, ok, err := menus.SyncOptions()
+		if !ok || err != nil {
+			break
+		}
+		if err := opts.Validate(); err != nil {
+			fmt.Printf("Cannot sync: %v\n", err)
+			break
+		}
+
+		fmt.Print(rsync.Report(rsync.SyncHosts(connItems, opts), opts.DryRun))
+		if !opts.DryRun {
+			break
+		}
+
+		fmt.Print("Apply these changes? [y/N] ")
+		var answer string
+		fmt.Scanln(&answer)
+		if strings.ToLower(answer) != "y" {
+			break
+		}
+		opts.DryRun = false
+		fmt.Print(rsync.Report(rsync.SyncHosts(connItems, opts), opts.DryRun))
+
+	case "Tunnels":
+		if err := menus.Tunnels(connItems); err != nil {
+			fmt.Printf("Could not show tunnels: %v\n", err)
+		}
+
+	case "SocksProxy":
+		c := connItems[0]
+		if len(connItems) > 1 {
+			fmt.Printf("Can only handle one connection but multiple selected.\n\t Proxying through %v...\n", c.WindowName())
+		}
+		if err := menus.SocksProxy(c); err != nil {
+			fmt.Printf("Could not run SOCKS proxy: %v\n", err)
+		}
+
+	case "GatherFacts":
+		results := facts.GatherAll(connItems, facts.Probe())
+		fmt.Print(facts.Report(results))
+		if err := facts.Save(results); err != nil {
+			fmt.Printf("Could not save facts: %v\n", err)
+		}
+
+	case "Keyscan":
+		if err := hostkey.Keyscan(connItems, false); err != nil {
+			fmt.Printf("Could not record host keys: %v\n", err)
+		}
+
+	case "RotatePassword":
+		fmt.Println("Passwords will be changed on:")
+		for _, val := range connItems {
+			fmt.Printf("\t%v\n", val.WindowName())
+		}
+		fmt.Print("Continue? [y/N] ")
+		var answer string
+		fmt.Scanln(&answer)
+		if strings.ToLower(answer) != "y" {
+			break
+		}
+
+		results := rotate.RotateHosts(connItems, rotate.GetPolicy())
+		fmt.Print(rotate.Report(results))
+
 	}
 
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"os"
 	"strings"
@@ -135,11 +136,11 @@ func TestGetAuthentication(t *testing.T) {
 			},
 		},
 		{
-			expected:   "Temporary identity file is ",
+			expected:   "Identity file is " + tmpfile.Name(),
 			passphrase: passphrase,
-			exactMatch: false,
+			exactMatch: true,
 			item: connection.Item{
-				Name:    "encrypted identity file",
+				Name:    "encrypted file that is not a private key",
 				Checked: false,
 				Index:   5,
 				Conn: connection.Connection{
@@ -147,14 +148,29 @@ func TestGetAuthentication(t *testing.T) {
 				},
 			},
 		},
+		{
+			expected:   "Password is providedpassword",
+			exactMatch: true,
+			passphrase: passphrase,
+			item: connection.Item{
+				Name:    "password from provider",
+				Checked: false,
+				Index:   6,
+				Conn: connection.Connection{
+					Password: "exec:echo providedpassword",
+					PassFile: tmpfile.Name(),
+				},
+			},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.item.Name, func(t *testing.T) {
 			os.Setenv("GOSSH_PASSPHRASE", tt.passphrase)
 			defer os.Unsetenv("GOSSH_PASSPHRASE")
+			t.Setenv("SSH_AUTH_SOCK", "")
 
-			got := GetAuthentication(tt.item)
+			got, _ := GetAuthentication(tt.item, true)
 			if (got != tt.expected && tt.exactMatch) || (!strings.Contains(got, tt.expected) && !tt.exactMatch) {
 				t.Errorf("GetAuthentication() = %v, want %v", got, tt.expected)
 			}
@@ -226,3 +242,43 @@ func TestHandleTmux(t *testing.T) {
 	// cleanup tmux window
 	HandleTmux("")
 }
+
+func TestGetAuthenticationHidden(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	t.Setenv("GOSSH_TEST_PASSWORD", "providedpassword")
+
+	item := connection.Item{
+		Name: "provider password",
+		Conn: connection.Connection{Password: "exec:echo $GOSSH_TEST_PASSWORD"},
+	}
+
+	got, pw := GetAuthentication(item, false)
+	if strings.Contains(got, "providedpassword") {
+		t.Errorf("GetAuthentication() = %v, should not contain the password", got)
+	}
+	if pw != "providedpassword" {
+		t.Errorf("GetAuthentication() password = %v, want providedpassword", pw)
+	}
+
+	got, pw = GetAuthentication(item, true)
+	if got != "Password is providedpassword" || pw != "" {
+		t.Errorf("GetAuthentication(reveal) = %v, %v, want printed password only", got, pw)
+	}
+}
+
+func TestCopyToClipboard(t *testing.T) {
+	t.Setenv("GOSSH_CLIPBOARD", "osc52")
+
+	enter := make(chan struct{}, 1)
+	interrupt := make(chan os.Signal, 1)
+
+	enter <- struct{}{}
+	if err := CopyToClipboard("hunter2", enter, interrupt); err != nil {
+		t.Errorf("CopyToClipboard() after enter = %v, want nil", err)
+	}
+
+	interrupt <- os.Interrupt
+	if err := CopyToClipboard("hunter2", enter, interrupt); !errors.Is(err, ErrInterrupted) {
+		t.Errorf("CopyToClipboard() after ctrl+c = %v, want %v", err, ErrInterrupted)
+	}
+}
@@ -0,0 +1,92 @@
+package clipboard
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atotto/clipboard"
+	"github.com/aymanbagabas/go-osc52/v2"
+)
+
+const (
+	MethodSystem = "system"
+	MethodOSC52  = "osc52"
+
+	defaultTimeout = 45
+)
+
+// osc52Out is where OSC52 sequences are written, replaced in tests
+var osc52Out io.Writer = os.Stderr
+
+func GetTimeout() time.Duration {
+	timeout := defaultTimeout
+
+	timeoutEnv := os.Getenv("GOSSH_CLIPBOARD_TIMEOUT")
+	if timeoutEnv != "" {
+		parsed, err := strconv.Atoi(timeoutEnv)
+		if err == nil && parsed > 0 {
+			timeout = parsed
+		}
+	}
+
+	return time.Duration(timeout) * time.Second
+}
+
+// GetMethod uses GOSSH_CLIPBOARD when set. Otherwise OSC52 is used in ssh
+// sessions or when no system clipboard tool is available.
+func GetMethod() string {
+	switch strings.ToLower(os.Getenv("GOSSH_CLIPBOARD")) {
+	case MethodSystem:
+		return MethodSystem
+	case MethodOSC52:
+		return MethodOSC52
+	}
+
+	if os.Getenv("SSH_CONNECTION") != "" || os.Getenv("SSH_TTY") != "" || clipboard.Unsupported {
+		return MethodOSC52
+	}
+	return MethodSystem
+}
+
+func wrapOSC52(seq osc52.Sequence) osc52.Sequence {
+	if os.Getenv("TMUX") != "" {
+		seq = seq.Tmux()
+	} else if strings.HasPrefix(os.Getenv("TERM"), "screen") {
+		seq = seq.Screen()
+	}
+	return seq
+}
+
+func Copy(method string, s string) error {
+	if method == MethodOSC52 {
+		_, err := wrapOSC52(osc52.New(s)).WriteTo(osc52Out)
+		return err
+	}
+
+	if clipboard.Unsupported {
+		return errors.New("no system clipboard available (install xclip, xsel or wl-clipboard)")
+	}
+	return clipboard.WriteAll(s)
+}
+
+// Clear empties the clipboard. The system clipboard is only cleared if it
+// still holds s so anything copied since isn't lost.
+func Clear(method string, s string) error {
+	if method == MethodOSC52 {
+		_, err := wrapOSC52(osc52.Clear()).WriteTo(osc52Out)
+		return err
+	}
+
+	current, err := clipboard.ReadAll()
+	if err != nil {
+		return err
+	}
+	if current != s {
+		return nil
+	}
+	return clipboard.WriteAll("")
+}
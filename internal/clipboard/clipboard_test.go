@@ -0,0 +1,73 @@
+package clipboard
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		envValue string
+		expected time.Duration
+	}{
+		{name: "not set", envValue: "", expected: defaultTimeout * time.Second},
+		{name: "valid", envValue: "10", expected: 10 * time.Second},
+		{name: "invalid", envValue: "soon", expected: defaultTimeout * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GOSSH_CLIPBOARD_TIMEOUT", tt.envValue)
+			if got := GetTimeout(); got != tt.expected {
+				t.Errorf("GetTimeout() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGetMethod(t *testing.T) {
+	t.Setenv("GOSSH_CLIPBOARD", "osc52")
+	if got := GetMethod(); got != MethodOSC52 {
+		t.Errorf("GetMethod() = %v, want %v", got, MethodOSC52)
+	}
+
+	t.Setenv("GOSSH_CLIPBOARD", "system")
+	if got := GetMethod(); got != MethodSystem {
+		t.Errorf("GetMethod() = %v, want %v", got, MethodSystem)
+	}
+
+	t.Setenv("GOSSH_CLIPBOARD", "")
+	t.Setenv("SSH_CONNECTION", "10.0.0.1 22 10.0.0.2 22")
+	if got := GetMethod(); got != MethodOSC52 {
+		t.Errorf("GetMethod() over ssh = %v, want %v", got, MethodOSC52)
+	}
+}
+
+func TestCopyAndClearOSC52(t *testing.T) {
+	t.Setenv("TMUX", "")
+	t.Setenv("TERM", "xterm-256color")
+	var buf bytes.Buffer
+	osc52Out = &buf
+	defer func() { osc52Out = os.Stderr }()
+
+	if err := Copy(MethodOSC52, "hunter2"); err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	encoded := base64.StdEncoding.EncodeToString([]byte("hunter2"))
+	if !strings.Contains(buf.String(), "\x1b]52;c;"+encoded) {
+		t.Errorf("Copy() wrote %q, want OSC52 sequence with %v", buf.String(), encoded)
+	}
+
+	buf.Reset()
+	if err := Clear(MethodOSC52, "hunter2"); err != nil {
+		t.Fatalf("Clear() error = %v", err)
+	}
+	if strings.Contains(buf.String(), encoded) || !strings.Contains(buf.String(), "\x1b]52;c;") {
+		t.Errorf("Clear() wrote %q, want OSC52 clear sequence", buf.String())
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/encryption"
 	"github.com/nicknickel/gossh/internal/log"
 	"gopkg.in/yaml.v3"
 )
@@ -101,7 +102,10 @@ func ReadConnections() []list.Item {
 			// which allows for program to be called from any directory
 			keys := Keys(fc)
 			for _, key := range keys {
-				if fc[key].IdentityFile != "" && !filepath.IsAbs(fc[key].IdentityFile) {
+				tConn := fc[key]
+				tConn.Source = file
+				fc[key] = tConn
+				if fc[key].IdentityFile != "" && !filepath.IsAbs(fc[key].IdentityFile) && !encryption.IsSecretRef(fc[key].IdentityFile) {
 					p := filepath.Join(filepath.Dir(file), fc[key].IdentityFile)
 					tConn := fc[key]
 					tConn.IdentityFile = filepath.Clean(p)
@@ -113,6 +117,18 @@ func ReadConnections() []list.Item {
 					tConn.PassFile = filepath.Clean(p)
 					fc[key] = tConn
 				}
+				if fc[key].Totp != "" && !filepath.IsAbs(fc[key].Totp) && !encryption.IsSecretRef(fc[key].Totp) {
+					p := filepath.Join(filepath.Dir(file), fc[key].Totp)
+					tConn := fc[key]
+					tConn.Totp = filepath.Clean(p)
+					fc[key] = tConn
+				}
+				for ind, step := range fc[key].Automation {
+					if step.SendSecret != "" && !filepath.IsAbs(step.SendSecret) && !encryption.IsSecretRef(step.SendSecret) {
+						p := filepath.Join(filepath.Dir(file), step.SendSecret)
+						fc[key].Automation[ind].SendSecret = filepath.Clean(p)
+					}
+				}
 			}
 
 			maps.Copy(config, fc)
@@ -121,3 +137,87 @@ func ReadConnections() []list.Item {
 
 	return SortConns(config)
 }
+
+// FindConnection returns the connection with the given name, as read from
+// all config files
+func FindConnection(name string) (connection.Item, bool) {
+	for _, val := range ReadConnections() {
+		item := val.(connection.Item)
+		if item.Name == name {
+			return item, true
+		}
+	}
+	return connection.Item{}, false
+}
+
+// ConnectionFiles returns every config file that defines the connection, in
+// the order they are read
+func ConnectionFiles(name string) []string {
+	var found []string
+	seen := map[string]bool{}
+
+	for _, file := range ConfigFiles() {
+		// ./gossh.yml is read twice when gossh runs in the home directory
+		abs, err := filepath.Abs(file)
+		if err != nil || seen[abs] {
+			continue
+		}
+		seen[abs] = true
+
+		f, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+
+		fc := make(map[string]connection.Connection)
+		if err := yaml.Unmarshal(f, fc); err != nil {
+			continue
+		}
+		if _, ok := fc[name]; ok {
+			found = append(found, file)
+		}
+	}
+	return found
+}
+
+// FindConnectionFile returns the config file that defines the connection.
+// Later files override earlier ones so the last match wins.
+func FindConnectionFile(name string) (string, error) {
+	found := ConnectionFiles(name)
+	if len(found) == 0 {
+		return "", fmt.Errorf("connection %v not found in any config file", name)
+	}
+	return found[len(found)-1], nil
+}
+
+// SetConnectionValue updates a single key of a connection in place, keeping
+// the rest of the file (ordering, comments, formatting) intact
+func SetConnectionValue(file string, name string, key string, value string) error {
+	s, err := loadSource(file, false)
+	if err != nil {
+		return err
+	}
+	if err := s.setKey(name, key, scalar(value)); err != nil {
+		return err
+	}
+	return s.write()
+}
+
+// RelativeToConfig returns p relative to the config file directory when it
+// sits beneath it, matching how relative passfile/identity paths are resolved
+func RelativeToConfig(file string, p string) string {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+	dir, err := filepath.Abs(filepath.Dir(file))
+	if err != nil {
+		return abs
+	}
+
+	rel, err := filepath.Rel(dir, abs)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return abs
+	}
+	return rel
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/nicknickel/gossh/internal/connection"
@@ -106,4 +107,81 @@ func TestSortConns(t *testing.T) {
 	}
 }
 
+func TestSetConnectionValue(t *testing.T) {
+	dir := t.TempDir()
+	file := dir + "/gossh.yml"
+	original := `# servers
+web:
+  address: 1.2.3.4 # primary
+  passfile: old.age
+db:
+  address: 2.3.4.5
+`
+	if err := os.WriteFile(file, []byte(original), 0640); err != nil {
+		t.Fatalf("Could not write config: %v", err)
+	}
+
+	if err := SetConnectionValue(file, "web", "passfile", "web.pass.age"); err != nil {
+		t.Fatalf("SetConnectionValue() error = %v", err)
+	}
+	if err := SetConnectionValue(file, "db", "identity", "db.key.age"); err != nil {
+		t.Fatalf("SetConnectionValue() error = %v", err)
+	}
+	if err := SetConnectionValue(file, "missing", "passfile", "x"); err == nil {
+		t.Errorf("SetConnectionValue() for missing connection expected error")
+	}
+
+	data, _ := os.ReadFile(file)
+	got := string(data)
+	for _, want := range []string{"# servers", "# primary", "passfile: web.pass.age", "identity: db.key.age"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("config missing %q:\n%v", want, got)
+		}
+	}
+	if strings.Contains(got, "old.age") {
+		t.Errorf("config still contains old passfile:\n%v", got)
+	}
+	if fi, _ := os.Stat(file); fi.Mode().Perm() != 0640 {
+		t.Errorf("config mode = %v, want 0640", fi.Mode().Perm())
+	}
+}
+
+func TestFindConnection(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GOSSH_CONFIGDIR", dir)
+	os.WriteFile(dir+"/gossh.yml", []byte("web:\n  address: 1.2.3.4\n  passfile: web.age\n"), 0600)
+
+	item, ok := FindConnection("web")
+	if !ok || item.Conn.Address != "1.2.3.4" {
+		t.Errorf("FindConnection(web) = %v, %v, want the web connection", item, ok)
+	}
+	if item.Conn.PassFile != dir+"/web.age" {
+		t.Errorf("FindConnection(web) passfile = %v, want it resolved against the config file", item.Conn.PassFile)
+	}
+	if _, ok := FindConnection("missing"); ok {
+		t.Errorf("FindConnection(missing) found a connection")
+	}
+}
+
+func TestRelativeToConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		p        string
+		expected string
+	}{
+		{name: "beneath config", file: "/etc/gossh/gossh.yml", p: "/etc/gossh/secrets/a.age", expected: "secrets/a.age"},
+		{name: "outside config", file: "/etc/gossh/gossh.yml", p: "/tmp/a.age", expected: "/tmp/a.age"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RelativeToConfig(tt.file, tt.p); got != tt.expected {
+				t.Errorf("RelativeToConfig() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
 // Note: TestReadConnections would require mocking file system, which is more complex. Skipping for now or implement with test files.
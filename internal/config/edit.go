@@ -0,0 +1,610 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/nicknickel/gossh/internal/encryption"
+	"gopkg.in/yaml.v3"
+)
+
+// EditableKeys are the keys of a connection edited from the TUI, in the order
+// they are added to new connections. Automation steps and forwards are only
+// edited in the file.
+var EditableKeys = []string{"address", "user", "comment", "identity", "passfile", "password", "totp", "sshprogram", "hostkey", "tags", "group", "folder"}
+
+// source is a config file as written along with the document parsed from it.
+// Edits replace only the lines of what changed, so the indentation, blank
+// lines and comments of the rest of the file are kept.
+type source struct {
+	file  string
+	lines []string
+	doc   *yaml.Node
+	// indent is the indentation of the connections' keys, used for the
+	// lines gossh writes
+	indent int
+}
+
+// loadSource reads the config file, or starts an empty one when create is
+// set and the file does not exist yet
+func loadSource(file string, create bool) (*source, error) {
+	f, err := os.ReadFile(file)
+	if os.IsNotExist(err) && create {
+		f, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &source{file: file}
+	if err := s.parse(string(f)); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// parse replaces the lines and the document with those of the text
+func (s *source) parse(text string) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(text), &doc); err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("config file %v is not a mapping of connections", s.file)
+	}
+	s.doc = &doc
+	s.lines = nil
+	if text = strings.TrimRight(text, "\n"); text != "" {
+		s.lines = strings.Split(text, "\n")
+	}
+
+	s.indent = 2
+	for i := 1; i < len(root.Content); i += 2 {
+		if conn := root.Content[i]; conn.Kind == yaml.MappingNode && conn.Style&yaml.FlowStyle == 0 && len(conn.Content) > 0 {
+			s.indent = max(1, conn.Content[0].Column-root.Content[i-1].Column)
+			break
+		}
+	}
+
+	// a file written as one flow mapping has no lines per connection
+	if root.Style&yaml.FlowStyle != 0 {
+		root.Style = 0
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(s.indent)
+		if err := enc.Encode(&doc); err != nil {
+			return err
+		}
+		return s.parse(buf.String())
+	}
+	return nil
+}
+
+func (s *source) text() string {
+	if len(s.lines) == 0 {
+		return ""
+	}
+	return strings.Join(s.lines, "\n") + "\n"
+}
+
+// replace swaps the lines from to to, both included, for lines and parses
+// the result. to is from-1 to insert the lines before from.
+func (s *source) replace(from int, to int, lines []string) error {
+	return s.parse(strings.Join(slices.Concat(s.lines[:from], lines, s.lines[to+1:]), "\n"))
+}
+
+// write replaces the file with the edited lines through a temporary file,
+// with the file's permissions or readable by the user only when it is new
+func (s *source) write() error {
+	file := s.file
+	perm := os.FileMode(0600)
+	if fi, err := os.Stat(file); err == nil {
+		perm = fi.Mode().Perm()
+		// replace the file a link points to rather than the link
+		if file, err = filepath.EvalSymlinks(file); err != nil {
+			return err
+		}
+	} else if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.WriteString(s.text()); err != nil {
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), file)
+}
+
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+func blank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// entryEnd is the last line of a mapping entry: the key's line and the lines
+// after it indented deeper, or the items of a sequence at the key's own
+// indentation
+func (s *source) entryEnd(key *yaml.Node, value *yaml.Node) int {
+	col := key.Column - 1
+	blockSeq := value.Kind == yaml.SequenceNode && value.Style&yaml.FlowStyle == 0
+	end := key.Line - 1
+	for i := end + 1; i < len(s.lines); i++ {
+		line := s.lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+		case indentation(line) > col, blockSeq && indentation(line) == col && strings.HasPrefix(trimmed, "-"):
+			end = i
+		default:
+			return end
+		}
+	}
+	return end
+}
+
+// headStart is the first line of the comments directly above the key
+func (s *source) headStart(key *yaml.Node) int {
+	start := key.Line - 1
+	for start > 0 {
+		line := s.lines[start-1]
+		if indentation(line) != key.Column-1 || !strings.HasPrefix(strings.TrimSpace(line), "#") {
+			break
+		}
+		start--
+	}
+	return start
+}
+
+// tokenEnd is where the key starting at from ends on the line
+func tokenEnd(line string, from int) int {
+	switch line[from] {
+	case '"':
+		for i := from + 1; i < len(line); i++ {
+			if line[i] == '\\' {
+				i++
+			} else if line[i] == '"' {
+				return i + 1
+			}
+		}
+	case '\'':
+		for i := from + 1; i < len(line); i++ {
+			if line[i] == '\'' {
+				if i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					continue
+				}
+				return i + 1
+			}
+		}
+	default:
+		for i := from; i < len(line); i++ {
+			if line[i] == ':' && (i+1 == len(line) || line[i+1] == ' ') {
+				return strings.LastIndexFunc(line[:i], func(r rune) bool { return r != ' ' }) + 1
+			}
+		}
+	}
+	return len(line)
+}
+
+// valueSpan is where a value written on its key's line starts and ends,
+// before any comment. ok is false for values spanning lines.
+func (s *source) valueSpan(key *yaml.Node, value *yaml.Node) (int, int, bool) {
+	if value.Line != key.Line || s.entryEnd(key, value) != key.Line-1 ||
+		value.Kind == yaml.MappingNode || value.Kind == yaml.SequenceNode && value.Style&yaml.FlowStyle == 0 ||
+		value.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+		return 0, 0, false
+	}
+	line := s.lines[key.Line-1]
+	end := len(line)
+	for _, comment := range []string{value.LineComment, key.LineComment} {
+		if ind := strings.LastIndex(line, comment); comment != "" && ind > value.Column-1 {
+			end = ind
+			break
+		}
+	}
+	return value.Column - 1, len(strings.TrimRight(line[:end], " ")), true
+}
+
+// inline is the node written on one line, ok is false when it needs more
+func inline(n *yaml.Node) (string, bool) {
+	out, err := yaml.Marshal(n)
+	text := strings.TrimSuffix(string(out), "\n")
+	return text, err == nil && !strings.Contains(text, "\n")
+}
+
+// render writes the mapping entry at the indentation col
+func (s *source) render(col int, key *yaml.Node, value *yaml.Node) []string {
+	// the comments above the key and after the entry stay where they are
+	k, v := *key, *value
+	k.HeadComment, k.FootComment, v.FootComment = "", "", ""
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(s.indent)
+	enc.Encode(&yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{&k, &v}})
+	enc.Close()
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	for ind, line := range lines {
+		if line != "" {
+			lines[ind] = strings.Repeat(" ", col) + line
+		}
+	}
+	return lines
+}
+
+func scalar(value string) *yaml.Node {
+	n := &yaml.Node{}
+	n.SetString(value)
+	return n
+}
+
+// connectionIndex is the index of the connection's key in the root mapping,
+// -1 when the file does not define it
+func connectionIndex(root *yaml.Node, name string) int {
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// connection is the key and value of the connection. A connection written
+// as a flow mapping is written again as a block mapping first so each of its
+// keys has a line of its own.
+func (s *source) connection(name string) (*yaml.Node, *yaml.Node, error) {
+	root := s.doc.Content[0]
+	ind := connectionIndex(root, name)
+	if ind < 0 {
+		return nil, nil, fmt.Errorf("connection %v not found in %v", name, s.file)
+	}
+	key, conn := root.Content[ind], root.Content[ind+1]
+	if conn.Kind == yaml.MappingNode && conn.Style&yaml.FlowStyle != 0 && len(conn.Content) > 0 {
+		conn.Style = 0
+		if err := s.replace(key.Line-1, s.entryEnd(key, conn), s.render(key.Column-1, key, conn)); err != nil {
+			return nil, nil, err
+		}
+		return s.connection(name)
+	}
+	return key, conn, nil
+}
+
+// setKey sets the connection's key to the value, or removes it when value
+// is nil, changing only the lines of that key
+func (s *source) setKey(name string, k string, value *yaml.Node) error {
+	connKey, conn, err := s.connection(name)
+	if err != nil {
+		return err
+	}
+	if conn.Kind == yaml.MappingNode && len(conn.Content) > 0 {
+		for i := 0; i+1 < len(conn.Content); i += 2 {
+			key, old := conn.Content[i], conn.Content[i+1]
+			if key.Value != k {
+				continue
+			}
+			start, end := key.Line-1, s.entryEnd(key, old)
+			if value == nil {
+				return s.replace(s.headStart(key), end, nil)
+			}
+			if from, to, ok := s.valueSpan(key, old); ok {
+				if text, ok := inline(value); ok {
+					line := s.lines[start]
+					return s.replace(start, start, []string{line[:from] + text + line[to:]})
+				}
+			}
+			return s.replace(start, end, s.render(key.Column-1, key, value))
+		}
+		if value == nil {
+			return nil
+		}
+		last := conn.Content[len(conn.Content)-2]
+		end := s.entryEnd(last, conn.Content[len(conn.Content)-1])
+		return s.replace(end+1, end, s.render(last.Column-1, scalar(k), value))
+	}
+	if value == nil {
+		return nil
+	}
+	// a connection without keys is written again with its first
+	mapping := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{scalar(k), value}}
+	return s.replace(connKey.Line-1, s.entryEnd(connKey, conn), s.render(connKey.Column-1, connKey, mapping))
+}
+
+// rename changes the name of the connection on its line
+func (s *source) rename(name string, newName string) error {
+	key, _, err := s.connection(name)
+	if err != nil {
+		return err
+	}
+	line := s.lines[key.Line-1]
+	from := key.Column - 1
+	text, _ := inline(scalar(newName))
+	return s.replace(key.Line-1, key.Line-1, []string{line[:from] + text + line[tokenEnd(line, from):]})
+}
+
+// separated reports whether a blank line comes before the connection's key
+// and comments, as between connections in files that separate them
+func (s *source) separated(key *yaml.Node) bool {
+	start := s.headStart(key)
+	return start > 0 && blank(s.lines[start-1])
+}
+
+// appendConnection adds the connection at the end of the file
+func (s *source) appendConnection(name string, conn *yaml.Node) error {
+	lines := s.render(0, scalar(name), conn)
+	if root := s.doc.Content[0]; len(root.Content) > 2 && s.separated(root.Content[len(root.Content)-2]) {
+		lines = append([]string{""}, lines...)
+	}
+	return s.replace(len(s.lines), len(s.lines)-1, lines)
+}
+
+// splitTags reads the comma separated tags
+func splitTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// fieldNode is the value of an editable key, tags as a flow sequence
+func fieldNode(key string, value string) *yaml.Node {
+	if key != "tags" {
+		return scalar(value)
+	}
+	n := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Style: yaml.FlowStyle}
+	for _, tag := range splitTags(value) {
+		n.Content = append(n.Content, scalar(tag))
+	}
+	return n
+}
+
+// setField sets or removes an editable key, leaving values that did not
+// change untouched so their formatting is kept
+func (s *source) setField(name string, key string, value string) error {
+	_, conn, err := s.connection(name)
+	if err != nil {
+		return err
+	}
+	current, set := "", false
+	for i := 0; i+1 < len(conn.Content); i += 2 {
+		if conn.Content[i].Value == key {
+			current, set = nodeValue(conn.Content[i+1]), true
+		}
+	}
+	switch {
+	case value == "" && !set, set && value == current:
+		return nil
+	case value == "":
+		return s.setKey(name, key, nil)
+	}
+	return s.setKey(name, key, fieldNode(key, value))
+}
+
+// nodeValue is a scalar's value, or a sequence's values joined with ", "
+func nodeValue(n *yaml.Node) string {
+	if n.Kind != yaml.SequenceNode {
+		return n.Value
+	}
+	var values []string
+	for _, c := range n.Content {
+		values = append(values, c.Value)
+	}
+	return strings.Join(values, ", ")
+}
+
+// ConnectionValues reads the editable keys of the connection as written in
+// the file, with relative paths unresolved
+func ConnectionValues(file string, name string) (map[string]string, error) {
+	s, err := loadSource(file, false)
+	if err != nil {
+		return nil, err
+	}
+	_, conn, err := s.connection(name)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	for i := 0; i+1 < len(conn.Content); i += 2 {
+		values[conn.Content[i].Value] = nodeValue(conn.Content[i+1])
+	}
+	return values, nil
+}
+
+// SaveConnection writes the editable keys of the connection, renaming
+// oldName to name, or adds the connection at the end of the file when
+// oldName is empty. Only the lines of keys that changed are written, keys
+// with empty values are removed.
+func SaveConnection(file string, oldName string, name string, values map[string]string) error {
+	s, err := loadSource(file, oldName == "")
+	if err != nil {
+		return err
+	}
+	root := s.doc.Content[0]
+	if oldName != name && connectionIndex(root, name) >= 0 {
+		return fmt.Errorf("connection %v already exists in %v", name, file)
+	}
+
+	if oldName == "" {
+		conn := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, key := range EditableKeys {
+			if value := strings.TrimSpace(values[key]); value != "" {
+				conn.Content = append(conn.Content, scalar(key), fieldNode(key, value))
+			}
+		}
+		if err := s.appendConnection(name, conn); err != nil {
+			return err
+		}
+		return s.write()
+	}
+
+	if oldName != name {
+		if err := s.rename(oldName, name); err != nil {
+			return err
+		}
+	}
+	for _, key := range EditableKeys {
+		if err := s.setField(name, key, strings.TrimSpace(values[key])); err != nil {
+			return err
+		}
+	}
+	return s.write()
+}
+
+// DuplicateConnection copies the lines of the connection, with every key, to
+// newName right after it
+func DuplicateConnection(file string, name string, newName string) error {
+	s, err := loadSource(file, false)
+	if err != nil {
+		return err
+	}
+	if connectionIndex(s.doc.Content[0], newName) >= 0 {
+		return fmt.Errorf("connection %v already exists in %v", newName, file)
+	}
+	key, conn, err := s.connection(name)
+	if err != nil {
+		return err
+	}
+
+	start, end := key.Line-1, s.entryEnd(key, conn)
+	copied := slices.Clone(s.lines[start : end+1])
+	from := key.Column - 1
+	text, _ := inline(scalar(newName))
+	copied[0] = copied[0][:from] + text + copied[0][tokenEnd(copied[0], from):]
+	if s.separated(key) {
+		copied = append([]string{""}, copied...)
+	}
+	if err := s.replace(end+1, end, copied); err != nil {
+		return err
+	}
+	return s.write()
+}
+
+// DeleteConnection removes the connection and the comments above it from
+// the file
+func DeleteConnection(file string, name string) error {
+	s, err := loadSource(file, false)
+	if err != nil {
+		return err
+	}
+	key, conn, err := s.connection(name)
+	if err != nil {
+		return err
+	}
+	from, to := s.headStart(key), s.entryEnd(key, conn)
+	// keep a single blank line between the connections around it
+	if to+1 < len(s.lines) && blank(s.lines[to+1]) && (from == 0 || blank(s.lines[from-1])) {
+		to++
+	}
+	if err := s.replace(from, to, nil); err != nil {
+		return err
+	}
+	return s.write()
+}
+
+// Writable is why connections from the file can not be changed by gossh,
+// nil when they can
+func Writable(file string) error {
+	if file == "" {
+		return errors.New("not read from a gossh config file")
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("%v is read-only: %w", file, err)
+	}
+	return f.Close()
+}
+
+// DefaultConfigFile is where new connections go when there is no other
+// choice: the first config file that exists, else ~/.config/gossh/gossh.yml
+func DefaultConfigFile() string {
+	for _, file := range ConfigFiles() {
+		if fi, err := os.Stat(file); err == nil && fi.Mode().IsRegular() {
+			return file
+		}
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "gossh.yml"
+	}
+	return filepath.Join(home, ".config", "gossh", "gossh.yml")
+}
+
+// resolvePath is p as ReadConnections resolves it, relative to the file
+func resolvePath(file string, p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Clean(filepath.Join(filepath.Dir(file), p))
+}
+
+// ValidateConnection checks the editable keys of a connection to be written
+// to the file
+func ValidateConnection(file string, values map[string]string) error {
+	var errs []error
+	field := func(key string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%v: %v", key, fmt.Sprintf(format, args...)))
+	}
+
+	if addr := strings.TrimSpace(values["address"]); addr != "" {
+		if strings.ContainsAny(addr, " \t@") {
+			field("address", "%q is not a host or host:port", addr)
+		} else if strings.Contains(addr, ":") && net.ParseIP(strings.Trim(addr, "[]")) == nil {
+			_, port, err := net.SplitHostPort(addr)
+			if n, perr := strconv.Atoi(port); err != nil || perr != nil || n < 1 || n > 65535 {
+				field("address", "%q is not a host or host:port", addr)
+			}
+		}
+	}
+	if user := strings.TrimSpace(values["user"]); strings.ContainsAny(user, " \t@") {
+		field("user", "%q contains a space or @", user)
+	}
+
+	for _, key := range []string{"identity", "passfile", "totp"} {
+		p := strings.TrimSpace(values[key])
+		if p == "" || (key != "passfile" && encryption.IsSecretRef(p)) {
+			continue
+		}
+		if _, err := os.Stat(resolvePath(file, p)); err != nil {
+			field(key, "%v does not exist", resolvePath(file, p))
+		}
+	}
+	if pw := strings.TrimSpace(values["password"]); pw != "" && !encryption.IsSecretRef(pw) {
+		field("password", "must be a secret reference such as pass:path, use passfile for files")
+	}
+	if hk := strings.TrimSpace(values["hostkey"]); hk != "" && !strings.HasPrefix(hk, "SHA256:") {
+		field("hostkey", "must be a SHA256: fingerprint")
+	}
+	for _, tag := range splitTags(values["tags"]) {
+		if strings.ContainsAny(tag, " \t") {
+			field("tags", "%q contains a space", tag)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,248 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const editOriginal = `# servers
+web:
+  # the primary
+  address: 1.2.3.4 # primary
+  user: ops
+  tags: [prod, web]
+  forwards:
+    - type: dynamic
+      listen: 1080
+db:
+  address: 2.3.4.5
+`
+
+func TestSaveConnection(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "gossh.yml")
+	os.WriteFile(file, []byte(editOriginal), 0640)
+
+	values, err := ConnectionValues(file, "web")
+	if err != nil || values["address"] != "1.2.3.4" || values["tags"] != "prod, web" {
+		t.Fatalf("ConnectionValues() = %v, %v", values, err)
+	}
+
+	values["user"] = ""
+	values["comment"] = "front end"
+	values["tags"] = "prod, web, eu"
+	if err := SaveConnection(file, "web", "web1", values); err != nil {
+		t.Fatalf("SaveConnection() err = %v", err)
+	}
+	if err := SaveConnection(file, "", "cache", map[string]string{"address": "2222", "tags": "lab"}); err != nil {
+		t.Fatalf("SaveConnection() new err = %v", err)
+	}
+	if err := SaveConnection(file, "db", "web1", map[string]string{}); err == nil {
+		t.Errorf("SaveConnection() renamed over an existing connection")
+	}
+	if err := SaveConnection(file, "missing", "missing", map[string]string{}); err == nil {
+		t.Errorf("SaveConnection() of a missing connection should fail")
+	}
+
+	data, _ := os.ReadFile(file)
+	expected := `# servers
+web1:
+  # the primary
+  address: 1.2.3.4 # primary
+  tags: [prod, web, eu]
+  forwards:
+    - type: dynamic
+      listen: 1080
+  comment: front end
+db:
+  address: 2.3.4.5
+cache:
+  address: "2222"
+  tags: [lab]
+`
+	if string(data) != expected {
+		t.Errorf("config =\n%v\nwant\n%v", string(data), expected)
+	}
+	if fi, _ := os.Stat(file); fi.Mode().Perm() != 0640 {
+		t.Errorf("config mode = %v, want 0640", fi.Mode().Perm())
+	}
+}
+
+func TestSaveConnection_NewFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "gossh", "gossh.yml")
+	if err := SaveConnection(file, "", "web", map[string]string{"address": "1.2.3.4"}); err != nil {
+		t.Fatalf("SaveConnection() err = %v", err)
+	}
+	data, _ := os.ReadFile(file)
+	if string(data) != "web:\n  address: 1.2.3.4\n" {
+		t.Errorf("config =\n%v", string(data))
+	}
+	if fi, _ := os.Stat(file); fi.Mode().Perm() != 0600 {
+		t.Errorf("config mode = %v, want 0600", fi.Mode().Perm())
+	}
+}
+
+func TestDuplicateAndDeleteConnection(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "gossh.yml")
+	os.WriteFile(file, []byte(editOriginal), 0600)
+
+	if err := DuplicateConnection(file, "web", "web2"); err != nil {
+		t.Fatalf("DuplicateConnection() err = %v", err)
+	}
+	if err := DuplicateConnection(file, "web", "db"); err == nil {
+		t.Errorf("DuplicateConnection() over an existing connection should fail")
+	}
+	if err := DeleteConnection(file, "web"); err != nil {
+		t.Fatalf("DeleteConnection() err = %v", err)
+	}
+	if err := DeleteConnection(file, "web"); err == nil {
+		t.Errorf("DeleteConnection() of a missing connection should fail")
+	}
+
+	data, _ := os.ReadFile(file)
+	got := string(data)
+	if !strings.HasPrefix(got, "web2:\n") || !strings.Contains(got, "listen: 1080") || !strings.Contains(got, "db:") || strings.Contains(got, "web:") {
+		t.Errorf("config =\n%v", got)
+	}
+}
+
+const editFormatted = `# production hosts
+
+web1:
+    # the public web server
+    address: 10.0.0.5:2222    # behind the lb
+    user: 'ops'
+    tags: [prod, web]
+
+    automation:
+        -   expect: "\\$"
+            send: sudo -i
+
+# databases
+db1:
+    address: db.internal
+`
+
+func TestSaveConnection_KeepsFormatting(t *testing.T) {
+	tests := []struct {
+		name     string
+		edit     func(file string) error
+		expected string
+	}{
+		{name: "unchanged", edit: func(file string) error {
+			values, _ := ConnectionValues(file, "web1")
+			return SaveConnection(file, "web1", "web1", values)
+		}, expected: editFormatted},
+		{name: "address", edit: func(file string) error {
+			values, _ := ConnectionValues(file, "web1")
+			values["address"] = "10.0.0.6:2222"
+			return SaveConnection(file, "web1", "web1", values)
+		}, expected: strings.Replace(editFormatted, "10.0.0.5:2222 ", "10.0.0.6:2222 ", 1)},
+		{name: "rename and add", edit: func(file string) error {
+			values, _ := ConnectionValues(file, "db1")
+			values["user"] = "postgres"
+			return SaveConnection(file, "db1", "db2", values)
+		}, expected: strings.Replace(editFormatted, "db1:\n    address: db.internal\n", "db2:\n    address: db.internal\n    user: postgres\n", 1)},
+		{name: "remove", edit: func(file string) error {
+			values, _ := ConnectionValues(file, "web1")
+			values["tags"] = ""
+			return SaveConnection(file, "web1", "web1", values)
+		}, expected: strings.Replace(editFormatted, "    tags: [prod, web]\n", "", 1)},
+		{name: "pin", edit: func(file string) error {
+			return SetConnectionValue(file, "web1", "hostkey", "SHA256:abc")
+		}, expected: strings.Replace(editFormatted, "            send: sudo -i\n", "            send: sudo -i\n    hostkey: SHA256:abc\n", 1)},
+		{name: "delete", edit: func(file string) error {
+			return DeleteConnection(file, "web1")
+		}, expected: "# production hosts\n\n# databases\ndb1:\n    address: db.internal\n"},
+		{name: "add", edit: func(file string) error {
+			return SaveConnection(file, "", "cache", map[string]string{"address": "cache.internal", "tags": "lab"})
+		}, expected: editFormatted + "\ncache:\n    address: cache.internal\n    tags: [lab]\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "gossh.yml")
+			os.WriteFile(file, []byte(editFormatted), 0600)
+			if err := tt.edit(file); err != nil {
+				t.Fatalf("edit err = %v", err)
+			}
+			data, _ := os.ReadFile(file)
+			if string(data) != tt.expected {
+				t.Errorf("config =\n%v\nwant\n%v", string(data), tt.expected)
+			}
+			if entries, _ := os.ReadDir(filepath.Dir(file)); len(entries) != 1 {
+				t.Errorf("temporary files left: %v", entries)
+			}
+		})
+	}
+}
+
+func TestSaveConnection_FlowStyle(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "gossh.yml")
+	os.WriteFile(file, []byte("web1: {address: 1.2.3.4, user: ops}\ndb1: {}\n"), 0600)
+	if err := SetConnectionValue(file, "web1", "user", "root"); err != nil {
+		t.Fatalf("SetConnectionValue() err = %v", err)
+	}
+	if err := SetConnectionValue(file, "db1", "address", "2.3.4.5"); err != nil {
+		t.Fatalf("SetConnectionValue() err = %v", err)
+	}
+	data, _ := os.ReadFile(file)
+	if expected := "web1:\n  address: 1.2.3.4\n  user: root\ndb1:\n  address: 2.3.4.5\n"; string(data) != expected {
+		t.Errorf("config =\n%v\nwant\n%v", string(data), expected)
+	}
+}
+
+func TestValidateConnection(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "gossh.yml")
+	os.WriteFile(filepath.Join(dir, "key.pem"), []byte("key"), 0600)
+
+	tests := []struct {
+		name   string
+		values map[string]string
+		errors []string
+	}{
+		{name: "empty", values: map[string]string{}},
+		{name: "valid", values: map[string]string{"address": "web1.example.com:2222", "user": "ops", "identity": "key.pem",
+			"password": "pass:infra/web1", "totp": "op://infra/web1/totp", "hostkey": "SHA256:abc", "tags": "prod, web"}},
+		{name: "ipv6", values: map[string]string{"address": "fe80::1"}},
+		{name: "bad port", values: map[string]string{"address": "web1:99999"}, errors: []string{"address"}},
+		{name: "spaces", values: map[string]string{"address": "web 1", "user": "a b", "tags": "prod web"}, errors: []string{"address", "user", "tags"}},
+		{name: "missing files", values: map[string]string{"identity": "missing.pem", "passfile": "pass:web1"}, errors: []string{"identity", "passfile"}},
+		{name: "plain password", values: map[string]string{"password": "hunter2"}, errors: []string{"password"}},
+		{name: "hostkey", values: map[string]string{"hostkey": "MD5:aa"}, errors: []string{"hostkey"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateConnection(file, tt.values)
+			if (err != nil) != (len(tt.errors) > 0) {
+				t.Fatalf("ValidateConnection() = %v, want errors for %v", err, tt.errors)
+			}
+			for _, key := range tt.errors {
+				if !strings.Contains(err.Error(), key+":") {
+					t.Errorf("ValidateConnection() = %v, missing %v", err, key)
+				}
+			}
+		})
+	}
+}
+
+func TestWritable(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "gossh.yml")
+	os.WriteFile(file, []byte(editOriginal), 0600)
+	if err := Writable(file); err != nil {
+		t.Errorf("Writable() = %v", err)
+	}
+	if err := Writable(""); err == nil {
+		t.Errorf("connections without a config file should not be writable")
+	}
+	os.Chmod(file, 0400)
+	if os.Geteuid() != 0 {
+		if err := Writable(file); err == nil {
+			t.Errorf("read-only file is writable")
+		}
+	}
+}
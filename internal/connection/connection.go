@@ -1,14 +1,86 @@
 package connection
 
-import "strings"
+import (
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AutomationStep sends text (or a secret) once the expect regex matches the
+// output. Steps run in order after login.
+type AutomationStep struct {
+	Expect     string `yaml:"expect"`
+	Send       string `yaml:"send,omitempty"`
+	SendSecret string `yaml:"sendsecret,omitempty"`
+}
+
+// Forward is a port forward started in the background by the tunnel manager.
+// Type is local (-L), remote (-R) or dynamic (-D, a SOCKS proxy).
+type Forward struct {
+	Name   string `yaml:"name,omitempty"`
+	Type   string `yaml:"type"`
+	Listen string `yaml:"listen"`
+	Target string `yaml:"target,omitempty"`
+}
+
+// Label is the forward's name, or its type and listen address when unnamed
+func (f Forward) Label() string {
+	if f.Name != "" {
+		return f.Name
+	}
+	return f.Type + "-" + f.Listen
+}
 
 type Connection struct {
-	Address      string `yaml:"address,omitempty"`
-	User         string `yaml:"user,omitempty"`
-	Description  string `yaml:"comment,omitempty"`
-	IdentityFile string `yaml:"identity,omitempty"`
-	PassFile     string `yaml:"passfile,omitempty"`
-	SshProgram   string `yaml:"sshprogram,omitempty"`
+	Address      string           `yaml:"address,omitempty"`
+	User         string           `yaml:"user,omitempty"`
+	Description  string           `yaml:"comment,omitempty"`
+	IdentityFile string           `yaml:"identity,omitempty"`
+	PassFile     string           `yaml:"passfile,omitempty"`
+	Password     string           `yaml:"password,omitempty"`
+	Totp         string           `yaml:"totp,omitempty"`
+	Automation   []AutomationStep `yaml:"automation,omitempty"`
+	SshProgram   string           `yaml:"sshprogram,omitempty"`
+	Forwards     []Forward        `yaml:"forwards,omitempty"`
+	HostKey      string           `yaml:"hostkey,omitempty"`
+	Tags         []string         `yaml:"tags,omitempty"`
+	Group        string           `yaml:"group,omitempty"`
+	Folder       string           `yaml:"folder,omitempty"`
+
+	// Source is the config file the connection was read from
+	Source string `yaml:"-"`
+}
+
+const (
+	HealthUp    = "up"
+	HealthDown  = "down"
+	HealthNoSSH = "nossh"
+)
+
+// Health is the last reachability check of a connection, empty when it has
+// not been checked
+type Health struct {
+	State   string
+	Latency time.Duration
+	Checked time.Time
+	Err     string
+}
+
+// Badge is a status glyph with the latency, or why the host is not reachable
+func (h Health) Badge() string {
+	switch h.State {
+	case HealthUp:
+		return fmt.Sprintf("● %vms", h.Latency.Milliseconds())
+	case HealthNoSSH:
+		return "◐ no ssh"
+	case HealthDown:
+		return "○ down"
+	}
+	return ""
 }
 
 type Item struct {
@@ -16,6 +88,51 @@ type Item struct {
 	Conn    Connection
 	Checked bool
 	Index   int
+	Health  Health
+	// Facts gathered from the host, usable in command templates as
+	// {{.Fact "os"}}
+	Facts         map[string]string
+	FactsGathered time.Time
+	Favorite      bool
+}
+
+// Fact is a gathered fact, empty when unknown
+func (i Item) Fact(key string) string {
+	return i.Facts[key]
+}
+
+func formatUptime(seconds string) string {
+	n, err := strconv.Atoi(seconds)
+	if err != nil {
+		return seconds
+	}
+	d := time.Duration(n) * time.Second
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("up %vd", int(d.Hours()/24))
+	case d >= time.Hour:
+		return fmt.Sprintf("up %vh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("up %vm", int(d.Minutes()))
+	}
+}
+
+// FactsSummary is the OS, kernel, uptime and addresses gathered from the host
+func (i Item) FactsSummary() string {
+	var parts []string
+	if release := strings.TrimSpace(i.Fact("os") + " " + i.Fact("version")); release != "" {
+		parts = append(parts, release)
+	}
+	if k := i.Fact("kernel"); k != "" && k != i.Fact("version") {
+		parts = append(parts, k)
+	}
+	if up := i.Fact("uptime"); up != "" {
+		parts = append(parts, formatUptime(up))
+	}
+	if ip := i.Fact("ip"); ip != "" {
+		parts = append(parts, ip)
+	}
+	return strings.Join(parts, ", ")
 }
 
 func (i Item) FinalAddr() string {
@@ -33,19 +150,78 @@ func (i Item) FinalAddr() string {
 	return finalAddr
 }
 
+// HostPort is the address to dial, on port 22 unless the address has a port
+func (i Item) HostPort() string {
+	addr := i.Conn.Address
+	if addr == "" {
+		addr = i.Name
+	}
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(addr, "22")
+}
+
+// FilterFields are the key:value fields matched by qualified filter terms:
+// name, addr, user, comment, each tag, the group and folder, the health
+// check status and the gathered facts
+func (i Item) FilterFields() []string {
+	addr := i.Conn.Address
+	if addr == "" {
+		addr = i.Name
+	}
+	fields := []string{"name:" + i.Name, "addr:" + addr, "user:" + i.Conn.User, "comment:" + i.Conn.Description}
+	for _, tag := range i.Conn.Tags {
+		fields = append(fields, "tag:"+tag)
+	}
+	if i.Conn.Group != "" {
+		fields = append(fields, "group:"+i.Conn.Group)
+	}
+	if i.Conn.Folder != "" {
+		fields = append(fields, "folder:"+i.Conn.Folder)
+	}
+	if i.Health.State != "" {
+		fields = append(fields, "status:"+i.Health.State)
+	}
+	for _, k := range slices.Sorted(maps.Keys(i.Facts)) {
+		fields = append(fields, k+":"+i.Facts[k])
+	}
+	for ind, f := range fields {
+		fields[ind] = strings.ReplaceAll(f, "\t", " ")
+	}
+	return fields
+}
+
+// FilterValue is the fields matched by plain filter terms separated by
+// spaces, followed by the tab separated FilterFields. It starts with the
+// title so matches can be highlighted in it.
 func (i Item) FilterValue() string {
-	return i.Name + " " + i.Conn.Address + " " + i.Conn.User + " " + i.Conn.Description
+	fv := i.Title() + " " + i.Conn.Address + " " + i.Conn.User + " " + i.Conn.Description
+	return fv + "\t" + strings.Join(i.FilterFields(), "\t")
 }
 
+// CheckedPrefix marks checked items in the list
+const CheckedPrefix = "[x] "
+
 func (i Item) Title() string {
 	if i.Checked {
-		return "[x] " + i.Name
+		return CheckedPrefix + i.Name
 	}
 	return i.Name
 }
 
 func (i Item) Description() string {
-	return i.FinalAddr() + " -> " + i.Conn.Description
+	desc := i.FinalAddr() + " -> " + i.Conn.Description
+	if badge := i.Health.Badge(); badge != "" {
+		desc = badge + "  " + desc
+	}
+	if i.Favorite {
+		desc = "★ " + desc
+	}
+	if summary := i.FactsSummary(); summary != "" {
+		desc += " [" + summary + "]"
+	}
+	return desc
 }
 
 func (i Item) WindowName() string {
@@ -39,6 +39,24 @@ func TestItem_FinalAddr(t *testing.T) {
 	}
 }
 
+func TestItem_HostPort(t *testing.T) {
+	tests := []struct {
+		item     Item
+		expected string
+	}{
+		{item: Item{Name: "host"}, expected: "host:22"},
+		{item: Item{Name: "host", Conn: Connection{Address: "1.2.3.4"}}, expected: "1.2.3.4:22"},
+		{item: Item{Name: "host", Conn: Connection{Address: "1.2.3.4:2222"}}, expected: "1.2.3.4:2222"},
+		{item: Item{Name: "host", Conn: Connection{Address: "::1"}}, expected: "[::1]:22"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.item.HostPort(); got != tt.expected {
+			t.Errorf("HostPort(%v) = %v, want %v", tt.item.Conn.Address, got, tt.expected)
+		}
+	}
+}
+
 func TestItem_FilterValue(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -48,12 +66,28 @@ func TestItem_FilterValue(t *testing.T) {
 		{
 			name:     "basic",
 			item:     Item{Name: "host", Conn: Connection{Address: "addr", User: "user", Description: "desc"}},
-			expected: "host addr user desc",
+			expected: "host addr user desc\tname:host\taddr:addr\tuser:user\tcomment:desc",
 		},
 		{
 			name:     "empty fields",
 			item:     Item{Name: "host", Conn: Connection{}},
-			expected: "host   ",
+			expected: "host   \tname:host\taddr:host\tuser:\tcomment:",
+		},
+		{
+			name:     "checked",
+			item:     Item{Name: "host", Conn: Connection{Address: "addr"}, Health: Health{State: HealthNoSSH}},
+			expected: "host addr  \tname:host\taddr:addr\tuser:\tcomment:\tstatus:nossh",
+		},
+		{
+			name: "tags and facts",
+			item: Item{Name: "host", Conn: Connection{Tags: []string{"prod", "db"}}, Health: Health{State: HealthUp},
+				Facts: map[string]string{"os": "ubuntu", "ip": "10.0.0.5 10.0.0.6"}},
+			expected: "host   \tname:host\taddr:host\tuser:\tcomment:\ttag:prod\ttag:db\tstatus:up\tip:10.0.0.5 10.0.0.6\tos:ubuntu",
+		},
+		{
+			name:     "group and folder",
+			item:     Item{Name: "host", Conn: Connection{Group: "web", Folder: "prod/eu"}},
+			expected: "host   \tname:host\taddr:host\tuser:\tcomment:\tgroup:web\tfolder:prod/eu",
 		},
 	}
 
@@ -89,6 +123,12 @@ func TestItem_Description(t *testing.T) {
 			item:     Item{Name: "host", Conn: Connection{}},
 			expected: "host -> ",
 		},
+		{
+			name: "with health and facts",
+			item: Item{Name: "host", Conn: Connection{Description: "db"}, Health: Health{State: HealthDown},
+				Facts: map[string]string{"os": "ubuntu", "version": "22.04", "kernel": "5.15.0", "uptime": "7300", "ip": "10.0.0.5"}},
+			expected: "○ down  host -> db [ubuntu 22.04, 5.15.0, up 2h, 10.0.0.5]",
+		},
 	}
 
 	for _, tt := range tests {
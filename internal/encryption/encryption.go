@@ -1,13 +1,18 @@
 package encryption
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"filippo.io/age/armor"
 	"github.com/nicknickel/gossh/internal/log"
 )
 
@@ -22,60 +27,233 @@ func GetPassphrase() string {
 	return passphrase
 }
 
-func GetEncryptedContents(encFile string) string {
-	if encFile == "" {
-		return ""
-	}
+// GetIdentities collects the age identities able to decrypt secrets: the
+// GOSSH_PASSPHRASE scrypt identity and any in the GOSSH_AGE_IDENTITY file
+func GetIdentities() []age.Identity {
+	var identities []age.Identity
 
 	p := GetPassphrase()
-	if p == "" {
-		return ""
+	if p != "" {
+		identity, err := age.NewScryptIdentity(p)
+		if err != nil {
+			log.Logger.Error("Could not create a new scrypt identity", "err", err)
+		} else {
+			identities = append(identities, identity)
+		}
 	}
 
-	identity, err := age.NewScryptIdentity(p)
-	if err != nil {
-		log.Logger.Error("Could not create a new scrypt identity", "err", err)
-		return ""
+	idFile := os.Getenv("GOSSH_AGE_IDENTITY")
+	if idFile != "" {
+		f, err := os.Open(idFile)
+		if err != nil {
+			log.Logger.Error("Failed to open age identity file", "file", idFile, "err", err)
+			return identities
+		}
+		defer f.Close()
+
+		fileIdentities, err := age.ParseIdentities(f)
+		if err != nil {
+			log.Logger.Error("Failed to parse age identity file", "file", idFile, "err", err)
+			return identities
+		}
+		identities = append(identities, fileIdentities...)
+	}
+
+	return identities
+}
+
+func Decrypt(encFile string, identities []age.Identity) ([]byte, error) {
+	if len(identities) == 0 {
+		return nil, errors.New("no passphrase or age identity available")
 	}
 
 	f, err := os.Open(encFile)
 	if err != nil {
-		log.Logger.Error("Failed to open file", "file", encFile, "err", err)
-		return ""
+		return nil, err
+	}
+	defer f.Close()
+
+	in := bufio.NewReader(f)
+	var src io.Reader = in
+	if header, _ := in.Peek(len(armor.Header)); string(header) == armor.Header {
+		src = armor.NewReader(in)
 	}
 
-	r, err := age.Decrypt(f, identity)
+	r, err := age.Decrypt(src, identities...)
 	if err != nil {
-		log.Logger.Error("Failed to open encrypted file", "file", encFile, "err", err)
-		return ""
+		return nil, err
 	}
+
 	out := &bytes.Buffer{}
 	if _, err := io.Copy(out, r); err != nil {
-		log.Logger.Error("Failed to read encrypted file", "file", encFile, "err", err)
-		return ""
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// ageHeader starts every binary age file
+const ageHeader = "age-encryption.org/"
+
+// IsEncrypted reports whether the file is age encrypted, binary or ASCII
+// armored, by its header
+func IsEncrypted(file string) (bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return false, err
 	}
+	defer f.Close()
 
-	return out.String()
+	header := make([]byte, len(armor.Header))
+	n, _ := io.ReadFull(f, header)
+	return bytes.HasPrefix(header[:n], []byte(ageHeader)) || string(header[:n]) == armor.Header, nil
 }
 
-func GetEncryptedIdentity(encFile string) string {
+func GetEncryptedContents(encFile string) string {
 	if encFile == "" {
 		return ""
 	}
 
-	identityContents := GetEncryptedContents(encFile)
-	if identityContents != "" {
-		pattern := fmt.Sprintf("%v.pem.*", filepath.Base(encFile))
-		f, err := os.CreateTemp("", pattern)
-		if err != nil {
-			log.Logger.Error("Failed to create temp identity file", "file", encFile, "err", err)
-			return ""
+	identities := GetIdentities()
+	if len(identities) == 0 {
+		return ""
+	}
+
+	out, err := Decrypt(encFile, identities)
+	if err != nil {
+		log.Logger.Error("Failed to open encrypted file", "file", encFile, "err", err)
+		return ""
+	}
+
+	return string(out)
+}
+
+// RecipientsOf recreates the recipients of the encrypted file from the
+// identities that decrypt it, so it can be rewritten to the same keys. It
+// fails when any recipient has no identity, rather than dropping it.
+func RecipientsOf(encFile string) ([]age.Recipient, error) {
+	f, err := os.Open(encFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stanzas := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() && !strings.HasPrefix(scanner.Text(), "---") {
+		if strings.HasPrefix(scanner.Text(), "-> ") {
+			stanzas++
+		}
+	}
+
+	var recipients []age.Recipient
+	for _, identity := range GetIdentities() {
+		if _, err := Decrypt(encFile, []age.Identity{identity}); err != nil {
+			continue
+		}
+		switch id := identity.(type) {
+		case *age.ScryptIdentity:
+			return PassphraseRecipient(GetPassphrase())
+		case *age.X25519Identity:
+			recipients = append(recipients, id.Recipient())
 		}
+	}
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("no passphrase or age identity decrypts %v", encFile)
+	}
+	if len(recipients) < stanzas {
+		return nil, fmt.Errorf("%v is encrypted to recipients without an identity in GOSSH_AGE_IDENTITY", encFile)
+	}
+	return recipients, nil
+}
+
+// ParseRecipients accepts age (age1...) and ssh public key recipients, either
+// directly or one per line in recipientsFile
+func ParseRecipients(recipients []string, recipientsFile string) ([]age.Recipient, error) {
+	lines := recipients
 
+	if recipientsFile != "" {
+		f, err := os.Open(recipientsFile)
+		if err != nil {
+			return nil, err
+		}
 		defer f.Close()
 
-		_, err = f.Write([]byte(identityContents))
-		return f.Name()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var parsed []age.Recipient
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var r age.Recipient
+		var err error
+		if strings.HasPrefix(line, "age1") {
+			r, err = age.ParseX25519Recipient(line)
+		} else {
+			r, err = agessh.ParseRecipient(line)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %q: %w", line, err)
+		}
+		parsed = append(parsed, r)
+	}
+
+	return parsed, nil
+}
+
+func PassphraseRecipient(p string) ([]age.Recipient, error) {
+	if p == "" {
+		return nil, errors.New("passphrase cannot be empty")
+	}
+
+	r, err := age.NewScryptRecipient(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return []age.Recipient{r}, nil
+}
+
+// Encrypt writes the encrypted contents to a temp file next to outFile and
+// renames it into place so a failure never leaves a partially written secret
+func Encrypt(contents []byte, recipients []age.Recipient, outFile string) error {
+	if len(recipients) == 0 {
+		return errors.New("no recipients to encrypt to")
 	}
-	return ""
+
+	if err := os.MkdirAll(filepath.Dir(outFile), 0700); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(outFile), "."+filepath.Base(outFile)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w, err := age.Encrypt(f, recipients...)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(contents); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), outFile)
 }
@@ -3,6 +3,7 @@ package encryption
 import (
 	"bytes"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"io"
@@ -130,92 +131,133 @@ func TestGetEncryptedContents(t *testing.T) {
 	}
 }
 
-func TestGetEncryptedIdentity(t *testing.T) {
+func TestEncryptDecrypt(t *testing.T) {
 	internal_log.Logger = log.New(io.Discard)
-	// Create a temporary encrypted file
-	passphrase := "testpass"
-	recipient, err := age.NewScryptRecipient(passphrase)
-	if err != nil {
-		t.Fatalf("Failed to create identity: %v", err)
-	}
 
-	plaintext := "secretpassword"
-	buf := new(bytes.Buffer)
-	w, err := age.Encrypt(buf, recipient)
+	x25519, err := age.GenerateX25519Identity()
 	if err != nil {
-		t.Fatalf("Failed to create encrypt writer: %v", err)
-	}
-	_, err = w.Write([]byte(plaintext))
-	if err != nil {
-		t.Fatalf("Failed to write plaintext: %v", err)
+		t.Fatalf("Failed to generate identity: %v", err)
 	}
-	err = w.Close()
+	passRecipients, err := PassphraseRecipient("testpass")
 	if err != nil {
-		t.Fatalf("Failed to close encrypt writer: %v", err)
+		t.Fatalf("PassphraseRecipient() error = %v", err)
 	}
-
-	tmpfile, err := os.CreateTemp("", "encrypted")
-	if err != nil {
-		t.Fatalf("Failed to create temp file: %v", err)
-	}
-	defer os.Remove(tmpfile.Name())
-
-	_, err = tmpfile.Write(buf.Bytes())
-	if err != nil {
-		t.Fatalf("Failed to write to temp file: %v", err)
-	}
-	tmpfile.Close()
+	scrypt, _ := age.NewScryptIdentity("testpass")
 
 	tests := []struct {
-		name          string
-		passphrase    string
-		filename      string
-		expectedBlank bool
-		expectErr     bool
+		name       string
+		recipients []age.Recipient
+		identities []age.Identity
+		expectErr  bool
 	}{
 		{
-			name:          "valid decryption",
-			passphrase:    passphrase,
-			filename:      tmpfile.Name(),
-			expectedBlank: false,
-			expectErr:     false,
+			name:       "passphrase",
+			recipients: passRecipients,
+			identities: []age.Identity{scrypt},
 		},
 		{
-			name:          "wrong passphrase",
-			passphrase:    "wrong",
-			filename:      tmpfile.Name(),
-			expectedBlank: true,
-			expectErr:     true,
+			name:       "x25519 recipient",
+			recipients: []age.Recipient{x25519.Recipient()},
+			identities: []age.Identity{scrypt, x25519},
 		},
 		{
-			name:          "no passphrase",
-			passphrase:    "",
-			filename:      tmpfile.Name(),
-			expectedBlank: true,
-			expectErr:     false,
+			name:       "wrong identity",
+			recipients: []age.Recipient{x25519.Recipient()},
+			identities: []age.Identity{scrypt},
+			expectErr:  true,
 		},
 		{
-			name:          "file not exist",
-			passphrase:    passphrase,
-			filename:      "nonexistent",
-			expectedBlank: true,
-			expectErr:     true,
+			name:       "no identities",
+			recipients: passRecipients,
+			identities: nil,
+			expectErr:  true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			os.Setenv("GOSSH_PASSPHRASE", tt.passphrase)
-			defer os.Unsetenv("GOSSH_PASSPHRASE")
+			outFile := t.TempDir() + "/secret.age"
+			if err := Encrypt([]byte("secretpassword"), tt.recipients, outFile); err != nil {
+				t.Fatalf("Encrypt() error = %v", err)
+			}
+			fi, err := os.Stat(outFile)
+			if err != nil {
+				t.Fatalf("Encrypt() did not create %v", outFile)
+			}
+			if fi.Mode().Perm() != 0600 {
+				t.Errorf("Encrypt() file mode = %v, want 0600", fi.Mode().Perm())
+			}
 
-			got := GetEncryptedIdentity(tt.filename)
-			if got != "" && tt.expectedBlank {
-				t.Errorf("GetEncryptedIdentity() = %v, want empty string", got)
+			got, err := Decrypt(outFile, tt.identities)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("Decrypt() error = %v, expectErr %v", err, tt.expectErr)
 			}
-			if got == "" && !tt.expectedBlank {
-				t.Errorf("GetEncryptedIdentity() = empty string, want non-empty string")
+			if !tt.expectErr && string(got) != "secretpassword" {
+				t.Errorf("Decrypt() = %v, want secretpassword", string(got))
+			}
+		})
+	}
+
+	if err := Encrypt([]byte("x"), nil, t.TempDir()+"/none.age"); err == nil {
+		t.Errorf("Encrypt() without recipients expected error")
+	}
+}
+
+func TestParseRecipients(t *testing.T) {
+	x25519, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+
+	recipientsFile := t.TempDir() + "/recipients.txt"
+	os.WriteFile(recipientsFile, []byte("# team keys\n\n"+x25519.Recipient().String()+"\n"), 0600)
+
+	tests := []struct {
+		name       string
+		recipients []string
+		file       string
+		expected   int
+		expectErr  bool
+	}{
+		{name: "direct", recipients: []string{x25519.Recipient().String()}, expected: 1},
+		{name: "file with comments", file: recipientsFile, expected: 1},
+		{name: "direct and file", recipients: []string{x25519.Recipient().String()}, file: recipientsFile, expected: 2},
+		{name: "invalid", recipients: []string{"notakey"}, expectErr: true},
+		{name: "missing file", file: "nonexistent", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRecipients(tt.recipients, tt.file)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("ParseRecipients() error = %v, expectErr %v", err, tt.expectErr)
+			}
+			if len(got) != tt.expected {
+				t.Errorf("ParseRecipients() len = %d, want %d", len(got), tt.expected)
 			}
-			// Note: The function doesn't return error, but logs it. For testing, we might check logs, but simplifying here.
 		})
 	}
 }
+
+func TestIsEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"encrypted": "age-encryption.org/v1\n-> X25519 abc\n",
+		"armored":   "-----BEGIN AGE ENCRYPTED FILE-----\nYWdlLWVuY3J5cHRpb24ub3JnL3YxCg==\n",
+		"plain":     "hunter2\n",
+		"short":     "age",
+	}
+	for name, contents := range files {
+		os.WriteFile(filepath.Join(dir, name), []byte(contents), 0600)
+	}
+
+	for name := range files {
+		got, err := IsEncrypted(filepath.Join(dir, name))
+		if err != nil || got != (name == "encrypted" || name == "armored") {
+			t.Errorf("IsEncrypted(%v) = %v, %v", name, got, err)
+		}
+	}
+	if _, err := IsEncrypted(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("IsEncrypted() of a missing file should fail")
+	}
+}
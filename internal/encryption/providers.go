@@ -0,0 +1,103 @@
+package encryption
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// SecretProvider looks up a secret from an external password manager
+type SecretProvider interface {
+	Get(ref string) (string, error)
+}
+
+// CommandProvider runs Program with Args followed by the reference and returns
+// its output
+type CommandProvider struct {
+	Program string
+	Args    []string
+}
+
+func (c CommandProvider) Get(ref string) (string, error) {
+	args := append(append([]string{}, c.Args...), ref)
+	return runProvider(exec.Command(c.Program, args...))
+}
+
+// ExecProvider runs the reference itself as a shell command
+type ExecProvider struct{}
+
+func (e ExecProvider) Get(ref string) (string, error) {
+	return runProvider(exec.Command("sh", "-c", ref))
+}
+
+// BitwardenProvider reads the password of an item, or its notes when the
+// reference is prefixed with notes: (useful for private keys)
+type BitwardenProvider struct{}
+
+func (b BitwardenProvider) Get(ref string) (string, error) {
+	field := "password"
+	if item, ok := strings.CutPrefix(ref, "notes:"); ok {
+		field = "notes"
+		ref = item
+	}
+	return runProvider(exec.Command("bw", "get", field, ref))
+}
+
+func runProvider(cmd *exec.Cmd) (string, error) {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%v failed: %w: %s", cmd.Args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// Providers maps a reference prefix to its provider. Prefixes that are part of
+// the reference itself (op://) are passed through whole.
+var Providers = map[string]SecretProvider{
+	"pass:":   CommandProvider{Program: "pass", Args: []string{"show"}},
+	"gopass:": CommandProvider{Program: "gopass", Args: []string{"show", "-o"}},
+	"op://":   CommandProvider{Program: "op", Args: []string{"read"}},
+	"bw:":     BitwardenProvider{},
+	"exec:":   ExecProvider{},
+}
+
+func ParseSecretRef(s string) (SecretProvider, string, bool) {
+	for prefix, p := range Providers {
+		if rest, ok := strings.CutPrefix(s, prefix); ok {
+			if prefix == "op://" {
+				return p, s, true
+			}
+			return p, rest, true
+		}
+	}
+	return nil, "", false
+}
+
+func IsSecretRef(s string) bool {
+	_, _, ok := ParseSecretRef(s)
+	return ok
+}
+
+func GetSecret(s string) (string, error) {
+	p, ref, ok := ParseSecretRef(s)
+	if !ok {
+		return "", fmt.Errorf("%v is not a secret reference", s)
+	}
+	return p.Get(ref)
+}
+
+// GetSecretPassword returns the first line of the secret, as password managers
+// like pass store additional fields on the following lines
+func GetSecretPassword(s string) (string, error) {
+	secret, err := GetSecret(s)
+	if err != nil {
+		return "", err
+	}
+	pw, _, _ := strings.Cut(secret, "\n")
+	return pw, nil
+}
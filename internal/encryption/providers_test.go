@@ -0,0 +1,81 @@
+package encryption
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFakeProvider puts a script named program on the PATH that echoes its
+// arguments so tests can check how each provider is invoked
+func writeFakeProvider(t *testing.T, dir string, program string, script string) {
+	f := filepath.Join(dir, program)
+	if err := os.WriteFile(f, []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatalf("Failed to write fake %v: %v", program, err)
+	}
+}
+
+func TestGetSecret(t *testing.T) {
+	dir := t.TempDir()
+	writeFakeProvider(t, dir, "pass", `echo "pass $*"; echo "user: admin"`)
+	writeFakeProvider(t, dir, "gopass", `echo "gopass $*"`)
+	writeFakeProvider(t, dir, "op", `echo "op $*"`)
+	writeFakeProvider(t, dir, "bw", `echo "bw $*"`)
+	writeFakeProvider(t, dir, "failing", `echo "locked" >&2; exit 1`)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	tests := []struct {
+		name      string
+		ref       string
+		expected  string
+		expectErr bool
+	}{
+		{name: "pass", ref: "pass:infra/db01", expected: "pass show infra/db01\nuser: admin"},
+		{name: "gopass", ref: "gopass:infra/db01", expected: "gopass show -o infra/db01"},
+		{name: "1password", ref: "op://vault/item/key", expected: "op read op://vault/item/key"},
+		{name: "bitwarden password", ref: "bw:db01", expected: "bw get password db01"},
+		{name: "bitwarden notes", ref: "bw:notes:db01", expected: "bw get notes db01"},
+		{name: "exec", ref: "exec:echo secret", expected: "secret"},
+		{name: "failing command", ref: "exec:failing", expectErr: true},
+		{name: "not a reference", ref: "/tmp/passfile", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetSecret(tt.ref)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("GetSecret() error = %v, expectErr %v", err, tt.expectErr)
+			}
+			if got != tt.expected {
+				t.Errorf("GetSecret() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGetSecretPassword(t *testing.T) {
+	got, err := GetSecretPassword("exec:printf 'hunter2\\nuser: admin\\n'")
+	if err != nil {
+		t.Fatalf("GetSecretPassword() error = %v", err)
+	}
+	if got != "hunter2" {
+		t.Errorf("GetSecretPassword() = %q, want hunter2", got)
+	}
+}
+
+func TestIsSecretRef(t *testing.T) {
+	tests := map[string]bool{
+		"pass:infra/db01":    true,
+		"op://vault/item/pw": true,
+		"exec:cat /tmp/x":    true,
+		"/tmp/key.pem":       false,
+		"keys/passfile.age":  false,
+		"":                   false,
+	}
+
+	for ref, expected := range tests {
+		if got := IsSecretRef(ref); got != expected {
+			t.Errorf("IsSecretRef(%q) = %v, want %v", ref, got, expected)
+		}
+	}
+}
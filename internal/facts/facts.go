@@ -0,0 +1,213 @@
+package facts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/log"
+	"github.com/nicknickel/gossh/internal/runcommand"
+)
+
+// defaultProbe prints key=value lines with POSIX shell tools
+const defaultProbe = `. /etc/os-release 2>/dev/null; ` +
+	`echo "os=$(echo ${ID:-$(uname -s)} | tr A-Z a-z)"; ` +
+	`echo "version=${VERSION_ID:-$(uname -r)}"; ` +
+	`echo "kernel=$(uname -r)"; ` +
+	`echo "arch=$(uname -m)"; ` +
+	`echo "uptime=$(cut -d. -f1 /proc/uptime 2>/dev/null)"; ` +
+	`echo "ip=$(hostname -I 2>/dev/null)"`
+
+var factLine = regexp.MustCompile(`^([a-z][a-z0-9_]*)=(.*)$`)
+
+// Entry is the facts gathered from a host
+type Entry struct {
+	Gathered time.Time         `json:"gathered"`
+	Values   map[string]string `json:"values"`
+}
+
+// Result is the outcome of gathering one connection's facts
+type Result struct {
+	Item  connection.Item
+	Entry Entry
+	Err   error
+}
+
+// Probe is the shell command printing the facts, GOSSH_FACTS_PROBE or the
+// default one
+func Probe() string {
+	if p := os.Getenv("GOSSH_FACTS_PROBE"); p != "" {
+		return p
+	}
+	return defaultProbe
+}
+
+// CacheFile is where gathered facts are kept, in GOSSH_CACHE_DIR or the
+// user's cache directory
+func CacheFile() (string, error) {
+	dir := os.Getenv("GOSSH_CACHE_DIR")
+	if dir == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(cache, "gossh")
+	}
+	return filepath.Join(dir, "facts.json"), nil
+}
+
+// Load reads the cached facts by connection name
+func Load() (map[string]Entry, error) {
+	cache := map[string]Entry{}
+	file, err := CacheFile()
+	if err != nil {
+		return cache, err
+	}
+	f, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return cache, err
+	}
+	if err := json.Unmarshal(f, &cache); err != nil {
+		return map[string]Entry{}, fmt.Errorf("could not read %v: %w", file, err)
+	}
+	return cache, nil
+}
+
+// Save merges the gathered facts into the cache, keeping the facts of
+// hosts that failed
+func Save(results []Result) error {
+	cache, err := Load()
+	if err != nil {
+		log.Logger.Error("Replacing unreadable facts cache", "err", err)
+	}
+	for _, r := range results {
+		if r.Err == nil {
+			cache[r.Item.Name] = r.Entry
+		}
+	}
+
+	file, err := CacheFile()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// Apply adds the cached facts to the connection items
+func Apply(items []list.Item) {
+	cache, err := Load()
+	if err != nil {
+		log.Logger.Error("Could not load facts", "err", err)
+		return
+	}
+	for ind, val := range items {
+		item := val.(connection.Item)
+		if e, ok := cache[item.Name]; ok {
+			item.Facts, item.FactsGathered = e.Values, e.Gathered
+			items[ind] = item
+		}
+	}
+}
+
+// Parse reads the key=value lines of the probe output, ignoring anything
+// else such as login banners
+func Parse(out string) map[string]string {
+	values := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		m := factLine.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if m != nil {
+			values[m[1]] = strings.Join(strings.Fields(m[2]), " ")
+		}
+	}
+	return values
+}
+
+// Gather runs the probe on the connection
+func Gather(i connection.Item, probe string, now time.Time) Result {
+	r := Result{Item: i}
+	c := append([]string{"ssh", "-o", "ConnectTimeout=10", "{{.FinalAddr}}"}, strings.Split(probe, " ")...)
+	cmd, responders, cleanup, err := runcommand.PrepareCommand(&i, c)
+	defer cleanup()
+	if err != nil {
+		r.Err = err
+		return r
+	}
+
+	var out string
+	if len(responders) > 0 {
+		out, err = runcommand.PtyCommandOutput(cmd, responders)
+	} else {
+		var b []byte
+		b, err = cmd.CombinedOutput()
+		out = string(b)
+	}
+	if err != nil {
+		r.Err = fmt.Errorf("%w: %s", err, strings.TrimSpace(out))
+		return r
+	}
+
+	r.Entry = Entry{Gathered: now, Values: Parse(out)}
+	if len(r.Entry.Values) == 0 {
+		r.Err = errors.New("the probe printed no key=value lines")
+	}
+	return r
+}
+
+func GatherAll(items []connection.Item, probe string) []Result {
+	results := make([]Result, len(items))
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	limiter := make(chan int, runcommand.GetConcurrency())
+	for ind, item := range items {
+		wg.Go(func() {
+			limiter <- 1
+			results[ind] = Gather(item, probe, now)
+			<-limiter
+		})
+	}
+	wg.Wait()
+
+	return results
+}
+
+func Report(results []Result) string {
+	var sb strings.Builder
+	failed := 0
+
+	for _, r := range results {
+		if r.Err != nil {
+			sb.WriteString(fmt.Sprintf("%v: failed (%v)\n", r.Item.WindowName(), r.Err))
+			failed++
+			continue
+		}
+		item := r.Item
+		item.Facts = r.Entry.Values
+		sb.WriteString(fmt.Sprintf("%v: %v\n", item.WindowName(), item.FactsSummary()))
+	}
+	sb.WriteString(fmt.Sprintf("\nGathered facts from %v of %v hosts\n", len(results)-failed, len(results)))
+
+	return sb.String()
+}
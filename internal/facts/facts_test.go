@@ -0,0 +1,80 @@
+package facts
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/log"
+	"github.com/nicknickel/gossh/internal/connection"
+	internal_log "github.com/nicknickel/gossh/internal/log"
+)
+
+// writeFakeSsh puts an ssh script on the PATH
+func writeFakeSsh(t *testing.T, script string) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ssh"), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatalf("Failed to write fake ssh: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("SSH_AUTH_SOCK", "")
+	t.Setenv("HOME", t.TempDir())
+}
+
+func TestParse(t *testing.T) {
+	out := "Welcome to web1\r\nos=ubuntu\r\nversion=22.04\nip=10.0.0.5  10.0.0.6 \nNot a fact\nBad Key=x\n"
+	got := Parse(out)
+	if len(got) != 3 || got["os"] != "ubuntu" || got["version"] != "22.04" || got["ip"] != "10.0.0.5 10.0.0.6" {
+		t.Errorf("Parse() = %v", got)
+	}
+}
+
+func TestGatherAll(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	writeFakeSsh(t, `case "$*" in
+*down*) echo "ssh: connect to host down port 22: Connection refused" >&2; exit 255;;
+*) echo "os=ubuntu"; echo "version=22.04"; echo "uptime=90000";;
+esac`)
+
+	items := []connection.Item{{Name: "web1"}, {Name: "down"}}
+	results := GatherAll(items, Probe())
+	if results[0].Err != nil || results[0].Entry.Values["os"] != "ubuntu" || results[0].Entry.Gathered.IsZero() {
+		t.Errorf("GatherAll() web1 = %+v", results[0])
+	}
+	if results[1].Err == nil || !strings.Contains(results[1].Err.Error(), "Connection refused") {
+		t.Errorf("GatherAll() down = %+v", results[1])
+	}
+	report := Report(results)
+	if !strings.Contains(report, "web1: ubuntu 22.04, up 1d") || !strings.Contains(report, "1 of 2 hosts") {
+		t.Errorf("Report() = %v", report)
+	}
+}
+
+func TestSaveApply(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	t.Setenv("GOSSH_CACHE_DIR", t.TempDir())
+	gathered := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	Save([]Result{
+		{Item: connection.Item{Name: "web1"}, Entry: Entry{Gathered: gathered, Values: map[string]string{"os": "debian"}}},
+		{Item: connection.Item{Name: "web2"}, Entry: Entry{Gathered: gathered, Values: map[string]string{"os": "rhel"}}},
+	})
+	// a failed gather keeps the cached facts
+	if err := Save([]Result{{Item: connection.Item{Name: "web2"}, Err: io.EOF}}); err != nil {
+		t.Fatalf("Save() err = %v", err)
+	}
+
+	items := []list.Item{connection.Item{Name: "web1"}, connection.Item{Name: "web2"}, connection.Item{Name: "web3"}}
+	Apply(items)
+	web1, web2 := items[0].(connection.Item), items[1].(connection.Item)
+	if web1.Fact("os") != "debian" || !web1.FactsGathered.Equal(gathered) || web2.Fact("os") != "rhel" {
+		t.Errorf("Apply() = %+v, %+v", web1, web2)
+	}
+	if items[2].(connection.Item).Facts != nil {
+		t.Errorf("Apply() set facts of an unknown host")
+	}
+}
@@ -0,0 +1,129 @@
+package health
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/log"
+	"github.com/nicknickel/gossh/internal/sshconfig"
+)
+
+const (
+	ModeOff = "off"
+	ModeTCP = "tcp"
+	ModeSSH = "ssh"
+
+	defaultInterval = 30 * time.Second
+	// probes are cheap so more run at once than commands do
+	concurrency = 16
+)
+
+var timeout = 3 * time.Second
+
+// Result is the outcome of probing one connection
+type Result struct {
+	Name   string
+	Health connection.Health
+}
+
+// GetMode reads GOSSH_HEALTH: off (default), tcp to check the port accepts
+// connections or ssh to also wait for the server's ssh banner
+func GetMode() string {
+	switch mode := strings.ToLower(os.Getenv("GOSSH_HEALTH")); mode {
+	case ModeTCP, ModeSSH:
+		return mode
+	case "", ModeOff:
+		return ModeOff
+	default:
+		log.Logger.Error("Unknown GOSSH_HEALTH, not checking hosts", "value", mode)
+		return ModeOff
+	}
+}
+
+// GetInterval reads GOSSH_HEALTH_INTERVAL, the seconds between checks
+func GetInterval() time.Duration {
+	if v := os.Getenv("GOSSH_HEALTH_INTERVAL"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return time.Duration(n) * time.Second
+		}
+		log.Logger.Error("Invalid GOSSH_HEALTH_INTERVAL, using default", "value", v, "default", defaultInterval)
+	}
+	return defaultInterval
+}
+
+// Probe connects to the host the way ssh would and, when banner is set,
+// reads the ssh identification line the server sends first. The latency is
+// the time to connect.
+func Probe(cfg sshconfig.Config, banner bool) connection.Health {
+	started := time.Now()
+	conn, err := cfg.Dial(timeout)
+	if err != nil {
+		return connection.Health{State: connection.HealthDown, Checked: started, Err: err.Error()}
+	}
+	defer conn.Close()
+	h := connection.Health{State: connection.HealthUp, Latency: time.Since(started), Checked: started}
+	// a proxy starts whether or not it reaches the host, only the banner
+	// shows it did
+	proxied := cfg.Proxied()
+	if !banner && !proxied {
+		return h
+	}
+
+	// proxies ignore read deadlines so the read ends by closing the
+	// connection, after allowing for the jump hosts to connect first
+	wait := timeout
+	if proxied {
+		wait *= 3
+	}
+	expired := time.AfterFunc(wait, func() { conn.Close() })
+	defer expired.Stop()
+	// servers may send other lines of up to 255 bytes before the banner
+	r := bufio.NewReader(io.LimitReader(conn, 5*256))
+	received := false
+	for range 5 {
+		line, err := r.ReadString('\n')
+		received = received || line != ""
+		if strings.HasPrefix(line, "SSH-") {
+			if proxied {
+				h.Latency = time.Since(started)
+			}
+			return h
+		}
+		if err != nil {
+			h.Err = err.Error()
+			break
+		}
+	}
+	h.State = connection.HealthNoSSH
+	if proxied && !received {
+		h.State = connection.HealthDown
+	}
+	if h.Err == "" {
+		h.Err = "no ssh banner"
+	}
+	return h
+}
+
+// CheckAll probes the connections concurrently, resolving their host, port
+// and proxies through the ssh config
+func CheckAll(items []connection.Item, banner bool) []Result {
+	results := make([]Result, len(items))
+	var wg sync.WaitGroup
+	limiter := make(chan int, concurrency)
+
+	for ind, item := range items {
+		wg.Go(func() {
+			limiter <- 1
+			results[ind] = Result{Name: item.Name, Health: Probe(sshconfig.Lookup(item), banner)}
+			<-limiter
+		})
+	}
+	wg.Wait()
+	return results
+}
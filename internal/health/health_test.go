@@ -0,0 +1,116 @@
+package health
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/nicknickel/gossh/internal/connection"
+	internal_log "github.com/nicknickel/gossh/internal/log"
+	"github.com/nicknickel/gossh/internal/sshconfig"
+)
+
+// listen accepts connections and writes greeting to each of them
+func listen(t *testing.T, greeting string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(greeting))
+			time.AfterFunc(time.Second, func() { conn.Close() })
+		}
+	}()
+	return l.Addr().String()
+}
+
+func closedAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func TestProbe(t *testing.T) {
+	timeout = 200 * time.Millisecond
+	t.Cleanup(func() { timeout = 3 * time.Second })
+
+	sshd := listen(t, "SSH-2.0-OpenSSH_9.6\r\n")
+	web := listen(t, "")
+	banner := listen(t, "Authorized use only\r\nSSH-2.0-dropbear\r\n")
+	noise := listen(t, strings.Repeat("x", 2000)+"\r\nSSH-2.0-late\r\n")
+	down := closedAddr(t)
+
+	tests := []struct {
+		name     string
+		addr     string
+		proxy    string
+		banner   bool
+		expected string
+	}{
+		{name: "tcp up", addr: web, expected: connection.HealthUp},
+		{name: "ssh up", addr: sshd, banner: true, expected: connection.HealthUp},
+		{name: "lines before banner", addr: banner, banner: true, expected: connection.HealthUp},
+		{name: "no banner", addr: web, banner: true, expected: connection.HealthNoSSH},
+		{name: "banner after too much", addr: noise, banner: true, expected: connection.HealthNoSSH},
+		{name: "down", addr: down, expected: connection.HealthDown},
+		{name: "proxy up", addr: down, proxy: `printf 'SSH-2.0-proxied\r\n'; sleep 5`, expected: connection.HealthUp},
+		{name: "proxy cannot connect", addr: down, proxy: "exit 1", expected: connection.HealthDown},
+		{name: "proxy hangs", addr: down, proxy: "sleep 5", expected: connection.HealthDown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, port, _ := net.SplitHostPort(tt.addr)
+			got := Probe(sshconfig.Config{Hostname: host, Port: port, Proxy: tt.proxy}, tt.banner)
+			if got.State != tt.expected {
+				t.Errorf("Probe() = %+v, want %v", got, tt.expected)
+			}
+			if (got.State == connection.HealthUp) != (got.Err == "") {
+				t.Errorf("Probe() err = %q for state %v", got.Err, got.State)
+			}
+		})
+	}
+}
+
+func TestCheckAll(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	results := CheckAll([]connection.Item{
+		{Name: "up", Conn: connection.Connection{Address: listen(t, "SSH-2.0-test\r\n")}},
+		{Name: "down", Conn: connection.Connection{Address: closedAddr(t)}},
+	}, true)
+	if len(results) != 2 || results[0].Name != "up" || results[0].Health.State != connection.HealthUp ||
+		results[1].Health.State != connection.HealthDown {
+		t.Errorf("CheckAll() = %+v", results)
+	}
+}
+
+func TestGetMode(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	for value, expected := range map[string]string{"": ModeOff, "TCP": ModeTCP, "ssh": ModeSSH, "ping": ModeOff} {
+		t.Setenv("GOSSH_HEALTH", value)
+		if got := GetMode(); got != expected {
+			t.Errorf("GetMode(%q) = %v, want %v", value, got, expected)
+		}
+	}
+	t.Setenv("GOSSH_HEALTH_INTERVAL", "5")
+	if got := GetInterval(); got != 5*time.Second {
+		t.Errorf("GetInterval() = %v", got)
+	}
+	t.Setenv("GOSSH_HEALTH_INTERVAL", "soon")
+	if got := GetInterval(); got != defaultInterval {
+		t.Errorf("GetInterval() = %v", got)
+	}
+}
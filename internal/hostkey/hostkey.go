@@ -0,0 +1,428 @@
+package hostkey
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nicknickel/gossh/internal/config"
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/log"
+	"github.com/nicknickel/gossh/internal/sshconfig"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	StatusKnown    = "known"
+	StatusNew      = "new"
+	StatusChanged  = "CHANGED"
+	StatusMismatch = "PIN MISMATCH"
+	StatusFailed   = "failed"
+)
+
+// scans only exchange keys so more run at once than commands do
+const concurrency = 16
+
+var scanTimeout = 5 * time.Second
+
+// key types tried, in order, when looking for the key matching a pin
+var pinAlgorithms = []string{
+	ssh.KeyAlgoED25519,
+	ssh.KeyAlgoECDSA256,
+	ssh.KeyAlgoECDSA384,
+	ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSASHA512,
+}
+
+var errScanned = errors.New("host key scanned")
+
+// Result is the key a host presented compared with what gossh knows about it
+type Result struct {
+	Item        connection.Item
+	Key         ssh.PublicKey
+	Fingerprint string
+	// Previous is the recorded or pinned fingerprint when it differs
+	Previous string
+	Status   string
+	Err      error
+}
+
+// File is the known_hosts file managed by gossh, GOSSH_KNOWN_HOSTS or
+// ~/.config/gossh/known_hosts
+func File() string {
+	if f := os.Getenv("GOSSH_KNOWN_HOSTS"); f != "" {
+		return f
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "gossh", "known_hosts")
+}
+
+// UserFile is the user's own known_hosts file
+func UserFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".ssh", "known_hosts")
+}
+
+// Scan returns the host key the server presents, of one of the algorithms
+// when given. It connects the way ssh would, through any proxies.
+func Scan(cfg sshconfig.Config, algorithms ...string) (ssh.PublicKey, error) {
+	conn, err := cfg.Dial(scanTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	// proxies ignore deadlines so a stalled handshake ends by closing the
+	// connection, after allowing for the jump hosts to connect first
+	wait := scanTimeout
+	if cfg.Proxied() {
+		wait *= 3
+	}
+	expired := time.AfterFunc(wait, func() { conn.Close() })
+	defer expired.Stop()
+
+	var key ssh.PublicKey
+	clientCfg := &ssh.ClientConfig{
+		User:              "gossh",
+		HostKeyAlgorithms: algorithms,
+		HostKeyCallback: func(hostname string, remote net.Addr, k ssh.PublicKey) error {
+			key = k
+			return errScanned
+		},
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, cfg.Addr(), clientCfg)
+	if err == nil {
+		ssh.NewClient(c, chans, reqs).Close()
+	}
+	if key == nil {
+		if err == nil {
+			err = errors.New("no host key received")
+		}
+		return nil, err
+	}
+	return key, nil
+}
+
+// Alias is the name the connection's keys are recorded under in gossh's
+// known_hosts. It is passed to ssh as HostKeyAlias so the keys match however
+// the ssh config resolves the host.
+func Alias(i connection.Item) string {
+	return knownhosts.Normalize(i.HostPort())
+}
+
+// known returns the keys recorded for addr in the files that exist
+func known(addr string, files ...string) []ssh.PublicKey {
+	var existing []string
+	for _, f := range files {
+		if _, err := os.Stat(f); err == nil {
+			existing = append(existing, f)
+		}
+	}
+	if len(existing) == 0 {
+		return nil
+	}
+	callback, err := knownhosts.New(existing...)
+	if err != nil {
+		log.Logger.Error("Could not read known_hosts", "files", existing, "err", err)
+		return nil
+	}
+
+	// a key no host has always fails, listing the keys recorded for addr
+	var keyErr *knownhosts.KeyError
+	if err := callback(addr, anyAddr, noKey{}); errors.As(err, &keyErr) {
+		var keys []ssh.PublicKey
+		for _, k := range keyErr.Want {
+			keys = append(keys, k.Key)
+		}
+		return keys
+	}
+	return nil
+}
+
+// anyAddr stands in for remote addresses that are not checked, as ssh does
+// not check them with a HostKeyAlias
+var anyAddr = &net.TCPAddr{IP: net.IPv4zero}
+
+// noKey is a public key matching no known_hosts line
+type noKey struct{}
+
+func (noKey) Type() string                                 { return "none" }
+func (noKey) Marshal() []byte                              { return nil }
+func (noKey) Verify(data []byte, sig *ssh.Signature) error { return errors.New("no key") }
+
+// compare sets the status of a scanned key against the pin or, without a
+// pin, against the recorded keys of the same type
+func (r *Result) compare(recorded []ssh.PublicKey) {
+	r.Fingerprint = ssh.FingerprintSHA256(r.Key)
+	pin := r.Item.Conn.HostKey
+	if pin != "" && pin != r.Fingerprint {
+		r.Status, r.Previous = StatusMismatch, pin
+		return
+	}
+
+	r.Status = StatusNew
+	for _, k := range recorded {
+		if k.Type() != r.Key.Type() {
+			continue
+		}
+		if bytes.Equal(k.Marshal(), r.Key.Marshal()) {
+			r.Status, r.Previous = StatusKnown, ""
+			return
+		}
+		if pin == "" {
+			r.Status, r.Previous = StatusChanged, ssh.FingerprintSHA256(k)
+		}
+	}
+}
+
+// Check scans the connection's host key and compares it with the pinned
+// fingerprint, the keys recorded in gossh's known_hosts and the keys the
+// user's known_hosts has for the host ssh resolves
+func Check(i connection.Item) Result {
+	r := Result{Item: i}
+	cfg := sshconfig.Lookup(i)
+
+	var err error
+	r.Key, err = Scan(cfg)
+	if err == nil && i.Conn.HostKey != "" && ssh.FingerprintSHA256(r.Key) != i.Conn.HostKey {
+		// the pin may be for a key type the server did not offer first
+		for _, algo := range pinAlgorithms {
+			if k, err := Scan(cfg, algo); err == nil && ssh.FingerprintSHA256(k) == i.Conn.HostKey {
+				r.Key = k
+				break
+			}
+		}
+	}
+	if err != nil {
+		r.Status, r.Err = StatusFailed, err
+		return r
+	}
+
+	r.compare(append(known(i.HostPort(), File()), known(cfg.Addr(), UserFile())...))
+	return r
+}
+
+// CheckAll checks the connections concurrently
+func CheckAll(items []connection.Item) []Result {
+	results := make([]Result, len(items))
+	var wg sync.WaitGroup
+	limiter := make(chan int, concurrency)
+	for ind, item := range items {
+		wg.Go(func() {
+			limiter <- 1
+			results[ind] = Check(item)
+			<-limiter
+		})
+	}
+	wg.Wait()
+	return results
+}
+
+// Record writes the key to gossh's known_hosts, replacing any key of the
+// same type recorded for the host
+func Record(addr string, key ssh.PublicKey) error {
+	file := File()
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	host := knownhosts.Normalize(addr)
+
+	var lines []string
+	if f, err := os.ReadFile(file); err == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(f))
+		for scanner.Scan() {
+			line := scanner.Text()
+			if _, hosts, k, _, _, err := ssh.ParseKnownHosts([]byte(line)); err == nil &&
+				slices.Contains(hosts, host) && k.Type() == key.Type() {
+				continue
+			}
+			lines = append(lines, line)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	lines = append(lines, knownhosts.Line([]string{host}, key))
+
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// Accept records the scanned key in gossh's known_hosts and pins its
+// fingerprint in the connection's config file
+func Accept(r Result) error {
+	if err := Record(r.Item.HostPort(), r.Key); err != nil {
+		return fmt.Errorf("could not record host key: %w", err)
+	}
+	if r.Item.Conn.HostKey == r.Fingerprint {
+		return nil
+	}
+	file, err := config.FindConnectionFile(r.Item.Name)
+	if err != nil {
+		return err
+	}
+	if err := config.SetConnectionValue(file, r.Item.Name, "hostkey", r.Fingerprint); err != nil {
+		return fmt.Errorf("could not pin host key in %v: %w", file, err)
+	}
+	return nil
+}
+
+// ensurePinned makes sure gossh's known_hosts holds the pinned key, scanning
+// the host when it does not
+func ensurePinned(i connection.Item) error {
+	addr := i.HostPort()
+	for _, k := range known(addr, File()) {
+		if ssh.FingerprintSHA256(k) == i.Conn.HostKey {
+			return nil
+		}
+	}
+	r := Check(i)
+	if r.Err != nil {
+		return fmt.Errorf("could not check the host key: %w", r.Err)
+	}
+	if r.Status == StatusMismatch {
+		return fmt.Errorf("host key of %v is %v but %v is pinned", addr, r.Fingerprint, r.Previous)
+	}
+	return Record(addr, r.Key)
+}
+
+// SshOptions has ssh check the host key against gossh's known_hosts, without
+// prompting, for pinned hosts and hosts recorded by keyscan. Other hosts use
+// the user's ssh configuration. It fails when a pinned host's key can not be
+// confirmed, so the connection is refused.
+func SshOptions(i connection.Item) ([]string, error) {
+	opts, err := PreviewSshOptions(i)
+	if err == nil && opts != nil && i.Conn.HostKey != "" {
+		err = ensurePinned(i)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("refusing to connect to %v: %w", i.Name, err)
+	}
+	return opts, nil
+}
+
+// PreviewSshOptions is SshOptions without recording the keys of pinned
+// hosts. The options are split on whitespace when the command is rendered,
+// so a pin that can not be passed intact fails rather than being dropped.
+func PreviewSshOptions(i connection.Item) ([]string, error) {
+	file := File()
+	alias := Alias(i)
+	switch {
+	case file == "" && i.Conn.HostKey != "":
+		return nil, errors.New("cannot enforce pinned host key: no known_hosts file")
+	case strings.ContainsAny(file+alias, " \t") && i.Conn.HostKey != "":
+		return nil, errors.New("cannot enforce pinned host key: path contains whitespace")
+	case file == "" || strings.ContainsAny(file+alias, " \t"):
+		return nil, nil
+	}
+	if i.Conn.HostKey == "" && len(known(i.HostPort(), file)) == 0 {
+		return nil, nil
+	}
+	return []string{"-o", "UserKnownHostsFile=" + file, "-o", "StrictHostKeyChecking=yes", "-o", "HostKeyAlias=" + alias}, nil
+}
+
+// Callback verifies host keys for SFTP connections against the pin, or the
+// keys recorded in gossh's known_hosts for the connection and in the user's
+// for the host dialed
+func Callback(i connection.Item) (ssh.HostKeyCallback, error) {
+	if pin := i.Conn.HostKey; pin != "" {
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if fp := ssh.FingerprintSHA256(key); fp != pin {
+				return fmt.Errorf("WARNING: host key of %v is %v but %v is pinned", hostname, fp, pin)
+			}
+			return nil
+		}, nil
+	}
+
+	gossh, err := fileCallback(File())
+	if err != nil {
+		return nil, err
+	}
+	user, err := fileCallback(UserFile())
+	if err != nil {
+		return nil, err
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if _, ok := remote.(*net.TCPAddr); !ok {
+			remote = anyAddr
+		}
+		// like ssh with a HostKeyAlias, gossh's keys match the name only
+		want := &knownhosts.KeyError{}
+		for _, check := range []func() error{
+			func() error { return gossh(i.HostPort(), anyAddr, key) },
+			func() error { return user(hostname, remote, key) },
+		} {
+			err := check()
+			var keyErr *knownhosts.KeyError
+			if err == nil {
+				return nil
+			} else if !errors.As(err, &keyErr) {
+				return err
+			}
+			want.Want = append(want.Want, keyErr.Want...)
+		}
+		for _, w := range want.Want {
+			if w.Key.Type() == key.Type() {
+				return fmt.Errorf("WARNING: host key of %v has CHANGED to %v (%v:%v has %v), run gossh keyscan after checking it",
+					hostname, ssh.FingerprintSHA256(key), w.Filename, w.Line, ssh.FingerprintSHA256(w.Key))
+			}
+		}
+		return want
+	}, nil
+}
+
+// fileCallback checks keys against the known_hosts file, knowing no hosts
+// when it does not exist
+func fileCallback(file string) (ssh.HostKeyCallback, error) {
+	if _, err := os.Stat(file); file == "" || err != nil {
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			return &knownhosts.KeyError{}
+		}, nil
+	}
+	callback, err := knownhosts.New(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read known_hosts: %w", err)
+	}
+	return callback, nil
+}
+
+// Report describes the results, warning about changed keys
+func Report(results []Result) string {
+	var sb strings.Builder
+	for _, r := range results {
+		line := fmt.Sprintf("%v: %v", r.Item.WindowName(), r.Status)
+		switch {
+		case r.Err != nil:
+			line += fmt.Sprintf(" (%v)", r.Err)
+		case r.Previous != "":
+			line += fmt.Sprintf(" %v %v, was %v", r.Key.Type(), r.Fingerprint, r.Previous)
+		default:
+			line += fmt.Sprintf(" %v %v", r.Key.Type(), r.Fingerprint)
+		}
+		sb.WriteString(line + "\n")
+	}
+	for _, r := range results {
+		if r.Status == StatusChanged || r.Status == StatusMismatch {
+			sb.WriteString("\nWARNING: some host keys do not match what was recorded. This can mean someone is intercepting\n" +
+				"the connection, or the host was reinstalled. Check the fingerprints with the host's administrator.\n")
+			break
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,309 @@
+package hostkey
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/log"
+	"github.com/nicknickel/gossh/internal/connection"
+	internal_log "github.com/nicknickel/gossh/internal/log"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// startServer runs an ssh server presenting the keys, which are generated
+// when none are given, in a temporary HOME
+func startServer(t *testing.T, signers ...ssh.Signer) (string, []ssh.Signer) {
+	if len(signers) == 0 {
+		_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+		ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		for _, k := range []any{edKey, ecKey} {
+			s, err := ssh.NewSignerFromKey(k)
+			if err != nil {
+				t.Fatalf("Failed to create signer: %v", err)
+			}
+			signers = append(signers, s)
+		}
+	}
+	cfg := &ssh.ServerConfig{NoClientAuth: true}
+	for _, s := range signers {
+		cfg.AddHostKey(s)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				if sc, _, _, err := ssh.NewServerConn(conn, cfg); err == nil {
+					sc.Close()
+				}
+				conn.Close()
+			}()
+		}
+	}()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("GOSSH_KNOWN_HOSTS", "")
+	return l.Addr().String(), signers
+}
+
+// otherKey generates a key of the same type as k
+func otherKey(t *testing.T, k ssh.PublicKey) ssh.PublicKey {
+	var priv any
+	if k.Type() == ssh.KeyAlgoED25519 {
+		_, priv, _ = ed25519.GenerateKey(rand.Reader)
+	} else {
+		priv, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	s, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	return s.PublicKey()
+}
+
+func TestCheck(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	addr, signers := startServer(t)
+	item := connection.Item{Name: "web1", Conn: connection.Connection{Address: addr}}
+
+	r := Check(item)
+	if r.Err != nil || r.Status != StatusNew || r.Fingerprint != ssh.FingerprintSHA256(r.Key) {
+		t.Fatalf("Check() new = %+v", r)
+	}
+	// the key the server did not offer first
+	second := signers[0].PublicKey()
+	if second.Type() == r.Key.Type() {
+		second = signers[1].PublicKey()
+	}
+
+	if err := Record(addr, r.Key); err != nil {
+		t.Fatalf("Record() err = %v", err)
+	}
+	if r := Check(item); r.Status != StatusKnown {
+		t.Errorf("Check() after Record = %v", r.Status)
+	}
+
+	// a different key of the same type recorded by ssh
+	other := otherKey(t, r.Key)
+	os.Remove(File())
+	os.MkdirAll(filepath.Join(os.Getenv("HOME"), ".ssh"), 0700)
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, other)
+	os.WriteFile(UserFile(), []byte(line+"\n"), 0600)
+	if r := Check(item); r.Status != StatusChanged || r.Previous != ssh.FingerprintSHA256(other) {
+		t.Errorf("Check() changed = %+v", r)
+	}
+
+	// pins can be of any key type the server has
+	item.Conn.HostKey = ssh.FingerprintSHA256(second)
+	if r := Check(item); r.Status != StatusNew || r.Key.Type() != second.Type() {
+		t.Errorf("Check() pinned = %+v", r)
+	}
+	item.Conn.HostKey = ssh.FingerprintSHA256(other)
+	if r := Check(item); r.Status != StatusMismatch || !strings.Contains(Report([]Result{r}), "WARNING") {
+		t.Errorf("Check() mismatch = %+v", r)
+	}
+
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed.Close()
+	if r := Check(connection.Item{Name: closed.Addr().String()}); r.Status != StatusFailed || r.Err == nil {
+		t.Errorf("Check() down = %+v", r)
+	}
+}
+
+func TestRecord_Replaces(t *testing.T) {
+	t.Setenv("GOSSH_KNOWN_HOSTS", filepath.Join(t.TempDir(), "gossh", "known_hosts"))
+	keys := make([]ssh.PublicKey, 2)
+	for ind := range keys {
+		pub, _, _ := ed25519.GenerateKey(rand.Reader)
+		keys[ind], _ = ssh.NewPublicKey(pub)
+	}
+
+	Record("web1:22", keys[0])
+	Record("web2:2222", keys[0])
+	Record("web1:22", keys[1])
+	got := known("web1:22", File())
+	if len(got) != 1 || ssh.FingerprintSHA256(got[0]) != ssh.FingerprintSHA256(keys[1]) {
+		t.Errorf("known() = %v", got)
+	}
+	if got := known("web2:2222", File()); len(got) != 1 {
+		t.Errorf("other hosts were not kept")
+	}
+}
+
+func TestSshOptions(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	addr, signers := startServer(t)
+	item := connection.Item{Name: "web1", Conn: connection.Connection{Address: addr}}
+
+	if opts, err := SshOptions(item); opts != nil || err != nil {
+		t.Errorf("SshOptions() for an unknown host = %v, %v", opts, err)
+	}
+
+	// a pin the server does not have refuses the connection
+	item.Conn.HostKey = ssh.FingerprintSHA256(otherKey(t, signers[0].PublicKey()))
+	if opts, err := SshOptions(item); opts != nil || err == nil || !strings.Contains(err.Error(), "refusing") {
+		t.Errorf("SshOptions() mismatch = %v, %v", opts, err)
+	}
+
+	// the pinned key is recorded on first use
+	item.Conn.HostKey = ssh.FingerprintSHA256(signers[1].PublicKey())
+	expected := []string{"-o", "UserKnownHostsFile=" + File(), "-o", "StrictHostKeyChecking=yes", "-o", "HostKeyAlias=" + knownhosts.Normalize(addr)}
+	if opts, err := SshOptions(item); err != nil || !slices.Equal(opts, expected) {
+		t.Errorf("SshOptions() pinned = %v, %v", opts, err)
+	}
+	if got := known(addr, File()); len(got) != 1 || got[0].Type() != signers[1].PublicKey().Type() {
+		t.Errorf("pinned key was not recorded: %v", got)
+	}
+
+	item.Conn.HostKey = ""
+	if opts, err := SshOptions(item); err != nil || !slices.Equal(opts, expected) {
+		t.Errorf("SshOptions() recorded = %v, %v", opts, err)
+	}
+
+	// a pin that can not be passed to ssh intact refuses the connection
+	t.Setenv("GOSSH_KNOWN_HOSTS", filepath.Join(t.TempDir(), "known hosts"))
+	if opts, err := SshOptions(item); opts != nil || err != nil {
+		t.Errorf("SshOptions() unpinned with whitespace = %v, %v", opts, err)
+	}
+	item.Conn.HostKey = ssh.FingerprintSHA256(signers[1].PublicKey())
+	if opts, err := SshOptions(item); opts != nil || err == nil || !strings.Contains(err.Error(), "whitespace") {
+		t.Errorf("SshOptions() pinned with whitespace = %v, %v", opts, err)
+	}
+}
+
+func TestCheck_SshConfig(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	addr, _ := startServer(t)
+	host, port, _ := net.SplitHostPort(addr)
+
+	// ssh resolves the alias to the server's address through a proxy
+	dir := t.TempDir()
+	fake := "#!/bin/sh\ncat <<EOF\nhostname %s\nport %s\nproxycommand nc %%h %%p\nEOF\n"
+	os.WriteFile(filepath.Join(dir, "ssh"), []byte(fmt.Sprintf(fake, host, port)), 0755)
+	os.WriteFile(filepath.Join(dir, "nc"), []byte("#!/bin/sh\nexec "+os.Args[0]+" -test.run=TestHelperNc -- \"$1\" \"$2\"\n"), 0755)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("GOSSH_HELPER_NC", "1")
+
+	item := connection.Item{Name: "web1-alias"}
+	r := Check(item)
+	if r.Err != nil || r.Status != StatusNew {
+		t.Fatalf("Check() through the ssh config = %+v", r)
+	}
+	if err := Record(item.HostPort(), r.Key); err != nil {
+		t.Fatalf("Record() err = %v", err)
+	}
+	if r := Check(item); r.Status != StatusKnown {
+		t.Errorf("Check() after Record = %v", r.Status)
+	}
+	callback, _ := Callback(item)
+	if err := callback(addr, proxyAddr{}, r.Key); err != nil {
+		t.Errorf("callback for the recorded alias err = %v", err)
+	}
+}
+
+// proxyAddr is a remote address that is not TCP, as over a proxy command
+type proxyAddr struct{}
+
+func (proxyAddr) Network() string { return "proxy" }
+func (proxyAddr) String() string  { return "proxy" }
+
+// TestHelperNc connects stdin and stdout to the host and port, standing in
+// for nc as a ProxyCommand
+func TestHelperNc(t *testing.T) {
+	if os.Getenv("GOSSH_HELPER_NC") == "" {
+		t.Skip("only run as a proxy command")
+	}
+	args := os.Args[len(os.Args)-2:]
+	conn, err := net.Dial("tcp", net.JoinHostPort(args[0], args[1]))
+	if err != nil {
+		os.Exit(1)
+	}
+	go io.Copy(conn, os.Stdin)
+	io.Copy(os.Stdout, conn)
+	os.Exit(0)
+}
+
+func TestCallback(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	addr, signers := startServer(t)
+	key := signers[0].PublicKey()
+	remote, _ := net.ResolveTCPAddr("tcp", addr)
+
+	pinned, _ := Callback(connection.Item{Conn: connection.Connection{HostKey: ssh.FingerprintSHA256(key)}})
+	if err := pinned(addr, remote, key); err != nil {
+		t.Errorf("pinned callback err = %v", err)
+	}
+	if err := pinned(addr, remote, signers[1].PublicKey()); err == nil {
+		t.Errorf("pinned callback accepted another key")
+	}
+
+	unknown, _ := Callback(connection.Item{})
+	var keyErr *knownhosts.KeyError
+	if err := unknown(addr, remote, key); err == nil || !errors.As(err, &keyErr) {
+		t.Errorf("callback without known_hosts err = %v", err)
+	}
+
+	Record(addr, otherKey(t, key))
+	changed, _ := Callback(connection.Item{Conn: connection.Connection{Address: addr}})
+	if err := changed(addr, remote, key); err == nil || !strings.Contains(err.Error(), "CHANGED") {
+		t.Errorf("changed callback err = %v", err)
+	}
+}
+
+func TestKeyscan(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	addr, signers := startServer(t)
+	dir := t.TempDir()
+	t.Setenv("GOSSH_CONFIGDIR", dir)
+	conf := filepath.Join(dir, "hosts.yml")
+	os.WriteFile(conf, []byte("web1:\n  address: "+addr+"\n  user: ops\n"), 0600)
+
+	stdin = strings.NewReader("n\n")
+	t.Cleanup(func() { stdin = os.Stdin })
+	if err := Run([]string{"web1"}); err != nil {
+		t.Fatalf("Run() err = %v", err)
+	}
+	if _, err := os.Stat(File()); !os.IsNotExist(err) {
+		t.Errorf("known_hosts written without confirmation")
+	}
+
+	if err := Run([]string{"-yes", "web1"}); err != nil {
+		t.Fatalf("Run() err = %v", err)
+	}
+	c, _ := os.ReadFile(conf)
+	pinned := false
+	for _, s := range signers {
+		pinned = pinned || strings.Contains(string(c), "hostkey: "+ssh.FingerprintSHA256(s.PublicKey()))
+	}
+	if !pinned {
+		t.Errorf("config not pinned:\n%s", c)
+	}
+	if got := known(addr, File()); len(got) != 1 {
+		t.Errorf("known() = %v", got)
+	}
+
+	if err := Run([]string{"missing"}); err == nil {
+		t.Errorf("expected an error for an unknown connection")
+	}
+}
@@ -0,0 +1,111 @@
+package hostkey
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/nicknickel/gossh/internal/config"
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/log"
+)
+
+const keyscanUsage = `Usage: gossh keyscan [-yes] [-all] [connection...]
+
+Scans the host keys of the connections, records them in gossh's known_hosts
+and pins their fingerprints in the config files.
+`
+
+// answers to the confirmation prompts, replaced in tests
+var stdin io.Reader = os.Stdin
+
+func confirm(r *bufio.Reader, question string) bool {
+	fmt.Print(question + " [y/N] ")
+	answer, _ := r.ReadString('\n')
+	return strings.ToLower(strings.TrimSpace(answer)) == "y"
+}
+
+// Keyscan checks the host keys of the connections and records the ones the
+// user accepts. Changed keys are only recorded after a second confirmation,
+// also when yes is set.
+func Keyscan(items []connection.Item, yes bool) error {
+	results := CheckAll(items)
+	fmt.Print(Report(results))
+
+	var fresh, changed []Result
+	for _, r := range results {
+		switch r.Status {
+		case StatusNew:
+			fresh = append(fresh, r)
+		case StatusKnown:
+			// recorded by ssh but not yet by gossh
+			if r.Item.Conn.HostKey == "" {
+				fresh = append(fresh, r)
+			}
+		case StatusChanged, StatusMismatch:
+			changed = append(changed, r)
+		}
+	}
+	if len(fresh) == 0 && len(changed) == 0 {
+		fmt.Println("Nothing to record.")
+		return nil
+	}
+
+	in := bufio.NewReader(stdin)
+	var accept []Result
+	if len(fresh) > 0 && (yes || confirm(in, fmt.Sprintf("Record %v host key(s)?", len(fresh)))) {
+		accept = append(accept, fresh...)
+	}
+	if len(changed) > 0 && confirm(in, fmt.Sprintf("Replace %v CHANGED host key(s)?", len(changed))) {
+		accept = append(accept, changed...)
+	}
+
+	var errs []error
+	for _, r := range accept {
+		if err := Accept(r); err != nil {
+			errs = append(errs, fmt.Errorf("%v: %w", r.Item.Name, err))
+			continue
+		}
+		log.Logger.Info("Recorded host key", "connection", r.Item.Name, "fingerprint", r.Fingerprint, "status", r.Status)
+		fmt.Printf("%v: recorded %v\n", r.Item.WindowName(), r.Fingerprint)
+	}
+	return errors.Join(errs...)
+}
+
+func Run(args []string) error {
+	fs := flag.NewFlagSet("keyscan", flag.ContinueOnError)
+	yes := fs.Bool("yes", false, "Record new host keys without asking")
+	all := fs.Bool("all", false, "Scan every connection")
+	fs.Usage = func() { fmt.Print(keyscanUsage) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !*all && fs.NArg() == 0 {
+		fmt.Print(keyscanUsage)
+		return errors.New("no connections given")
+	}
+
+	var items []connection.Item
+	found := map[string]bool{}
+	for _, val := range config.ReadConnections() {
+		item := val.(connection.Item)
+		for _, name := range fs.Args() {
+			if item.Name == name {
+				found[name] = true
+			}
+		}
+		if *all || found[item.Name] {
+			items = append(items, item)
+		}
+	}
+	for _, name := range fs.Args() {
+		if !found[name] {
+			return fmt.Errorf("connection %v not found", name)
+		}
+	}
+	return Keyscan(items, *yes)
+}
@@ -0,0 +1,210 @@
+package menus
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/runcommand"
+	"github.com/pkg/sftp"
+)
+
+// lister returns the entry names of dir with a trailing "/" on directories.
+// An empty dir is the working (or home) directory.
+type lister func(dir string) ([]string, error)
+
+// pathCompleter completes paths from a lister. Each directory is listed once,
+// in the background, and cached.
+type pathCompleter struct {
+	list    lister
+	cache   map[string][]string
+	pending map[string]bool
+	// words completes the last of several space separated paths
+	words bool
+}
+
+type completionMsg struct {
+	completer *pathCompleter
+	dir       string
+	names     []string
+}
+
+func newPathCompleter(l lister) *pathCompleter {
+	return &pathCompleter{list: l, cache: map[string][]string{}, pending: map[string]bool{}}
+}
+
+// splitCompletion splits value into the directory part, including the
+// trailing separator, and the name being typed
+func splitCompletion(value string) (string, string) {
+	ind := strings.LastIndexAny(value, "/"+string(filepath.Separator))
+	return value[:ind+1], value[ind+1:]
+}
+
+// lastWord splits value before its last path, which starts after the last
+// space that is not escaped with a backslash
+func lastWord(value string) (string, string) {
+	for ind := len(value) - 1; ind >= 0; ind-- {
+		if value[ind] == ' ' && (ind == 0 || value[ind-1] != '\\') {
+			return value[:ind+1], value[ind+1:]
+		}
+	}
+	return "", value
+}
+
+// suggestions returns the completions for value once its directory has been
+// listed, otherwise the command that lists it
+func (c *pathCompleter) suggestions(value string) ([]string, tea.Cmd) {
+	head := ""
+	if c.words {
+		head, value = lastWord(value)
+		value = strings.ReplaceAll(value, "\\ ", " ")
+	}
+	dir, prefix := splitCompletion(value)
+	names, ok := c.cache[dir]
+	if !ok {
+		if c.pending[dir] {
+			return nil, nil
+		}
+		c.pending[dir] = true
+		return nil, func() tea.Msg {
+			// listing errors just mean no completions
+			names, _ := c.list(dir)
+			return completionMsg{completer: c, dir: dir, names: names}
+		}
+	}
+
+	var suggestions []string
+	for _, name := range names {
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".") {
+			continue
+		}
+		suggestion := dir + name
+		if c.words {
+			suggestion = head + strings.ReplaceAll(suggestion, " ", "\\ ")
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+	return suggestions, nil
+}
+
+func (c *pathCompleter) store(msg completionMsg) {
+	c.cache[msg.dir] = msg.names
+	delete(c.pending, msg.dir)
+}
+
+func expandHome(p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return home + p[1:]
+		}
+	}
+	return p
+}
+
+func localLister(dir string) ([]string, error) {
+	d := expandHome(dir)
+	if d == "" {
+		d = "."
+	}
+	entries, err := os.ReadDir(d)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if info, err := os.Stat(filepath.Join(d, name)); err == nil && info.IsDir() {
+			name += "/"
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// sftpLister lists remote directories over an open SFTP connection
+func sftpLister(c *sftp.Client) lister {
+	return func(dir string) ([]string, error) {
+		d := dir
+		if d == "" || d == "~" || strings.HasPrefix(d, "~/") {
+			home, err := c.Getwd()
+			if err != nil {
+				return nil, err
+			}
+			d = home + strings.TrimPrefix(d, "~")
+		}
+		infos, err := c.ReadDir(d)
+		if err != nil {
+			return nil, err
+		}
+
+		var names []string
+		for _, info := range infos {
+			name := info.Name()
+			if info.IsDir() {
+				name += "/"
+			} else if info.Mode()&os.ModeSymlink != 0 {
+				if target, err := c.Stat(d + "/" + name); err == nil && target.IsDir() {
+					name += "/"
+				}
+			}
+			names = append(names, name)
+		}
+		return names, nil
+	}
+}
+
+// remoteListCommand returns the command run over ssh to list dir. A leading
+// ~/ is left unquoted so the remote shell expands it.
+func remoteListCommand(dir string) []string {
+	c := []string{"ls", "-1Ap"}
+	if dir == "" {
+		return c
+	}
+
+	home := ""
+	if strings.HasPrefix(dir, "~/") {
+		home, dir = "~/", dir[2:]
+	}
+	return append(c, "--", home+"'"+strings.ReplaceAll(dir, "'", `'\''`)+"'")
+}
+
+// sshLister lists remote directories by running ls over the connection's ssh
+// command, for hosts that can not be browsed over SFTP directly
+func sshLister(i connection.Item) lister {
+	return func(dir string) ([]string, error) {
+		c := append([]string{"ssh", "-o", "ConnectTimeout=5", "{{.FinalAddr}}"}, remoteListCommand(dir)...)
+		cmd, responders, cleanup, err := runcommand.PrepareCommand(&i, c)
+		defer cleanup()
+		if err != nil {
+			return nil, err
+		}
+		if len(responders) > 0 {
+			return nil, errors.New("completion is not available for connections that answer prompts")
+		}
+		// never prompt underneath the TUI, sshpass answers its own prompt
+		if cmd.Args[0] == "ssh" {
+			cmd.Args = slices.Insert(cmd.Args, 1, "-o", "BatchMode=yes")
+		}
+		return runLister(cmd)
+	}
+}
+
+func runLister(cmd *exec.Cmd) ([]string, error) {
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line != "" {
+			names = append(names, line)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,233 @@
+package menus
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/log"
+	"github.com/nicknickel/gossh/internal/connection"
+	internal_log "github.com/nicknickel/gossh/internal/log"
+	"github.com/nicknickel/gossh/internal/transfer"
+)
+
+func TestSplitCompletion(t *testing.T) {
+	tests := map[string][2]string{
+		"":            {"", ""},
+		"fi":          {"", "fi"},
+		"/etc/ho":     {"/etc/", "ho"},
+		"~/":          {"~/", ""},
+		"a/b/c/.hid":  {"a/b/c/", ".hid"},
+		"/var/log/x/": {"/var/log/x/", ""},
+	}
+	for value, expected := range tests {
+		dir, prefix := splitCompletion(value)
+		if dir != expected[0] || prefix != expected[1] {
+			t.Errorf("splitCompletion(%q) = %q, %q, want %q, %q", value, dir, prefix, expected[0], expected[1])
+		}
+	}
+}
+
+func TestPathCompleter(t *testing.T) {
+	calls := 0
+	c := newPathCompleter(func(dir string) ([]string, error) {
+		calls++
+		return []string{"etc/", ".ssh/", "notes.txt"}, nil
+	})
+
+	suggestions, cmd := c.suggestions("/home/user/")
+	if suggestions != nil || cmd == nil {
+		t.Fatalf("first call should list in the background")
+	}
+	if _, again := c.suggestions("/home/user/e"); again != nil {
+		t.Errorf("directory listed twice while pending")
+	}
+	c.store(cmd().(completionMsg))
+
+	suggestions, cmd = c.suggestions("/home/user/n")
+	if cmd != nil || !slices.Equal(suggestions, []string{"/home/user/etc/", "/home/user/notes.txt"}) {
+		t.Errorf("suggestions() = %v, cmd = %v", suggestions, cmd)
+	}
+	suggestions, _ = c.suggestions("/home/user/.")
+	if !slices.Contains(suggestions, "/home/user/.ssh/") {
+		t.Errorf("hidden entries missing when typing a dot: %v", suggestions)
+	}
+	if calls != 1 {
+		t.Errorf("lister called %v times, want 1", calls)
+	}
+}
+
+func TestPathCompleter_Words(t *testing.T) {
+	c := newPathCompleter(func(dir string) ([]string, error) {
+		return []string{"my notes.txt", "other.txt"}, nil
+	})
+	c.words = true
+
+	_, cmd := c.suggestions("a.txt /srv/my\\ n")
+	c.store(cmd().(completionMsg))
+	suggestions, _ := c.suggestions("a.txt /srv/my\\ n")
+	if !slices.Equal(suggestions, []string{"a.txt /srv/my\\ notes.txt", "a.txt /srv/other.txt"}) {
+		t.Errorf("suggestions() = %q", suggestions)
+	}
+}
+
+func TestLocalLister(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	os.WriteFile(filepath.Join(dir, "file"), []byte{}, 0644)
+	os.Symlink(filepath.Join(dir, "sub"), filepath.Join(dir, "link"))
+
+	names, err := localLister(dir + "/")
+	if err != nil || !slices.Equal(names, []string{"file", "link/", "sub/"}) {
+		t.Errorf("localLister() = %v, %v", names, err)
+	}
+
+	t.Setenv("HOME", dir)
+	if names, _ := localLister("~/"); !slices.Contains(names, "sub/") {
+		t.Errorf("localLister(~/) = %v", names)
+	}
+}
+
+func TestRemoteListCommand(t *testing.T) {
+	tests := map[string][]string{
+		"":          {"ls", "-1Ap"},
+		"/etc/":     {"ls", "-1Ap", "--", "'/etc/'"},
+		"~/my dir/": {"ls", "-1Ap", "--", "~/'my dir/'"},
+		"/it's/":    {"ls", "-1Ap", "--", `'/it'\''s/'`},
+	}
+	for dir, expected := range tests {
+		if got := remoteListCommand(dir); !slices.Equal(got, expected) {
+			t.Errorf("remoteListCommand(%q) = %v, want %v", dir, got, expected)
+		}
+	}
+}
+
+func TestSshLister(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	dir := t.TempDir()
+	script := "#!/bin/sh\necho \"$*\" > " + filepath.Join(dir, "args") + "\nprintf 'etc/\\nnotes.txt\\n'\n"
+	os.WriteFile(filepath.Join(dir, "ssh"), []byte(script), 0755)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	names, err := sshLister(connection.Item{Name: "web1"})("/srv/")
+	if err != nil || !slices.Equal(names, []string{"etc/", "notes.txt"}) {
+		t.Errorf("sshLister() = %v, %v", names, err)
+	}
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	if !strings.Contains(string(args), "-o BatchMode=yes -o ConnectTimeout=5 web1 ls -1Ap -- '/srv/'") {
+		t.Errorf("ssh called with %q", args)
+	}
+}
+
+// sendreceiveKeys feeds keys to the model, listing directories synchronously
+func sendreceiveKeys(m sendreceiveModel, keys ...tea.KeyMsg) sendreceiveModel {
+	for _, k := range keys {
+		model, _ := m.Update(k)
+		m = model.(sendreceiveModel)
+		for _, c := range m.completers {
+			if c == nil {
+				continue
+			}
+			for dir := range c.pending {
+				names, _ := c.list(dir)
+				model, _ = m.Update(completionMsg{completer: c, dir: dir, names: names})
+				m = model.(sendreceiveModel)
+			}
+		}
+	}
+	return m
+}
+
+func TestSendreceiveModel(t *testing.T) {
+	local := func(dir string) ([]string, error) {
+		if dir == "" {
+			return []string{"build/", "readme.md"}, nil
+		}
+		return nil, nil
+	}
+	remote := func(dir string) ([]string, error) {
+		if dir == "/srv/" {
+			return []string{"www/"}, nil
+		}
+		return []string{"srv/", "tmp/"}, nil
+	}
+
+	m := newSendreceiveModel("", transfer.Send, remote)
+	m.completers[inputSrc] = newPathCompleter(local)
+	tab := tea.KeyMsg{Type: tea.KeyTab}
+	enter := tea.KeyMsg{Type: tea.KeyEnter}
+
+	// tab accepts the local completion
+	m = sendreceiveKeys(m, runes("b"), tab)
+	if m.inputs[inputSrc].Value() != "build/" || m.focusedInput != 0 {
+		t.Errorf("src = %q, focused = %v", m.inputs[inputSrc].Value(), m.focusedInput)
+	}
+
+	// with nothing to complete tab moves to the remote input
+	m = sendreceiveKeys(m, tab)
+	if m.focusedInput != 1 {
+		t.Fatalf("tab did not move to the destination")
+	}
+	m = sendreceiveKeys(m, runes("/s"), tab, runes("w"), tab)
+	if m.inputs[inputDest].Value() != "/srv/www/" {
+		t.Errorf("dest = %q, want /srv/www/", m.inputs[inputDest].Value())
+	}
+
+	m = sendreceiveKeys(m, enter)
+	if !m.submitted || len(m.inputs) != 2 {
+		t.Errorf("expected prompt to be submitted without a layout")
+	}
+
+	m.inputs[inputSrc].SetValue(`build/ *.md "my file"`)
+	if r := m.Request(); !r.Globs || !slices.Equal(r.Sources, []string{"build/", "*.md", "my file"}) {
+		t.Errorf("Request() = %+v", r)
+	}
+}
+
+func TestSendreceiveModel_Layout(t *testing.T) {
+	t.Setenv("GOSSH_RECEIVE_LAYOUT", "dir")
+	m := newSendreceiveModel("", transfer.Receive, nil)
+	if m.inputs[inputLayout].Value() != "dir" {
+		t.Fatalf("layout = %q, want the GOSSH_RECEIVE_LAYOUT default", m.inputs[inputLayout].Value())
+	}
+
+	enter := tea.KeyMsg{Type: tea.KeyEnter}
+	m = sendreceiveKeys(m, runes("/var/log/syslog"), tea.KeyMsg{Type: tea.KeyDown}, runes("logs"), tea.KeyMsg{Type: tea.KeyDown})
+	if m.focusedInput != inputLayout {
+		t.Fatalf("focused = %v, want the layout input", m.focusedInput)
+	}
+
+	// an invalid template is reported instead of submitted
+	m.inputs[inputLayout].SetValue("{{.Hots}}")
+	m = sendreceiveKeys(m, enter)
+	if m.submitted || m.err == nil || !strings.Contains(m.View(), "Hots") {
+		t.Errorf("invalid layout submitted = %v, err = %v", m.submitted, m.err)
+	}
+
+	m.inputs[inputLayout].SetValue("prefix")
+	m = sendreceiveKeys(m, enter)
+	r := m.Request()
+	if !m.submitted || r.Layout != "prefix" || r.Dest != "logs" || !slices.Equal(r.Sources, []string{"/var/log/syslog"}) {
+		t.Errorf("Request() = %+v", r)
+	}
+}
+
+func TestSendreceiveModel_Cancel(t *testing.T) {
+	m := newSendreceiveModel("could not browse", transfer.Receive, nil)
+	if m.completers[inputSrc] != nil || m.completers[inputDest] == nil {
+		t.Errorf("receive without a remote lister should only complete the local destination")
+	}
+	if !strings.Contains(m.View(), "could not browse") {
+		t.Errorf("View() missing note")
+	}
+
+	m = sendreceiveKeys(m, runes("/var/log/syslog"), tea.KeyMsg{Type: tea.KeyEsc})
+	if m.submitted {
+		t.Errorf("esc should not submit")
+	}
+}
@@ -0,0 +1,312 @@
+package menus
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/nicknickel/gossh/internal/config"
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/usage"
+)
+
+const (
+	ActionAddConnection       = "AddConnection"
+	ActionEditConnection      = "EditConnection"
+	ActionDuplicateConnection = "DuplicateConnection"
+	ActionDeleteConnection    = "DeleteConnection"
+)
+
+// IsManageAction reports whether the action changes the config files, after
+// which the connection list is shown again
+func IsManageAction(action string) bool {
+	return slices.Contains([]string{ActionAddConnection, ActionEditConnection, ActionDuplicateConnection, ActionDeleteConnection}, action)
+}
+
+// formLabels describe the inputs of the connection form
+var formLabels = map[string]string{
+	"file":       "Config file",
+	"name":       "Name",
+	"address":    "Address (host or host:port)",
+	"user":       "User",
+	"comment":    "Comment",
+	"identity":   "Identity file or secret reference",
+	"passfile":   "Password file",
+	"password":   "Password secret reference",
+	"totp":       "TOTP seed file or secret reference",
+	"sshprogram": "SSH program",
+	"hostkey":    "Pinned host key (SHA256:...)",
+	"tags":       "Tags (comma separated)",
+	"group":      "Group",
+	"folder":     "Folder (path/like/this)",
+}
+
+type connectionformModel struct {
+	title string
+	// file is the config file of the connection when it is not asked for,
+	// which relative paths are resolved against
+	file    string
+	keys    []string
+	inputs  []textinput.Model
+	focused int
+	// names of the other connections, which the name must not be
+	names     []string
+	submitted bool
+	err       error
+}
+
+// newConnectionformModel asks for the name and editable keys, and the
+// config file when adding a connection
+func newConnectionformModel(title string, values map[string]string, askFile bool, names []string) connectionformModel {
+	m := connectionformModel{title: title, names: names, file: values["file"]}
+	if askFile {
+		m.keys = append(m.keys, "file")
+	}
+	m.keys = append(m.keys, "name")
+	m.keys = append(m.keys, config.EditableKeys...)
+	for _, k := range m.keys {
+		ti := textinput.New()
+		ti.Prompt = ""
+		ti.CharLimit = 1024
+		ti.Width = 60
+		ti.SetValue(values[k])
+		m.inputs = append(m.inputs, ti)
+	}
+	m.focus(0)
+	return m
+}
+
+func (m *connectionformModel) focus(input int) {
+	m.focused = (input + len(m.inputs)) % len(m.inputs)
+	for ind := range m.inputs {
+		if ind == m.focused {
+			m.inputs[ind].Focus()
+		} else {
+			m.inputs[ind].Blur()
+		}
+	}
+}
+
+// Values is what was typed by key
+func (m connectionformModel) Values() map[string]string {
+	values := map[string]string{}
+	for ind, k := range m.keys {
+		values[k] = strings.TrimSpace(m.inputs[ind].Value())
+	}
+	return values
+}
+
+func (m connectionformModel) validate() error {
+	values := m.Values()
+	var errs []error
+	switch name := values["name"]; {
+	case name == "":
+		errs = append(errs, errors.New("name: must be set"))
+	case slices.Contains(m.names, name):
+		errs = append(errs, fmt.Errorf("name: %v already exists", name))
+	}
+	file := m.file
+	if slices.Contains(m.keys, "file") {
+		file = values["file"]
+		if file == "" {
+			errs = append(errs, errors.New("file: must be set"))
+		}
+	}
+	errs = append(errs, config.ValidateConnection(file, values))
+	return errors.Join(errs...)
+}
+
+func (m connectionformModel) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (m connectionformModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "ctrl+c", "esc":
+			return m, tea.Quit
+		case "enter":
+			if m.err = m.validate(); m.err != nil {
+				return m, nil
+			}
+			m.submitted = true
+			return m, tea.Quit
+		case "tab", "down":
+			m.focus(m.focused + 1)
+			return m, nil
+		case "shift+tab", "up":
+			m.focus(m.focused - 1)
+			return m, nil
+		}
+	}
+
+	var cmd tea.Cmd
+	m.inputs[m.focused], cmd = m.inputs[m.focused].Update(msg)
+	return m, cmd
+}
+
+func (m connectionformModel) View() string {
+	lines := []string{StyleTitle(m.title), ""}
+	for ind, k := range m.keys {
+		label := previewKeyStyle.Width(36).Render(formLabels[k])
+		lines = append(lines, label+m.inputs[ind].View())
+	}
+	if m.err != nil {
+		lines = append(lines, "", m.err.Error())
+	}
+	lines = append(lines, "", "(tab or up/down to move, enter to save, esc to cancel)")
+	return globalStyle(strings.Join(lines, "\n") + "\n")
+}
+
+// confirmModel asks a yes or no question
+type confirmModel struct {
+	question  string
+	confirmed bool
+}
+
+func (m confirmModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m confirmModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		m.confirmed = strings.ToLower(msg.String()) == "y"
+		return m, tea.Quit
+	}
+	return m, nil
+}
+
+func (m confirmModel) View() string {
+	return globalStyle(m.question + " [y/N]\n")
+}
+
+// definedOnce refuses connections defined in several config files, whose
+// earlier definition would reappear once the last is renamed or deleted
+func definedOnce(name string) error {
+	if files := config.ConnectionFiles(name); len(files) > 1 {
+		return fmt.Errorf("cannot change %v, it is defined in %v; edit those files instead", name, strings.Join(files, ", "))
+	}
+	return nil
+}
+
+// names are the connections other than except
+func names(items []list.Item, except string) []string {
+	var n []string
+	for _, val := range items {
+		if name := val.(connection.Item).Name; name != except {
+			n = append(n, name)
+		}
+	}
+	return n
+}
+
+func runForm(m connectionformModel) (map[string]string, bool, error) {
+	fm, err := tea.NewProgram(m, tea.WithAltScreen()).Run()
+	if err != nil {
+		return nil, false, err
+	}
+	form := fm.(connectionformModel)
+	return form.Values(), form.submitted, nil
+}
+
+// Manage runs the form or confirmation of the list's add, edit, duplicate or
+// delete action and writes the change to the connection's config file. It
+// returns what was done.
+func Manage(lm *connectionlistModel) (string, error) {
+	items := lm.list.Items()
+	checked := lm.GetCheckedItems()
+	for _, i := range checked {
+		if lm.Action == ActionAddConnection {
+			break
+		}
+		if err := config.Writable(i.Conn.Source); err != nil {
+			return "", fmt.Errorf("cannot change %v: %w", i.Name, err)
+		}
+		if lm.Action == ActionDuplicateConnection {
+			continue
+		}
+		if err := definedOnce(i.Name); err != nil {
+			return "", err
+		}
+	}
+
+	switch lm.Action {
+	case ActionAddConnection:
+		file := config.DefaultConfigFile()
+		if i, ok := lm.selected(); ok && i.Conn.Source != "" {
+			file = i.Conn.Source
+		}
+		values, ok, err := runForm(newConnectionformModel("New connection", map[string]string{"file": file}, true, names(items, "")))
+		if err != nil || !ok {
+			return "", err
+		}
+		if err := config.SaveConnection(values["file"], "", values["name"], values); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("added %v to %v", values["name"], values["file"]), nil
+
+	case ActionEditConnection, ActionDuplicateConnection:
+		if len(checked) != 1 {
+			return "", fmt.Errorf("edit and duplicate change one connection, %v are checked", len(checked))
+		}
+		i := checked[0]
+		file := i.Conn.Source
+		values, err := config.ConnectionValues(file, i.Name)
+		if err != nil {
+			return "", err
+		}
+		values["file"] = file
+		title, others := "Edit "+i.Name, names(items, i.Name)
+		if lm.Action == ActionDuplicateConnection {
+			values["name"] = i.Name + "-copy"
+			title, others = "Duplicate "+i.Name, names(items, "")
+		} else {
+			values["name"] = i.Name
+		}
+		values, ok, err := runForm(newConnectionformModel(title, values, false, others))
+		if err != nil || !ok {
+			return "", err
+		}
+		values["file"] = file
+
+		if lm.Action == ActionDuplicateConnection {
+			if err := config.DuplicateConnection(file, i.Name, values["name"]); err != nil {
+				return "", err
+			}
+			if err := config.SaveConnection(file, values["name"], values["name"], values); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("duplicated %v as %v", i.Name, values["name"]), nil
+		}
+		if err := config.SaveConnection(file, i.Name, values["name"], values); err != nil {
+			return "", err
+		}
+		if values["name"] != i.Name {
+			if err := usage.Rename(i.Name, values["name"]); err != nil {
+				return "", err
+			}
+		}
+		return "saved " + values["name"], nil
+
+	case ActionDeleteConnection:
+		var n []string
+		for _, i := range checked {
+			n = append(n, i.Name)
+		}
+		cm, err := tea.NewProgram(confirmModel{question: "Delete " + strings.Join(n, ", ") + "?"}, tea.WithAltScreen()).Run()
+		if err != nil || !cm.(confirmModel).confirmed {
+			return "", err
+		}
+		for _, i := range checked {
+			if err := config.DeleteConnection(i.Conn.Source, i.Name); err != nil {
+				return "", err
+			}
+		}
+		return "deleted " + strings.Join(n, ", "), nil
+	}
+	return "", fmt.Errorf("unknown action %v", lm.Action)
+}
@@ -0,0 +1,156 @@
+package menus
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/usage"
+)
+
+func TestConnectionformModel(t *testing.T) {
+	tab := tea.KeyMsg{Type: tea.KeyTab}
+	enter := tea.KeyMsg{Type: tea.KeyEnter}
+	backspace := tea.KeyMsg{Type: tea.KeyBackspace}
+
+	tests := []struct {
+		name      string
+		values    map[string]string
+		keys      []tea.KeyMsg
+		submitted bool
+		err       string
+		expected  map[string]string
+	}{
+		{name: "no name", keys: []tea.KeyMsg{enter}, err: "name: must be set"},
+		{name: "existing name", keys: []tea.KeyMsg{runes("web2"), enter}, err: "name: web2 already exists"},
+		{name: "bad address", keys: []tea.KeyMsg{runes("web3"), tab, runes("web 3"), enter}, err: "address:"},
+		{name: "added", keys: []tea.KeyMsg{runes("web3"), tab, runes("10.0.0.3:2222"), tab, runes("ops"), enter}, submitted: true,
+			expected: map[string]string{"name": "web3", "address": "10.0.0.3:2222", "user": "ops"}},
+		{name: "renamed", values: map[string]string{"name": "web1", "user": "ops"}, keys: []tea.KeyMsg{backspace, runes("0"), enter}, submitted: true,
+			expected: map[string]string{"name": "web0", "user": "ops"}},
+		{name: "wraps to tags", values: map[string]string{"name": "web1"}, keys: []tea.KeyMsg{{Type: tea.KeyShiftTab}, {Type: tea.KeyUp}, {Type: tea.KeyUp}, runes("a b"), enter}, err: "tags:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m tea.Model = newConnectionformModel("Edit", tt.values, false, []string{"web2"})
+			for _, k := range tt.keys {
+				m, _ = m.Update(k)
+			}
+			form := m.(connectionformModel)
+			if form.submitted != tt.submitted {
+				t.Errorf("submitted = %v, want %v (%v)", form.submitted, tt.submitted, form.err)
+			}
+			if tt.err != "" && (form.err == nil || !strings.Contains(form.err.Error(), tt.err)) {
+				t.Errorf("err = %v, want %v", form.err, tt.err)
+			}
+			values := form.Values()
+			for k, v := range tt.expected {
+				if values[k] != v {
+					t.Errorf("%v = %q, want %q", k, values[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestConnectionformModel_File(t *testing.T) {
+	m := newConnectionformModel("New connection", map[string]string{"file": ""}, true, nil)
+	m.inputs[1].SetValue("web1")
+	if err := m.validate(); err == nil || !strings.Contains(err.Error(), "file: must be set") {
+		t.Errorf("validate() = %v", err)
+	}
+	if !strings.Contains(m.View(), "Config file") {
+		t.Errorf("View() does not ask for the file:\n%v", m.View())
+	}
+}
+
+func TestConnectionformModel_RelativePaths(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "keys"), 0700)
+	os.WriteFile(filepath.Join(dir, "keys", "web1"), []byte("key"), 0600)
+	t.Chdir(t.TempDir())
+
+	// relative paths are resolved against the connection's file, not the
+	// working directory
+	m := newConnectionformModel("Edit web1", map[string]string{"file": filepath.Join(dir, "hosts.yml"), "name": "web1", "identity": "keys/web1"}, false, nil)
+	if err := m.validate(); err != nil {
+		t.Errorf("validate() = %v", err)
+	}
+	m.inputs[slices.Index(m.keys, "identity")].SetValue("keys/missing")
+	if err := m.validate(); err == nil || !strings.Contains(err.Error(), filepath.Join(dir, "keys", "missing")) {
+		t.Errorf("validate() missing = %v", err)
+	}
+}
+
+func TestDefinedOnce(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GOSSH_CONFIGDIR", dir)
+	os.WriteFile(filepath.Join(dir, "a.yml"), []byte("web:\n  address: 1.2.3.4\ndb:\n  address: 2.3.4.5\n"), 0600)
+	os.WriteFile(filepath.Join(dir, "b.yml"), []byte("web:\n  address: 5.6.7.8\n"), 0600)
+
+	if err := definedOnce("db"); err != nil {
+		t.Errorf("definedOnce(db) = %v, want nil", err)
+	}
+	err := definedOnce("web")
+	if err == nil || !strings.Contains(err.Error(), "a.yml") || !strings.Contains(err.Error(), "b.yml") {
+		t.Errorf("definedOnce(web) = %v, want both files listed", err)
+	}
+}
+
+func TestConnectionlistModel_ManageKeys(t *testing.T) {
+	tests := []struct {
+		key     tea.KeyMsg
+		action  string
+		checked int
+	}{
+		{key: runes("n"), action: ActionAddConnection},
+		{key: runes("e"), action: ActionEditConnection, checked: 1},
+		{key: runes("C"), action: ActionDuplicateConnection, checked: 1},
+		{key: runes("X"), action: ActionDeleteConnection, checked: 1},
+		{key: tea.KeyMsg{Type: tea.KeyDelete}, action: ActionDeleteConnection, checked: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			m := newConnectionlistModel([]list.Item{
+				connection.Item{Name: "web1", Index: 0},
+				connection.Item{Name: "web2", Index: 1},
+			}, usage.State{})
+			model, _ := m.Update(tt.key)
+			lm := model.(connectionlistModel)
+			if lm.Action != tt.action || lm.CheckedCount != tt.checked || !IsManageAction(lm.Action) {
+				t.Errorf("%v = %v with %v checked, want %v with %v", tt.key, lm.Action, lm.CheckedCount, tt.action, tt.checked)
+			}
+		})
+	}
+
+	// edit and duplicate refuse several checked connections or a folder
+	m := newConnectionlistModel([]list.Item{
+		connection.Item{Name: "web1", Index: 0},
+		connection.Item{Name: "web2", Index: 1},
+	}, usage.State{})
+	model, _ := m.Update(runes("a"))
+	model, _ = model.Update(runes("e"))
+	if lm := model.(connectionlistModel); lm.Action != "" || lm.CheckedCount != 2 {
+		t.Errorf("e with two checked = %v with %v checked", lm.Action, lm.CheckedCount)
+	}
+	t.Setenv("GOSSH_VIEW", "tree")
+	t.Setenv("GOSSH_TREE_GROUP", "folder")
+	m = newConnectionlistModel(treeTestItems(), usage.State{})
+	m.tree.height = 20
+	model, _ = m.Update(runes("C"))
+	if lm := model.(connectionlistModel); lm.Action != "" || lm.CheckedCount != 0 {
+		t.Errorf("C on a folder = %v with %v checked", lm.Action, lm.CheckedCount)
+	}
+
+	if IsManageAction("Connect") {
+		t.Errorf("Connect is a manage action")
+	}
+}
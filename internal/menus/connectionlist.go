@@ -1,7 +1,8 @@
 package menus
 
 import (
-	"strings"
+	"slices"
+	"time"
 
 	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list"
@@ -9,19 +10,154 @@ import (
 	"github.com/charmbracelet/lipgloss"
 	"github.com/nicknickel/gossh/internal/config"
 	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/facts"
+	"github.com/nicknickel/gossh/internal/health"
+	"github.com/nicknickel/gossh/internal/log"
+	"github.com/nicknickel/gossh/internal/usage"
 )
 
 type connectionlistModel struct {
 	list         list.Model
 	CheckedCount int
 	Action       string
+
+	filterMode string
+
+	// usage orders the connections by sortMode, favorites first
+	usage    usage.State
+	sortMode string
+
+	tree treeModel
+
+	// the details pane of the highlighted connection
+	preview     bool
+	resolutions map[string]resolution
+
+	width  int
+	height int
+
+	// status is shown when the list opens, like the result of an edit
+	status string
+
+	// health checks, off when healthMode is health.ModeOff
+	healthMode     string
+	healthInterval time.Duration
+}
+
+// healthMsg carries the results of checking the visible connections
+type healthMsg []health.Result
+
+type healthTickMsg time.Time
+
+// checkHealth probes the visible connections in the background
+func (m connectionlistModel) checkHealth() tea.Cmd {
+	var items []connection.Item
+	for _, val := range m.list.VisibleItems() {
+		items = append(items, val.(connection.Item))
+	}
+	banner := m.healthMode == health.ModeSSH
+	return func() tea.Msg {
+		return healthMsg(health.CheckAll(items, banner))
+	}
+}
+
+const listTitle = "Go SSH Connection Manager"
+
+// resort orders the items by the sort mode and keeps the selected
+// connection selected
+func (m *connectionlistModel) resort() tea.Cmd {
+	var selected string
+	if i, ok := m.list.SelectedItem().(connection.Item); ok {
+		selected = i.Name
+	}
+	items := usage.Sort(m.list.Items(), m.sortMode, m.usage, time.Now())
+	cmd := m.list.SetItems(items)
+	if m.list.FilterState() == list.Unfiltered {
+		for ind, val := range items {
+			if val.(connection.Item).Name == selected {
+				m.list.Select(ind)
+			}
+		}
+	}
+	m.setTitle()
+	return cmd
+}
+
+// setTitle shows the sort mode unless sorting alphabetically
+func (m *connectionlistModel) setTitle() {
+	m.list.Title = listTitle
+	if m.sortMode != usage.SortAlpha {
+		m.list.Title += " · " + m.sortMode
+	}
+}
+
+// setChecked checks or unchecks the connection in the list
+func (m *connectionlistModel) setChecked(i connection.Item, checked bool) {
+	i = m.list.Items()[i.Index].(connection.Item)
+	if i.Checked == checked {
+		return
+	}
+	i.Checked = checked
+	if checked {
+		m.CheckedCount++
+	} else {
+		m.CheckedCount--
+	}
+	m.list.SetItem(i.Index, i)
+}
+
+// selected is the connection under the cursor, none when the tree cursor is
+// on a folder
+func (m connectionlistModel) selected() (connection.Item, bool) {
+	if !m.tree.enabled {
+		i, ok := m.list.SelectedItem().(connection.Item)
+		return i, ok
+	}
+	rows := m.treeRows()
+	if m.tree.cursor >= len(rows) || rows[m.tree.cursor].node != nil {
+		return connection.Item{}, false
+	}
+	return m.list.Items()[rows[m.tree.cursor].item.Index].(connection.Item), true
+}
+
+// quitWith ends the list with the action, on the connection under the
+// cursor (or every connection of the folder) when none are checked
+func (m connectionlistModel) quitWith(action string) (tea.Model, tea.Cmd) {
+	if m.CheckedCount == 0 {
+		if i, ok := m.selected(); ok {
+			m.setChecked(i, true)
+		} else if m.tree.enabled {
+			m.checkAll(m.treeFolder(m.treeRows()))
+		}
+	}
+	if m.CheckedCount == 0 {
+		return m, nil
+	}
+	m.Action = action
+	return m, tea.Quit
 }
 
 func (m connectionlistModel) Init() tea.Cmd {
-	return nil
+	var cmd tea.Cmd
+	if m.status != "" {
+		cmd = m.list.NewStatusMessage(m.status)
+	}
+	if m.healthMode == health.ModeOff {
+		return cmd
+	}
+	return tea.Batch(cmd, m.checkHealth())
 }
 
 func (m connectionlistModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	model, cmd := m.update(msg)
+	lm := model.(connectionlistModel)
+	if lm.Action != "" {
+		return lm, cmd
+	}
+	return lm, tea.Batch(cmd, lm.resolveSelected())
+}
+
+func (m connectionlistModel) update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		if msg.String() == "ctrl+c" {
@@ -30,36 +166,96 @@ func (m connectionlistModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if m.list.FilterState() == list.Filtering {
 			break
 		}
-		if key.Matches(msg, connectionListKeyBindings.ShowAuth) {
-			m.Action = "ShowAuth"
-			if m.CheckedCount == 0 {
-				i := m.list.SelectedItem().(connection.Item)
-				i.Checked = true
-				m.CheckedCount++
-				m.list.SetItem(m.list.GlobalIndex(), i)
+		if m.tree.enabled {
+			tm, cmd, handled := m.updateTree(msg)
+			if handled {
+				return tm, cmd
 			}
-			return m, tea.Quit
+			m = tm
 		}
-		if key.Matches(msg, connectionListKeyBindings.Choose) {
-			m.Action = "Connect"
-			if m.CheckedCount == 0 {
-				i := m.list.SelectedItem().(connection.Item)
-				i.Checked = true
-				m.CheckedCount++
-				m.list.SetItem(m.list.GlobalIndex(), i)
+		if key.Matches(msg, connectionListKeyBindings.Preview) {
+			m.preview = !m.preview
+			m.layout()
+			return m, nil
+		}
+		if key.Matches(msg, connectionListKeyBindings.ViewMode) {
+			m.tree.enabled = !m.tree.enabled
+			if m.tree.enabled && m.tree.filter.Value() == "" {
+				m.tree.filter.SetValue(m.list.FilterValue())
+			}
+			m.tree.scroll(len(m.treeRows()))
+			return m, nil
+		}
+		if key.Matches(msg, connectionListKeyBindings.TreeGroup) {
+			ind := slices.Index(treeGroupings, m.tree.grouping)
+			m.tree.grouping = treeGroupings[(ind+1)%len(treeGroupings)]
+			m.tree.enabled = true
+			m.tree.cursor, m.tree.offset = 0, 0
+			return m, nil
+		}
+		if key.Matches(msg, connectionListKeyBindings.FilterMode) {
+			if m.filterMode == FilterFuzzy {
+				m.filterMode = FilterStrict
+			} else {
+				m.filterMode = FilterFuzzy
+			}
+			m.list.Filter = filterFuncFor(m.filterMode)
+			fv := m.list.FilterValue()
+			if fv != "" {
+				m.list.SetFilterText(fv)
+			}
+			return m, m.list.NewStatusMessage(m.filterMode + " filter")
+		}
+		if key.Matches(msg, connectionListKeyBindings.SortMode) {
+			m.sortMode = usage.NextSort(m.sortMode)
+			if err := usage.SaveSort(m.sortMode); err != nil {
+				log.Logger.Error("Could not save the sort mode", "err", err)
+			}
+			cmd := m.resort()
+			return m, tea.Batch(cmd, m.list.NewStatusMessage("sorted by "+m.sortMode))
+		}
+		if key.Matches(msg, connectionListKeyBindings.Favorite) {
+			i, ok := m.selected()
+			if !ok {
+				return m, nil
+			}
+			favorite, err := usage.ToggleFavorite(i.Name)
+			if err != nil {
+				log.Logger.Error("Could not save the favorites", "err", err)
+				return m, m.list.NewStatusMessage("could not save the favorites")
+			}
+			i.Favorite = favorite
+			m.list.SetItem(i.Index, i)
+			cmd := m.resort()
+			fv := m.list.FilterValue()
+			if fv != "" {
+				m.list.SetFilterText(fv)
 			}
+			return m, cmd
+		}
+		if key.Matches(msg, connectionListKeyBindings.AddConnection) {
+			m.Action = ActionAddConnection
 			return m, tea.Quit
 		}
+		if key.Matches(msg, connectionListKeyBindings.EditConnection, connectionListKeyBindings.DuplicateConnection) {
+			// a folder or several checked connections can not be edited at once
+			if _, ok := m.selected(); m.CheckedCount > 1 || (m.CheckedCount == 0 && !ok) {
+				return m, m.list.NewStatusMessage("check or highlight a single connection to edit or duplicate")
+			}
+			if key.Matches(msg, connectionListKeyBindings.EditConnection) {
+				return m.quitWith(ActionEditConnection)
+			}
+			return m.quitWith(ActionDuplicateConnection)
+		}
+		if key.Matches(msg, connectionListKeyBindings.DeleteConnection) {
+			return m.quitWith(ActionDeleteConnection)
+		}
+		if key.Matches(msg, connectionListKeyBindings.Choose) {
+			return m.quitWith("Connect")
+		}
 		if key.Matches(msg, connectionListKeyBindings.Select) {
 			i := m.list.SelectedItem().(connection.Item)
-			if i.Checked {
-				i.Checked = false
-				m.CheckedCount--
-			} else {
-				i.Checked = true
-				m.CheckedCount++
-			}
-			m.list.SetItem(m.list.GlobalIndex(), i)
+			m.setChecked(i, !i.Checked)
 			fv := m.list.FilterValue()
 			if fv != "" {
 				m.list.SetFilterText(fv)
@@ -74,15 +270,7 @@ func (m connectionlistModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				checkAll = false
 			}
 			for _, val := range m.list.VisibleItems() {
-				connItem := val.(connection.Item)
-				if !connItem.Checked && checkAll {
-					connItem.Checked = true
-					m.CheckedCount++
-				} else if connItem.Checked && !checkAll {
-					connItem.Checked = false
-					m.CheckedCount--
-				}
-				m.list.SetItem(connItem.Index, connItem)
+				m.setChecked(val.(connection.Item), checkAll)
 			}
 			// the below is required for some reason
 			// without it the list does re-render to show the x marks
@@ -92,39 +280,67 @@ func (m connectionlistModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.list.SetFilterText(fv)
 			}
 		}
+		if key.Matches(msg, connectionListKeyBindings.ShowAuth) {
+			return m.quitWith("ShowAuth")
+		}
 		if key.Matches(msg, connectionListKeyBindings.RunCommand) {
-			m.Action = "RunCommand"
-			if m.CheckedCount == 0 {
-				i := m.list.SelectedItem().(connection.Item)
-				i.Checked = true
-				m.CheckedCount++
-				m.list.SetItem(m.list.GlobalIndex(), i)
-			}
-			return m, tea.Quit
+			return m.quitWith("RunCommand")
 		}
 		if key.Matches(msg, connectionListKeyBindings.SendFile) {
-			m.Action = "SendFile"
-			if m.CheckedCount == 0 {
-				i := m.list.SelectedItem().(connection.Item)
-				i.Checked = true
-				m.CheckedCount++
-				m.list.SetItem(m.list.GlobalIndex(), i)
-			}
-			return m, tea.Quit
+			return m.quitWith("SendFile")
 		}
 		if key.Matches(msg, connectionListKeyBindings.ReceiveFile) {
-			m.Action = "ReceiveFile"
-			if m.CheckedCount == 0 {
-				i := m.list.SelectedItem().(connection.Item)
-				i.Checked = true
-				m.CheckedCount++
-				m.list.SetItem(m.list.GlobalIndex(), i)
-			}
-			return m, tea.Quit
+			return m.quitWith("ReceiveFile")
+		}
+		if key.Matches(msg, connectionListKeyBindings.Sync) {
+			return m.quitWith("Sync")
+		}
+		if key.Matches(msg, connectionListKeyBindings.Tunnels) {
+			return m.quitWith("Tunnels")
+		}
+		if key.Matches(msg, connectionListKeyBindings.SocksProxy) {
+			return m.quitWith("SocksProxy")
+		}
+		if key.Matches(msg, connectionListKeyBindings.GatherFacts) {
+			return m.quitWith("GatherFacts")
+		}
+		if key.Matches(msg, connectionListKeyBindings.Keyscan) {
+			return m.quitWith("Keyscan")
+		}
+		if key.Matches(msg, connectionListKeyBindings.RotatePassword) {
+			return m.quitWith("RotatePassword")
+		}
+		if m.tree.enabled {
+			return m, nil
 		}
 	case tea.WindowSizeMsg:
 		h, v := docStyle.GetFrameSize()
-		m.list.SetSize(msg.Width-h, msg.Height-v)
+		m.width, m.height = msg.Width-h, msg.Height-v
+		m.layout()
+	case resolutionMsg:
+		m.resolutions[msg.name] = msg.resolution
+		return m, nil
+	case healthMsg:
+		results := map[string]connection.Health{}
+		for _, r := range msg {
+			results[r.Name] = r.Health
+		}
+		items := m.list.Items()
+		for ind, val := range items {
+			connItem := val.(connection.Item)
+			if h, ok := results[connItem.Name]; ok {
+				connItem.Health = h
+				items[ind] = connItem
+			}
+		}
+		// SetItems refilters so status: filters follow the new states
+		cmd := m.list.SetItems(items)
+		interval := m.healthInterval
+		return m, tea.Batch(cmd, tea.Tick(interval, func(t time.Time) tea.Msg {
+			return healthTickMsg(t)
+		}))
+	case healthTickMsg:
+		return m, m.checkHealth()
 	}
 
 	var cmd tea.Cmd
@@ -133,7 +349,20 @@ func (m connectionlistModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 }
 
 func (m connectionlistModel) View() string {
-	return globalStyle(m.list.View())
+	view := m.list.View()
+	if m.tree.enabled {
+		view = m.treeView()
+	}
+	if m.preview {
+		if m.previewSide() {
+			w := m.width * 3 / 5
+			view = lipgloss.PlaceHorizontal(w, lipgloss.Left, lipgloss.NewStyle().MaxWidth(w).Render(view))
+			view = lipgloss.JoinHorizontal(lipgloss.Top, view, m.previewView())
+		} else {
+			view = lipgloss.JoinVertical(lipgloss.Left, view, m.previewView())
+		}
+	}
+	return globalStyle(view)
 }
 
 func (m connectionlistModel) GetCheckedItems() []connection.Item {
@@ -148,47 +377,35 @@ func (m connectionlistModel) GetCheckedItems() []connection.Item {
 	return connItems
 }
 
-func FilterFunc(t string, items []string) []list.Rank {
-	var results []list.Rank
-	terms := strings.Split(t, " ")
-
-	for i, item := range items {
-		termsMatched := 0
-		// want to make sure all space separated search words
-		// are found in one of the fields
-		for _, term := range terms {
-			// Splitting the FilterValue as it is space separated by
-			// i.Name + " " + i.Conn.Address + " " + i.Conn.User + " " + i.Conn.Description
-			searchFields := strings.SplitN(item, " ", 4)
-			for _, field := range searchFields {
-				if index := strings.Index(strings.ToLower(field), strings.ToLower(term)); index > -1 {
-					termsMatched++
-					break // term exists in one of the fields so don't need to keep looking
-				}
-			}
-
-		}
-
-		if termsMatched == len(terms) {
-			results = append(results, list.Rank{Index: i, MatchedIndexes: nil})
-		}
-	}
-
-	return results
-}
-
 type connectionListKeyMap struct {
-	Choose      key.Binding
-	Select      key.Binding
-	SelectAll   key.Binding
-	ShowAuth    key.Binding
-	RunCommand  key.Binding
-	SendFile    key.Binding
-	ReceiveFile key.Binding
+	Choose         key.Binding
+	Select         key.Binding
+	SelectAll      key.Binding
+	ShowAuth       key.Binding
+	RunCommand     key.Binding
+	SendFile       key.Binding
+	ReceiveFile    key.Binding
+	Sync           key.Binding
+	Tunnels        key.Binding
+	SocksProxy     key.Binding
+	GatherFacts    key.Binding
+	Keyscan        key.Binding
+	RotatePassword key.Binding
+	FilterMode     key.Binding
+	SortMode       key.Binding
+	Favorite       key.Binding
+	ViewMode       key.Binding
+	TreeGroup      key.Binding
+	Preview        key.Binding
+
+	AddConnection       key.Binding
+	EditConnection      key.Binding
+	DuplicateConnection key.Binding
+	DeleteConnection    key.Binding
 }
 
 func (c *connectionListKeyMap) AdditionalKeys() []key.Binding {
-	return []key.Binding{c.Choose, c.Select, c.SelectAll, c.ShowAuth, c.RunCommand, c.SendFile, c.ReceiveFile}
+	return []key.Binding{c.Choose, c.Select, c.SelectAll, c.ShowAuth, c.RunCommand, c.SendFile, c.ReceiveFile, c.Sync, c.Tunnels, c.SocksProxy, c.GatherFacts, c.Keyscan, c.RotatePassword, c.FilterMode, c.SortMode, c.Favorite, c.ViewMode, c.TreeGroup, c.Preview, c.AddConnection, c.EditConnection, c.DuplicateConnection, c.DeleteConnection}
 }
 
 var connectionListKeyBindings = connectionListKeyMap{
@@ -220,9 +437,73 @@ var connectionListKeyBindings = connectionListKeyMap{
 		key.WithKeys("r"),
 		key.WithHelp("r", "receive-file"),
 	),
+	Sync: key.NewBinding(
+		key.WithKeys("y"),
+		key.WithHelp("y", "sync"),
+	),
+	Tunnels: key.NewBinding(
+		key.WithKeys("t"),
+		key.WithHelp("t", "tunnels"),
+	),
+	SocksProxy: key.NewBinding(
+		key.WithKeys("x"),
+		key.WithHelp("x", "socks-proxy"),
+	),
+	GatherFacts: key.NewBinding(
+		key.WithKeys("i"),
+		key.WithHelp("i", "gather-facts"),
+	),
+	Keyscan: key.NewBinding(
+		key.WithKeys("K"),
+		key.WithHelp("K", "keyscan"),
+	),
+	RotatePassword: key.NewBinding(
+		key.WithKeys("p"),
+		key.WithHelp("p", "rotate-password"),
+	),
+	FilterMode: key.NewBinding(
+		key.WithKeys("F"),
+		key.WithHelp("F", "fuzzy/strict filter"),
+	),
+	SortMode: key.NewBinding(
+		key.WithKeys("S"),
+		key.WithHelp("S", "sort mode"),
+	),
+	Favorite: key.NewBinding(
+		key.WithKeys("*"),
+		key.WithHelp("*", "favorite"),
+	),
+	ViewMode: key.NewBinding(
+		key.WithKeys("v"),
+		key.WithHelp("v", "list/tree view"),
+	),
+	TreeGroup: key.NewBinding(
+		key.WithKeys("T"),
+		key.WithHelp("T", "tree grouping"),
+	),
+	Preview: key.NewBinding(
+		key.WithKeys("D"),
+		key.WithHelp("D", "details"),
+	),
+	AddConnection: key.NewBinding(
+		key.WithKeys("n"),
+		key.WithHelp("n", "new"),
+	),
+	EditConnection: key.NewBinding(
+		key.WithKeys("e"),
+		key.WithHelp("e", "edit"),
+	),
+	DuplicateConnection: key.NewBinding(
+		key.WithKeys("C"),
+		key.WithHelp("C", "duplicate"),
+	),
+	DeleteConnection: key.NewBinding(
+		key.WithKeys("X", "delete"),
+		key.WithHelp("X", "delete"),
+	),
 }
 
-func newConnectionlistModel(items []list.Item) connectionlistModel {
+func newConnectionlistModel(items []list.Item, st usage.State) connectionlistModel {
 	l := list.NewDefaultDelegate()
 	l.Styles.SelectedTitle = l.Styles.SelectedTitle.
 		BorderForeground(lipgloss.Color("#06bf18")).
@@ -232,23 +513,41 @@ func newConnectionlistModel(items []list.Item) connectionlistModel {
 		BorderForeground(lipgloss.Color("#06bf18"))
 
 	m := connectionlistModel{
-		list: list.New(items, l, 0, 0),
+		list:           list.New(items, l, 0, 0),
+		filterMode:     GetFilterMode(),
+		usage:          st,
+		sortMode:       usage.ValidSort(st.Sort),
+		tree:           newTreeModel(),
+		preview:        GetPreview(),
+		resolutions:    map[string]resolution{},
+		healthMode:     health.GetMode(),
+		healthInterval: health.GetInterval(),
 	}
-	m.list.Title = "Go SSH Connection Manager"
+	m.setTitle()
 	m.list.Styles.Title = lipgloss.NewStyle().Background(lipgloss.Color("#045edb")).Padding(0, 1)
 	m.list.FilterInput.Cursor.Style = lipgloss.NewStyle().Foreground(lipgloss.Color("#ffffff"))
-	m.list.Filter = FilterFunc
+	m.list.Filter = filterFuncFor(m.filterMode)
 	m.list.AdditionalShortHelpKeys = connectionListKeyBindings.AdditionalKeys
 	m.list.AdditionalFullHelpKeys = connectionListKeyBindings.AdditionalKeys
 
 	return m
 }
 
-func ConnectionList(initialFilter string) (*connectionlistModel, error) {
+// ConnectionList shows the connections, filtered by initialFilter, with the
+// status message when it is set
+func ConnectionList(initialFilter string, status string) (*connectionlistModel, error) {
 	items := config.ReadConnections()
-	m := newConnectionlistModel(items)
+	facts.Apply(items)
+	st, err := usage.Load()
+	if err != nil {
+		log.Logger.Error("Could not load the usage state", "err", err)
+	}
+	items = usage.Apply(items, st, time.Now())
+	m := newConnectionlistModel(items, st)
+	m.status = status
 	if initialFilter != "" {
 		m.list.SetFilterText(initialFilter)
+		m.tree.filter.SetValue(initialFilter)
 	}
 	p := tea.NewProgram(m, tea.WithAltScreen())
 
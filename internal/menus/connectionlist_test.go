@@ -1,12 +1,30 @@
 package menus
 
-import "testing"
+import (
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/log"
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/health"
+	internal_log "github.com/nicknickel/gossh/internal/log"
+	"github.com/nicknickel/gossh/internal/usage"
+)
 
 func TestFilterFunc(t *testing.T) {
 	items := []string{
 		"host1 addr1 user1 desc1",
 		"host2 addr2 user2 desc2",
 		"host3 addr3 user3 desc3",
+		"web1 addr4 user4 desc4\tstatus:up",
+		"web2 addr5 user5 status:down\tstatus:nossh",
+		"db1 addr6 user6 desc6\tos:ubuntu\tversion:22.04\trole:db",
+		"db2 addr7 user7 role:db",
 	}
 
 	tests := []struct {
@@ -34,6 +52,31 @@ func TestFilterFunc(t *testing.T) {
 			term:     "host1 user3",
 			expected: []int{},
 		},
+		{
+			name:     "status",
+			term:     "status:up",
+			expected: []int{3},
+		},
+		{
+			name:     "status only matches the check",
+			term:     "status:down",
+			expected: []int{},
+		},
+		{
+			name:     "status with other terms",
+			term:     "STATUS:no web",
+			expected: []int{4},
+		},
+		{
+			name:     "fact",
+			term:     "os:ubuntu",
+			expected: []int{5},
+		},
+		{
+			name:     "probe facts are tags where gathered",
+			term:     "role:db",
+			expected: []int{5, 6},
+		},
 	}
 
 	for _, tt := range tests {
@@ -50,3 +93,244 @@ func TestFilterFunc(t *testing.T) {
 		})
 	}
 }
+
+func TestFilterFunc_Syntax(t *testing.T) {
+	conns := []connection.Item{
+		{Name: "web1", Conn: connection.Connection{Address: "10.1.0.11", User: "opc", Description: "frontend", Tags: []string{"prod", "eu"}, Group: "web", Folder: "prod/eu"}},
+		{Name: "web2", Conn: connection.Connection{Address: "10.1.0.12", User: "root", Description: "frontend, managed by opc", Tags: []string{"prod"}}},
+		{Name: "web-staging", Conn: connection.Connection{Address: "10.2.0.11", User: "opc", Description: "staging frontend", Tags: []string{"staging"}}},
+		{Name: "db01", Conn: connection.Connection{Address: "10.1.0.21", User: "opc", Description: "database"},
+			Health: connection.Health{State: connection.HealthDown}, Facts: map[string]string{"os": "ubuntu", "role": "primary"}},
+		{Name: "My server", Conn: connection.Connection{User: "admin", Description: "db backups"}},
+	}
+	var items []string
+	for _, c := range conns {
+		items = append(items, c.FilterValue())
+	}
+
+	tests := []struct {
+		name     string
+		term     string
+		expected []int
+	}{
+		{name: "empty", term: "", expected: []int{0, 1, 2, 3, 4}},
+		{name: "plain term matches any field", term: "opc", expected: []int{0, 1, 2, 3}},
+		{name: "plain terms are case insensitive", term: "FRONTEND", expected: []int{0, 1, 2}},
+		{name: "plain terms are anded", term: "opc frontend", expected: []int{0, 1, 2}},
+		{name: "plain term does not match tags", term: "eu", expected: []int{}},
+		{name: "user", term: "user:opc", expected: []int{0, 2, 3}},
+		{name: "user is case insensitive", term: "USER:OPC", expected: []int{0, 2, 3}},
+		{name: "addr prefix", term: "addr:10.1.", expected: []int{0, 1, 3}},
+		{name: "address alias", term: "address:10.2", expected: []int{2}},
+		{name: "addr defaults to the name", term: "addr:server", expected: []int{4}},
+		{name: "name", term: "name:web", expected: []int{0, 1, 2}},
+		{name: "name with spaces", term: "name:server", expected: []int{4}},
+		{name: "user of name with spaces", term: "user:admin", expected: []int{4}},
+		{name: "comment", term: "comment:db", expected: []int{4}},
+		{name: "desc alias", term: "desc:opc", expected: []int{1}},
+		{name: "tag", term: "tag:prod", expected: []int{0, 1}},
+		{name: "tags of one item", term: "tag:prod tag:eu", expected: []int{0}},
+		{name: "group", term: "group:web", expected: []int{0}},
+		{name: "no group is not a match", term: "group:", expected: []int{0}},
+		{name: "negated group", term: "-group:web", expected: []int{1, 2, 3, 4}},
+		{name: "folder", term: "folder:prod", expected: []int{0}},
+		{name: "status", term: "status:down", expected: []int{3}},
+		{name: "fact", term: "os:ubuntu", expected: []int{3}},
+		{name: "probe fact", term: "role:primary", expected: []int{3}},
+		{name: "unknown qualifier is plain", term: "role:db", expected: []int{}},
+		{name: "empty qualifier value", term: "tag:", expected: []int{0, 1, 2}},
+		{name: "negation", term: "-staging", expected: []int{0, 1, 3, 4}},
+		{name: "negated qualifier", term: "web -tag:staging", expected: []int{0, 1}},
+		{name: "negated status", term: "-status:down opc", expected: []int{0, 1, 2}},
+		{name: "lone dash is plain", term: "-", expected: []int{2}},
+		{name: "or with pipe", term: "user:root|user:admin", expected: []int{1, 4}},
+		{name: "or keyword", term: "tag:eu OR tag:staging", expected: []int{0, 2}},
+		{name: "or mixed with plain", term: "database|backups", expected: []int{3, 4}},
+		{name: "or anded with terms", term: "user:opc tag:prod|status:down", expected: []int{0, 3}},
+		{name: "negated or", term: "-tag:prod|tag:staging", expected: []int{3, 4}},
+		{name: "trailing or is plain", term: "web OR", expected: []int{}},
+		{name: "regex", term: `name:/^web\d+$/`, expected: []int{0, 1}},
+		{name: "regex is case insensitive", term: "name:/^WEB/", expected: []int{0, 1, 2}},
+		{name: "regex with alternatives", term: "name:/^(web1|db)/", expected: []int{0, 3}},
+		{name: "regex or plain", term: "name:/1$/|admin", expected: []int{0, 3, 4}},
+		{name: "plain regex", term: `/^10\.2\./`, expected: []int{2}},
+		{name: "negated regex", term: "-name:/^web/", expected: []int{3, 4}},
+		{name: "invalid regex is literal", term: "name:/[/", expected: []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			for _, r := range FilterFunc(tt.term, items) {
+				got = append(got, r.Index)
+			}
+			if len(got) != len(tt.expected) || (len(got) > 0 && !slices.Equal(got, tt.expected)) {
+				t.Errorf("FilterFunc(%q) = %v, want %v", tt.term, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestConnectionlistModel_Health(t *testing.T) {
+	items := []list.Item{
+		connection.Item{Name: "web1", Index: 0},
+		connection.Item{Name: "web2", Index: 1},
+	}
+	m := newConnectionlistModel(items, usage.State{})
+	m.healthMode = health.ModeOff
+	if m.Init() != nil {
+		t.Errorf("Init() should not check hosts when health checks are off")
+	}
+
+	model, cmd := m.Update(healthMsg{
+		{Name: "web1", Health: connection.Health{State: connection.HealthUp, Latency: 12 * time.Millisecond}},
+		{Name: "web2", Health: connection.Health{State: connection.HealthDown}},
+	})
+	m = model.(connectionlistModel)
+	if cmd == nil {
+		t.Errorf("expected the next check to be scheduled")
+	}
+	got := m.list.Items()
+	if desc := got[0].(connection.Item).Description(); !strings.HasPrefix(desc, "● 12ms") {
+		t.Errorf("Description() = %q", desc)
+	}
+	if desc := got[1].(connection.Item).Description(); !strings.HasPrefix(desc, "○ down") {
+		t.Errorf("Description() = %q", desc)
+	}
+}
+
+func TestFuzzyFilterFunc(t *testing.T) {
+	conns := []connection.Item{
+		{Name: "aws-prod-web", Conn: connection.Connection{Address: "10.0.0.1", User: "ec2-user"}},
+		{Name: "web1", Conn: connection.Connection{Address: "10.0.0.2", User: "opc", Tags: []string{"prod"}}},
+		{Name: "backup", Conn: connection.Connection{Address: "10.0.0.3", Description: "nightly web dumps"}},
+		{Name: "db01", Conn: connection.Connection{Address: "10.0.0.4"}, Checked: true},
+	}
+	var items []string
+	for _, c := range conns {
+		items = append(items, c.FilterValue())
+	}
+
+	tests := []struct {
+		name     string
+		term     string
+		expected []int
+		matches  [][]int
+	}{
+		{name: "name prefix ranks first", term: "web", expected: []int{1, 0, 2}, matches: [][]int{{0, 1, 2}, {9, 10, 11}, nil}},
+		{name: "subsequence", term: "apw", expected: []int{0, 2}, matches: [][]int{{0, 4, 9}}},
+		{name: "word boundaries beat scattered runes", term: "pw", expected: []int{0, 2}},
+		{name: "no match", term: "xyz", expected: []int{}},
+		{name: "all words must match", term: "web opc", expected: []int{1}},
+		{name: "case insensitive", term: "WEB1", expected: []int{1, 0}, matches: [][]int{{0, 1, 2, 3}}},
+		{name: "checked items are offset by the marker", term: "db", expected: []int{3, 0}, matches: [][]int{{4, 5}}},
+		{name: "qualified terms filter", term: "web tag:prod", expected: []int{1}, matches: [][]int{{0, 1, 2}}},
+		{name: "negated terms filter", term: "web -user:opc", expected: []int{0, 2}},
+		{name: "regex terms filter", term: "/^back/", expected: []int{2}, matches: [][]int{{}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ranks := FuzzyFilterFunc(tt.term, items)
+			var got []int
+			for _, r := range ranks {
+				got = append(got, r.Index)
+			}
+			if len(got) != len(tt.expected) || (len(got) > 0 && !slices.Equal(got, tt.expected)) {
+				t.Fatalf("FuzzyFilterFunc(%q) = %v, want %v", tt.term, got, tt.expected)
+			}
+			for ind, m := range tt.matches {
+				// only the runes within the title are highlighted
+				if m != nil && (len(ranks[ind].MatchedIndexes) < len(m) || !slices.Equal(ranks[ind].MatchedIndexes[:len(m)], m)) {
+					t.Errorf("MatchedIndexes[%v] = %v, want %v", ind, ranks[ind].MatchedIndexes, m)
+				}
+			}
+		})
+	}
+}
+
+func TestFuzzyMatch(t *testing.T) {
+	text := lowerRunes("my-web-server 10.0.0.1")
+	score, indexes, ok := fuzzyMatch(text, lowerRunes("ws"), 13)
+	if !ok || !slices.Equal(indexes, []int{3, 7}) {
+		t.Errorf("fuzzyMatch() = %v, %v, %v, want word starts", score, indexes, ok)
+	}
+	prefix, _, _ := fuzzyMatch(text, lowerRunes("my"), 13)
+	inner, _, _ := fuzzyMatch(text, lowerRunes("we"), 13)
+	if prefix <= inner {
+		t.Errorf("name prefix score %v should beat %v", prefix, inner)
+	}
+	if _, _, ok := fuzzyMatch(text, lowerRunes("zz"), 13); ok {
+		t.Errorf("fuzzyMatch() matched a missing rune")
+	}
+}
+
+func TestConnectionlistModel_FilterMode(t *testing.T) {
+	t.Setenv("GOSSH_FILTER", "fuzzy")
+	m := newConnectionlistModel([]list.Item{
+		connection.Item{Name: "web1", Index: 0},
+		connection.Item{Name: "aws-east-b", Index: 1},
+	}, usage.State{})
+	if m.filterMode != FilterFuzzy {
+		t.Fatalf("filterMode = %v", m.filterMode)
+	}
+	m.list.SetFilterText("web")
+	if got := len(m.list.VisibleItems()); got != 2 {
+		t.Errorf("fuzzy filter shows %v items, want 2", got)
+	}
+
+	model, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("F")})
+	m = model.(connectionlistModel)
+	if m.filterMode != FilterStrict || len(m.list.VisibleItems()) != 1 {
+		t.Errorf("after toggling filterMode = %v, visible = %v", m.filterMode, len(m.list.VisibleItems()))
+	}
+}
+
+func TestConnectionlistModel_SortAndFavorite(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	t.Setenv("GOSSH_STATE_DIR", t.TempDir())
+	now := time.Now()
+	st := usage.State{Events: []usage.Event{
+		{Connection: "web2", Action: "Connect", Time: now.Add(-time.Hour)},
+	}}
+	m := newConnectionlistModel(usage.Apply([]list.Item{
+		connection.Item{Name: "web1", Index: 0},
+		connection.Item{Name: "web2", Index: 1},
+		connection.Item{Name: "web3", Index: 2},
+	}, st, now), st)
+
+	names := func() []string {
+		var n []string
+		for ind, val := range m.list.Items() {
+			item := val.(connection.Item)
+			if item.Index != ind {
+				t.Errorf("%v has index %v at %v", item.Name, item.Index, ind)
+			}
+			n = append(n, item.Name)
+		}
+		return n
+	}
+	press := func(k string) {
+		model, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)})
+		m = model.(connectionlistModel)
+	}
+
+	press("S")
+	if m.sortMode != usage.SortRecent || !slices.Equal(names(), []string{"web2", "web1", "web3"}) {
+		t.Errorf("sorted by %v = %v", m.sortMode, names())
+	}
+	if selected := m.list.SelectedItem().(connection.Item).Name; selected != "web1" {
+		t.Errorf("selection moved to %v", selected)
+	}
+
+	// web1 is selected
+	press("*")
+	if !slices.Equal(names(), []string{"web1", "web2", "web3"}) {
+		t.Errorf("favorite not first: %v", names())
+	}
+
+	saved, err := usage.Load()
+	if err != nil || saved.Sort != usage.SortRecent || !slices.Equal(saved.Favorites, []string{"web1"}) {
+		t.Errorf("saved state = %+v, %v", saved, err)
+	}
+}
@@ -0,0 +1,384 @@
+package menus
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/transfer"
+	"github.com/pkg/sftp"
+)
+
+// fileSystem is the part of the local or remote filesystem the browser needs
+type fileSystem interface {
+	ReadDir(dir string) ([]os.FileInfo, error)
+	Stat(p string) (os.FileInfo, error)
+	Join(elem ...string) string
+	Dir(p string) string
+}
+
+type localFS struct{}
+
+func (localFS) ReadDir(dir string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var infos []os.FileInfo
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err == nil {
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+func (localFS) Stat(p string) (os.FileInfo, error) { return os.Stat(p) }
+func (localFS) Join(elem ...string) string         { return filepath.Join(elem...) }
+func (localFS) Dir(p string) string                { return filepath.Dir(p) }
+
+type remoteFS struct {
+	c *sftp.Client
+}
+
+func (r remoteFS) ReadDir(dir string) ([]os.FileInfo, error) { return r.c.ReadDir(dir) }
+func (r remoteFS) Stat(p string) (os.FileInfo, error)        { return r.c.Stat(p) }
+func (remoteFS) Join(elem ...string) string                  { return path.Join(elem...) }
+func (remoteFS) Dir(p string) string                         { return path.Dir(p) }
+
+type browserPane struct {
+	fs         fileSystem
+	title      string
+	dir        string
+	entries    []os.FileInfo
+	cursor     int
+	selected   map[string]bool
+	showHidden bool
+	err        error
+}
+
+// load lists dir with directories first. Row 0 is always "..".
+func (p *browserPane) load(dir string) {
+	infos, err := p.fs.ReadDir(dir)
+	if err != nil {
+		p.err = err
+		return
+	}
+
+	var entries []os.FileInfo
+	for _, info := range infos {
+		if !p.showHidden && strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		// follow symlinks so linked directories can be entered
+		if info.Mode()&os.ModeSymlink != 0 {
+			if target, err := p.fs.Stat(p.fs.Join(dir, info.Name())); err == nil {
+				info = target
+			}
+		}
+		entries = append(entries, info)
+	}
+	sort.SliceStable(entries, func(a, b int) bool {
+		if entries[a].IsDir() != entries[b].IsDir() {
+			return entries[a].IsDir()
+		}
+		return entries[a].Name() < entries[b].Name()
+	})
+
+	p.dir = dir
+	p.entries = entries
+	p.cursor = 0
+	p.err = nil
+}
+
+func (p *browserPane) rows() int {
+	return len(p.entries) + 1
+}
+
+// current returns the entry under the cursor, nil for ".."
+func (p *browserPane) current() os.FileInfo {
+	if p.cursor == 0 || p.cursor > len(p.entries) {
+		return nil
+	}
+	return p.entries[p.cursor-1]
+}
+
+func (p *browserPane) up() {
+	child := path.Base(filepath.ToSlash(p.dir))
+	p.load(p.fs.Dir(p.dir))
+	// keep the cursor on the directory we came from
+	for ind, entry := range p.entries {
+		if entry.Name() == child {
+			p.cursor = ind + 1
+		}
+	}
+}
+
+func (p *browserPane) open() {
+	entry := p.current()
+	if entry == nil {
+		p.up()
+	} else if entry.IsDir() {
+		p.load(p.fs.Join(p.dir, entry.Name()))
+	}
+}
+
+func (p *browserPane) toggleSelected() {
+	entry := p.current()
+	if entry == nil {
+		return
+	}
+	full := p.fs.Join(p.dir, entry.Name())
+	if p.selected[full] {
+		delete(p.selected, full)
+	} else {
+		p.selected[full] = true
+	}
+}
+
+type filebrowserModel struct {
+	local     *browserPane
+	remote    *browserPane
+	active    *browserPane
+	direction transfer.Direction
+	item      connection.Item
+	// layout names received files
+	layout    string
+	width     int
+	height    int
+	submitted bool
+	typed     bool
+}
+
+func (m filebrowserModel) source() *browserPane {
+	if m.direction == transfer.Send {
+		return m.local
+	}
+	return m.remote
+}
+
+func (m filebrowserModel) dest() *browserPane {
+	if m.direction == transfer.Send {
+		return m.remote
+	}
+	return m.local
+}
+
+// Sources returns the selected paths, or the entry under the cursor when
+// nothing is selected
+func (m filebrowserModel) Sources() []string {
+	src := m.source()
+	var sources []string
+	for p := range src.selected {
+		sources = append(sources, p)
+	}
+	if len(sources) == 0 {
+		if entry := src.current(); entry != nil {
+			sources = append(sources, src.fs.Join(src.dir, entry.Name()))
+		}
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+func (m filebrowserModel) Destination() string {
+	return m.dest().dir
+}
+
+func (m filebrowserModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m filebrowserModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		h, v := docStyle.GetFrameSize()
+		m.width = msg.Width - h
+		m.height = msg.Height - v
+	case tea.KeyMsg:
+		p := m.active
+		switch msg.String() {
+		case "ctrl+c", "esc", "q":
+			return m, tea.Quit
+		case "tab", "shift+tab":
+			if m.active == m.local {
+				m.active = m.remote
+			} else {
+				m.active = m.local
+			}
+		case "up", "k":
+			p.cursor = max(0, p.cursor-1)
+		case "down", "j":
+			p.cursor = min(p.rows()-1, p.cursor+1)
+		case "home", "g":
+			p.cursor = 0
+		case "end", "G":
+			p.cursor = p.rows() - 1
+		case "enter", "right", "l":
+			p.open()
+		case "backspace", "left", "h":
+			p.up()
+		case " ":
+			if p == m.source() {
+				p.toggleSelected()
+				p.cursor = min(p.rows()-1, p.cursor+1)
+			}
+		case ".":
+			p.showHidden = !p.showHidden
+			p.load(p.dir)
+		case "n":
+			if m.direction == transfer.Receive {
+				next := slices.Index(transfer.LayoutNames, m.layout) + 1
+				m.layout = transfer.LayoutNames[next%len(transfer.LayoutNames)]
+			}
+		case "e":
+			m.typed = true
+			return m, tea.Quit
+		case "c":
+			if len(m.Sources()) > 0 {
+				m.submitted = true
+				return m, tea.Quit
+			}
+		}
+	}
+	return m, nil
+}
+
+func (m filebrowserModel) paneView(p *browserPane, width int, height int) string {
+	cursorStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#06bf18")).Bold(true)
+	dirStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#045edb"))
+
+	visible := max(1, height-4)
+	start := max(0, min(p.cursor-visible/2, p.rows()-visible))
+
+	var lines []string
+	title := p.title + ":" + p.dir
+	if p == m.active {
+		title = StyleTitle(title)
+	}
+	lines = append(lines, title)
+	if p.err != nil {
+		lines = append(lines, lipgloss.NewStyle().Foreground(lipgloss.Color("#db0404")).Render(p.err.Error()))
+		visible--
+	}
+
+	for row := start; row < min(p.rows(), start+visible); row++ {
+		name, size, marker := "../", "", "    "
+		isDir := true
+		if row > 0 {
+			entry := p.entries[row-1]
+			name = entry.Name()
+			isDir = entry.IsDir()
+			if isDir {
+				name += "/"
+			} else {
+				size = FormatBytes(entry.Size())
+			}
+			if p.selected[p.fs.Join(p.dir, entry.Name())] {
+				marker = "[x] "
+			}
+		}
+
+		line := fmt.Sprintf("%v%-*.*s %10s", marker, max(1, width-19), max(1, width-19), name, size)
+		switch {
+		case row == p.cursor && p == m.active:
+			line = cursorStyle.Render(line)
+		case isDir:
+			line = dirStyle.Render(line)
+		}
+		lines = append(lines, line)
+	}
+
+	return lipgloss.NewStyle().Width(width).Height(height).
+		Border(lipgloss.NormalBorder()).BorderForeground(lipgloss.Color("228")).
+		Render(strings.Join(lines, "\n"))
+}
+
+func (m filebrowserModel) View() string {
+	width, height := m.width, m.height
+	if width <= 0 {
+		width, height = 100, 24
+	}
+	paneWidth := max(20, width/2-2)
+	paneHeight := max(5, height-6)
+
+	verb, where := "Send", "to"
+	if m.direction == transfer.Receive {
+		verb, where = "Receive", "into"
+	}
+	summary := fmt.Sprintf("%v %v item(s) %v %v:%v", verb, len(m.Sources()), where, m.dest().title, m.Destination())
+	help := "(tab switch pane, space select, enter open, backspace up, . hidden files, e type paths, c copy, esc quit)"
+	if m.direction == transfer.Receive {
+		summary += "\n" + m.namingView()
+		help = "(tab switch pane, space select, enter open, backspace up, . hidden files, n naming, e type paths, c copy, esc quit)"
+	}
+
+	return globalStyle(strings.Join([]string{
+		lipgloss.JoinHorizontal(lipgloss.Top, m.paneView(m.local, paneWidth, paneHeight), m.paneView(m.remote, paneWidth, paneHeight)),
+		summary,
+		help,
+	}, "\n") + "\n")
+}
+
+// namingView shows the layout with where the first source would be saved
+func (m filebrowserModel) namingView() string {
+	naming := "Naming: " + m.layout
+	sources := m.Sources()
+	if len(sources) == 0 {
+		return naming
+	}
+	paths, _, err := transfer.ReceiveLayout([]connection.Item{m.item}, [][]string{sources[:1]}, m.Destination(), m.layout, time.Now())
+	if err != nil {
+		return naming + " (" + err.Error() + ")"
+	}
+	return naming + ", e.g. " + paths[0][0].Dest
+}
+
+func newFilebrowserModel(localFs fileSystem, localDir string, remoteFs fileSystem, remoteDir string, item connection.Item, d transfer.Direction) filebrowserModel {
+	local := &browserPane{fs: localFs, title: "local", dir: localDir, selected: map[string]bool{}}
+	local.load(localDir)
+	remote := &browserPane{fs: remoteFs, title: item.WindowName(), dir: remoteDir, selected: map[string]bool{}}
+	remote.load(remoteDir)
+
+	m := filebrowserModel{local: local, remote: remote, direction: d, item: item, layout: transfer.GetLayout()}
+	m.active = m.source()
+	return m
+}
+
+// FileBrowser lets the user pick sources and a destination on the local
+// machine and the connection over SFTP. The bool is true when the user asked
+// to type the paths instead.
+func FileBrowser(c *sftp.Client, item connection.Item, d transfer.Direction) (TransferRequest, bool, error) {
+	remoteDir, err := c.Getwd()
+	if err != nil {
+		return TransferRequest{}, false, err
+	}
+	localDir, err := os.Getwd()
+	if err != nil {
+		return TransferRequest{}, false, err
+	}
+
+	p := tea.NewProgram(newFilebrowserModel(localFS{}, localDir, remoteFS{c}, remoteDir, item, d), tea.WithAltScreen())
+	m, err := p.Run()
+	if err != nil {
+		return TransferRequest{}, false, err
+	}
+	model := m.(filebrowserModel)
+	if !model.submitted {
+		return TransferRequest{}, model.typed, nil
+	}
+	r := TransferRequest{Sources: model.Sources(), Dest: model.Destination()}
+	if d == transfer.Receive {
+		r.Layout = model.layout
+	}
+	return r, false, nil
+}
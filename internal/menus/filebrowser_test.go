@@ -0,0 +1,145 @@
+package menus
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/transfer"
+)
+
+func browserKeys(m filebrowserModel, keys ...string) filebrowserModel {
+	for _, k := range keys {
+		var msg tea.KeyMsg
+		switch k {
+		case "tab":
+			msg = tea.KeyMsg{Type: tea.KeyTab}
+		case "enter":
+			msg = tea.KeyMsg{Type: tea.KeyEnter}
+		case "backspace":
+			msg = tea.KeyMsg{Type: tea.KeyBackspace}
+		case " ":
+			msg = tea.KeyMsg{Type: tea.KeySpace, Runes: []rune(" ")}
+		default:
+			msg = tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)}
+		}
+		model, _ := m.Update(msg)
+		m = model.(filebrowserModel)
+	}
+	return m
+}
+
+func TestFilebrowserModel(t *testing.T) {
+	local := t.TempDir()
+	os.MkdirAll(filepath.Join(local, "logs"), 0755)
+	os.WriteFile(filepath.Join(local, "logs", "app.log"), []byte("log"), 0644)
+	os.WriteFile(filepath.Join(local, "b.txt"), []byte("b"), 0644)
+	os.WriteFile(filepath.Join(local, "a.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(local, ".hidden"), []byte("h"), 0644)
+	os.Symlink(filepath.Join(local, "logs"), filepath.Join(local, "link"))
+
+	remote := t.TempDir()
+	os.MkdirAll(filepath.Join(remote, "www"), 0755)
+
+	m := newFilebrowserModel(localFS{}, local, localFS{}, remote, connection.Item{Name: "web1"}, transfer.Send)
+	if m.active != m.local {
+		t.Fatalf("send should start in the local pane")
+	}
+
+	// directories (including linked ones) first, hidden files skipped
+	var names []string
+	for _, e := range m.local.entries {
+		names = append(names, e.Name())
+	}
+	if !slices.Equal(names, []string{"link", "logs", "a.txt", "b.txt"}) {
+		t.Errorf("local entries = %v", names)
+	}
+
+	// open logs, go back up and the cursor is on logs again
+	m = browserKeys(m, "j", "j", "enter")
+	if m.local.dir != filepath.Join(local, "logs") {
+		t.Errorf("dir after enter = %v", m.local.dir)
+	}
+	m = browserKeys(m, "backspace")
+	if m.local.dir != local || m.local.cursor != 2 {
+		t.Errorf("after backspace dir = %v, cursor = %v", m.local.dir, m.local.cursor)
+	}
+
+	// nothing selected uses the cursor, space selects and moves down
+	if got := m.Sources(); !slices.Equal(got, []string{filepath.Join(local, "logs")}) {
+		t.Errorf("Sources() with no selection = %v", got)
+	}
+	m = browserKeys(m, "j", " ", " ")
+	if got := m.Sources(); !slices.Equal(got, []string{filepath.Join(local, "a.txt"), filepath.Join(local, "b.txt")}) {
+		t.Errorf("Sources() = %v", got)
+	}
+
+	// the destination pane cannot select, only navigate
+	m = browserKeys(m, "tab", "j", " ", "enter")
+	if len(m.remote.selected) != 0 || m.Destination() != filepath.Join(remote, "www") {
+		t.Errorf("remote selected = %v, destination = %v", m.remote.selected, m.Destination())
+	}
+
+	m = browserKeys(m, "tab", ".")
+	if len(m.local.entries) != 5 {
+		t.Errorf("hidden files not shown, entries = %v", len(m.local.entries))
+	}
+
+	m = browserKeys(m, "c")
+	if !m.submitted {
+		t.Errorf("expected browser to be submitted")
+	}
+	if m.View() == "" {
+		t.Errorf("View() is empty")
+	}
+}
+
+func TestFilebrowserModel_Receive(t *testing.T) {
+	local := t.TempDir()
+	remote := t.TempDir()
+	os.WriteFile(filepath.Join(remote, "syslog"), []byte("log"), 0644)
+
+	m := newFilebrowserModel(localFS{}, local, localFS{}, remote, connection.Item{Name: "web1"}, transfer.Receive)
+	if m.active != m.remote {
+		t.Fatalf("receive should start in the remote pane")
+	}
+
+	// ".." alone can not be copied
+	m = browserKeys(m, "c")
+	if m.submitted {
+		t.Errorf("submitted without any source")
+	}
+
+	m = browserKeys(m, "j", "c")
+	if !m.submitted || !slices.Equal(m.Sources(), []string{filepath.Join(remote, "syslog")}) || m.Destination() != local {
+		t.Errorf("submitted = %v, sources = %v, destination = %v", m.submitted, m.Sources(), m.Destination())
+	}
+}
+
+func TestFilebrowserModel_Naming(t *testing.T) {
+	t.Setenv("GOSSH_RECEIVE_LAYOUT", "")
+	local := t.TempDir()
+	remote := t.TempDir()
+	os.WriteFile(filepath.Join(remote, "app.log.gz"), []byte("log"), 0644)
+
+	m := newFilebrowserModel(localFS{}, local, localFS{}, remote, connection.Item{Name: "web1"}, transfer.Receive)
+	m = browserKeys(m, "j")
+	if m.layout != "suffix" || !strings.Contains(m.View(), filepath.Join(local, "app_web1.log.gz")) {
+		t.Errorf("layout = %v, view missing the example name", m.layout)
+	}
+
+	m = browserKeys(m, "n")
+	if m.layout != "dir" || !strings.Contains(m.View(), filepath.Join(local, "web1", "app.log.gz")) {
+		t.Errorf("n did not move to the dir layout, layout = %v", m.layout)
+	}
+	for range transfer.LayoutNames {
+		m = browserKeys(m, "n")
+	}
+	if m.layout != "dir" {
+		t.Errorf("layouts did not cycle, layout = %v", m.layout)
+	}
+}
@@ -0,0 +1,396 @@
+package menus
+
+import (
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/log"
+)
+
+// plainFields are the space separated fields at the start of a FilterValue,
+// in order, as i.Name + " " + i.Conn.Address + " " + i.Conn.User + " " + i.Conn.Description
+var plainFields = []string{"name", "addr", "user", "comment"}
+
+// fieldAliases are the qualifiers accepted for the fields
+var fieldAliases = map[string]string{
+	"name":        "name",
+	"host":        "name",
+	"addr":        "addr",
+	"address":     "addr",
+	"user":        "user",
+	"comment":     "comment",
+	"desc":        "comment",
+	"description": "comment",
+	"tag":         "tag",
+	"group":       "group",
+	"folder":      "folder",
+	"status":      "status",
+	"os":          "os",
+	"version":     "version",
+	"kernel":      "kernel",
+	"arch":        "arch",
+	"uptime":      "uptime",
+	"ip":          "ip",
+}
+
+// filterTarget is a FilterValue split into the plain fields and the
+// qualified fields by key
+type filterTarget struct {
+	plain  []string
+	fields map[string][]string
+}
+
+// parseTarget splits a FilterValue. Values without qualified fields take
+// them from the plain fields.
+func parseTarget(item string) filterTarget {
+	parts := strings.Split(strings.TrimPrefix(item, connection.CheckedPrefix), "\t")
+	t := filterTarget{plain: strings.SplitN(parts[0], " ", 4), fields: map[string][]string{}}
+	for ind, key := range plainFields {
+		if ind < len(t.plain) {
+			t.fields[key] = []string{t.plain[ind]}
+		}
+	}
+
+	replaced := map[string]bool{}
+	for _, entry := range parts[1:] {
+		key, value, ok := strings.Cut(entry, ":")
+		if !ok {
+			continue
+		}
+		if !replaced[key] {
+			t.fields[key] = nil
+			replaced[key] = true
+		}
+		t.fields[key] = append(t.fields[key], value)
+	}
+	return t
+}
+
+// filterMatcher is one alternative of a term: a substring or /regex/,
+// optionally qualified with the field it must match
+type filterMatcher struct {
+	raw   string
+	key   string
+	value string
+	re    *regexp.Regexp
+}
+
+func newFilterMatcher(s string) filterMatcher {
+	m := filterMatcher{raw: s, value: s}
+	if key, value, ok := strings.Cut(s, ":"); ok && key != "" {
+		m.key, m.value = strings.ToLower(key), value
+		if alias, ok := fieldAliases[m.key]; ok {
+			m.key = alias
+		}
+	}
+	m.re = parseRegex(m.value)
+	return m
+}
+
+// parseRegex compiles /pattern/ case insensitively, nil for anything else
+// including invalid patterns which are then matched literally
+func parseRegex(s string) *regexp.Regexp {
+	if len(s) < 2 || !strings.HasPrefix(s, "/") || !strings.HasSuffix(s, "/") {
+		return nil
+	}
+	re, err := regexp.Compile("(?i)" + s[1:len(s)-1])
+	if err != nil {
+		return nil
+	}
+	return re
+}
+
+func matchValue(value string, pattern string, re *regexp.Regexp) bool {
+	if re != nil {
+		return re.MatchString(value)
+	}
+	return strings.Contains(strings.ToLower(value), strings.ToLower(pattern))
+}
+
+func (m filterMatcher) match(t filterTarget) bool {
+	values, qualified := t.fields[m.key]
+	_, known := fieldAliases[m.key]
+	if m.key != "" && (qualified || known) {
+		for _, v := range values {
+			if matchValue(v, m.value, m.re) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// a plain term, also when the qualifier is not a field
+	re := parseRegex(m.raw)
+	for _, field := range t.plain {
+		if matchValue(field, m.raw, re) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterTerm matches when any of its alternatives does, or none when negated
+type filterTerm struct {
+	negate       bool
+	alternatives []filterMatcher
+}
+
+func (f filterTerm) match(t filterTarget) bool {
+	matched := slices.ContainsFunc(f.alternatives, func(m filterMatcher) bool { return m.match(t) })
+	return matched != f.negate
+}
+
+// splitAlternatives splits a term on | outside of /regex/ values
+func splitAlternatives(term string) []string {
+	var alternatives []string
+	for _, part := range strings.Split(term, "|") {
+		if n := len(alternatives); n > 0 && openRegex(alternatives[n-1]) {
+			alternatives[n-1] += "|" + part
+			continue
+		}
+		alternatives = append(alternatives, part)
+	}
+	return alternatives
+}
+
+// openRegex reports whether s, a value or key:value, starts a /regex/ that
+// is not closed yet
+func openRegex(s string) bool {
+	if !strings.HasPrefix(s, "/") {
+		if _, value, ok := strings.Cut(s, ":"); ok {
+			s = value
+		}
+	}
+	return strings.HasPrefix(s, "/") && (len(s) == 1 || !strings.HasSuffix(s, "/"))
+}
+
+// parseFilter splits the filter into terms that must all match. Terms are
+// separated by spaces, alternatives by | or the word OR, and a leading -
+// negates a term.
+func parseFilter(s string) []filterTerm {
+	words := strings.Split(s, " ")
+	var groups []string
+	for ind := 0; ind < len(words); ind++ {
+		if words[ind] == "OR" && len(groups) > 0 && ind+1 < len(words) {
+			groups[len(groups)-1] += "|" + words[ind+1]
+			ind++
+			continue
+		}
+		groups = append(groups, words[ind])
+	}
+
+	var terms []filterTerm
+	for _, g := range groups {
+		var f filterTerm
+		if len(g) > 1 && strings.HasPrefix(g, "-") {
+			f.negate, g = true, g[1:]
+		}
+		for _, alt := range splitAlternatives(g) {
+			f.alternatives = append(f.alternatives, newFilterMatcher(alt))
+		}
+		terms = append(terms, f)
+	}
+	return terms
+}
+
+// FilterFunc keeps the items matching every term of the filter. Plain terms
+// match the name, address, user or comment, qualified terms such as user:opc,
+// tag:prod or os:ubuntu only that field, and /regex/ values a regular
+// expression.
+func FilterFunc(t string, items []string) []list.Rank {
+	var results []list.Rank
+	terms := parseFilter(t)
+
+	for i, item := range items {
+		target := parseTarget(item)
+		matched := true
+		for _, term := range terms {
+			if !term.match(target) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			results = append(results, list.Rank{Index: i, MatchedIndexes: nil})
+		}
+	}
+
+	return results
+}
+
+const (
+	FilterStrict = "strict"
+	FilterFuzzy  = "fuzzy"
+)
+
+// GetFilterMode reads GOSSH_FILTER: strict (default) or fuzzy
+func GetFilterMode() string {
+	switch mode := strings.ToLower(os.Getenv("GOSSH_FILTER")); mode {
+	case FilterFuzzy:
+		return FilterFuzzy
+	case "", FilterStrict:
+		return FilterStrict
+	default:
+		log.Logger.Error("Unknown GOSSH_FILTER, using strict", "value", mode)
+		return FilterStrict
+	}
+}
+
+func filterFuncFor(mode string) list.FilterFunc {
+	if mode == FilterFuzzy {
+		return FuzzyFilterFunc
+	}
+	return FilterFunc
+}
+
+// fuzzy match scores, a matched rune is worth scoreMatch
+const (
+	scoreMatch       = 16
+	bonusConsecutive = 24
+	bonusBoundary    = 20
+	bonusName        = 8
+	bonusNamePrefix  = 48
+	penaltyGap       = 2
+	maxPenaltyGap    = 24
+)
+
+func isWordBoundary(prev rune) bool {
+	return strings.ContainsRune(" -_./@:", prev)
+}
+
+func lowerRunes(s string) []rune {
+	runes := []rune(s)
+	for ind, r := range runes {
+		runes[ind] = unicode.ToLower(r)
+	}
+	return runes
+}
+
+// fuzzyMatch finds pattern as a subsequence of text and scores it, with
+// bonuses for consecutive runes, word starts and matches in the name, the
+// first nameLen runes. Each occurrence of the first rune is tried as the
+// start and the best match returned.
+func fuzzyMatch(text []rune, pattern []rune, nameLen int) (int, []int, bool) {
+	best, bestIndexes, found := 0, []int(nil), false
+	for start, r := range text {
+		if r != pattern[0] {
+			continue
+		}
+		indexes := make([]int, 0, len(pattern))
+		score, prev := 0, -1
+		pos := start
+		for _, p := range pattern {
+			for pos < len(text) && text[pos] != p {
+				pos++
+			}
+			if pos == len(text) {
+				break
+			}
+			score += scoreMatch
+			switch {
+			case prev >= 0 && pos == prev+1:
+				score += bonusConsecutive
+			case prev >= 0:
+				score -= min((pos-prev-1)*penaltyGap, maxPenaltyGap)
+			}
+			if pos == 0 || isWordBoundary(text[pos-1]) {
+				score += bonusBoundary
+			}
+			if pos < nameLen {
+				score += bonusName
+			}
+			indexes = append(indexes, pos)
+			prev = pos
+			pos++
+		}
+		if len(indexes) < len(pattern) {
+			// later starts can not match either
+			break
+		}
+		if indexes[0] == 0 {
+			score += bonusNamePrefix
+		}
+		if !found || score > best {
+			best, bestIndexes, found = score, indexes, true
+		}
+	}
+	return best, bestIndexes, found
+}
+
+// fuzzyPattern is the term's text when it is a single plain word, which is
+// matched fuzzily. Other terms are matched as by FilterFunc.
+func (f filterTerm) fuzzyPattern(t filterTarget) (string, bool) {
+	if f.negate || len(f.alternatives) != 1 {
+		return "", false
+	}
+	m := f.alternatives[0]
+	_, qualified := t.fields[m.key]
+	_, known := fieldAliases[m.key]
+	if (m.key != "" && (qualified || known)) || parseRegex(m.raw) != nil {
+		return "", false
+	}
+	return m.raw, true
+}
+
+// FuzzyFilterFunc matches plain terms as fuzzy subsequences of the name,
+// address, user and comment, ordered by score with the matched runes set so
+// they are highlighted in the title. Qualified, negated, OR and regex terms
+// filter as with FilterFunc.
+func FuzzyFilterFunc(t string, items []string) []list.Rank {
+	type scored struct {
+		rank  list.Rank
+		score int
+	}
+	var results []scored
+	terms := parseFilter(t)
+
+	for i, item := range items {
+		offset := 0
+		if strings.HasPrefix(item, connection.CheckedPrefix) {
+			offset = utf8.RuneCountInString(connection.CheckedPrefix)
+		}
+		target := parseTarget(item)
+		plain, _, _ := strings.Cut(strings.TrimPrefix(item, connection.CheckedPrefix), "\t")
+		text := lowerRunes(plain)
+		nameLen := 0
+		if names := target.fields["name"]; len(names) > 0 {
+			nameLen = utf8.RuneCountInString(names[0])
+		}
+
+		score, matched := 0, true
+		indexes := map[int]bool{}
+		for _, term := range terms {
+			pattern, fuzzy := term.fuzzyPattern(target)
+			if !fuzzy {
+				matched = term.match(target)
+			} else if pattern != "" {
+				s, idx, ok := fuzzyMatch(text, lowerRunes(pattern), nameLen)
+				score += s
+				for _, ind := range idx {
+					indexes[ind+offset] = true
+				}
+				matched = ok
+			}
+			if !matched {
+				break
+			}
+		}
+		if matched {
+			results = append(results, scored{rank: list.Rank{Index: i, MatchedIndexes: slices.Sorted(maps.Keys(indexes))}, score: score})
+		}
+	}
+
+	slices.SortStableFunc(results, func(a, b scored) int { return b.score - a.score })
+	ranks := make([]list.Rank, len(results))
+	for ind, r := range results {
+		ranks[ind] = r.rank
+	}
+	return ranks
+}
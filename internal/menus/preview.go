@@ -0,0 +1,225 @@
+package menus
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/encryption"
+	"github.com/nicknickel/gossh/internal/log"
+	"github.com/nicknickel/gossh/internal/runcommand"
+	"github.com/nicknickel/gossh/internal/sshconfig"
+)
+
+const (
+	// the pane is beside the list from previewSideWidth columns, otherwise
+	// below it with previewHeight rows
+	previewSideWidth = 120
+	previewHeight    = 14
+)
+
+// GetPreview reads GOSSH_PREVIEW: on to show the details pane, off (default)
+func GetPreview() bool {
+	switch preview := strings.ToLower(os.Getenv("GOSSH_PREVIEW")); preview {
+	case "on", "true", "1":
+		return true
+	case "", "off", "false", "0":
+		return false
+	default:
+		log.Logger.Error("Unknown GOSSH_PREVIEW, using off", "value", preview)
+		return false
+	}
+}
+
+// resolution is how ssh resolves a connection and how gossh would connect to
+// it, worked out once per connection in the background. It is pending until
+// ssh -G has answered.
+type resolution struct {
+	pending bool
+	config  sshconfig.Config
+	err     error
+	// files are the status of the connection's secret files
+	files   map[string]string
+	command runcommand.Preview
+}
+
+type resolutionMsg struct {
+	name string
+	resolution
+}
+
+// resolve works out the resolution of the connection
+func resolve(i connection.Item) resolution {
+	r := resolution{files: map[string]string{}}
+	r.config, r.err = sshconfig.Resolve(i)
+	for _, f := range []string{i.Conn.IdentityFile, i.Conn.PassFile, i.Conn.Totp} {
+		if f != "" {
+			r.files[f] = fileStatus(f)
+		}
+	}
+	r.command = runcommand.DryRunCommand(i, []string{"ssh", "{{.FinalAddr}}"})
+	return r
+}
+
+// resolveSelected resolves the highlighted connection once
+func (m connectionlistModel) resolveSelected() tea.Cmd {
+	if !m.preview {
+		return nil
+	}
+	i, ok := m.selected()
+	if !ok {
+		return nil
+	}
+	if _, ok := m.resolutions[i.Name]; ok {
+		return nil
+	}
+	m.resolutions[i.Name] = resolution{pending: true}
+	return func() tea.Msg {
+		return resolutionMsg{name: i.Name, resolution: resolve(i)}
+	}
+}
+
+func (m connectionlistModel) previewSide() bool {
+	return m.width >= previewSideWidth
+}
+
+// layout sizes the list and tree around the details pane
+func (m *connectionlistModel) layout() {
+	w, h := m.width, m.height
+	if m.preview {
+		if m.previewSide() {
+			w = w * 3 / 5
+		} else {
+			h = max(1, h-previewHeight)
+		}
+	}
+	m.list.SetSize(w, h)
+	m.tree.width, m.tree.height = w, h
+}
+
+// fileStatus describes a secret file: missing, or whether it is encrypted
+func fileStatus(file string) string {
+	if encryption.IsSecretRef(file) {
+		return "secret reference"
+	}
+	if _, err := os.Stat(file); err != nil {
+		return "missing"
+	}
+	if encrypted, _ := encryption.IsEncrypted(file); encrypted {
+		return "encrypted"
+	}
+	return "not encrypted"
+}
+
+var (
+	previewKeyStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("#045edb")).Width(11)
+	previewPaneStyle = lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).
+				BorderForeground(lipgloss.Color("#045edb")).Padding(0, 1)
+)
+
+// details lists every field of the connection, how ssh reaches it and the
+// command Connect would run
+func (m connectionlistModel) details(i connection.Item) string {
+	var lines []string
+	add := func(key string, value string) {
+		if value != "" {
+			lines = append(lines, previewKeyStyle.Render(key)+value)
+		}
+	}
+	r := m.resolutions[i.Name]
+	file := func(path string) string {
+		if path == "" {
+			return ""
+		}
+		if status, ok := r.files[path]; ok {
+			return fmt.Sprintf("%v (%v)", path, status)
+		}
+		return path
+	}
+
+	lines = append(lines, treeFolderStyle.Render(i.Name))
+	if i.Conn.Description != "" {
+		lines = append(lines, treeDimStyle.Render(i.Conn.Description))
+	}
+	lines = append(lines, "")
+	if i.Conn.Source != "" {
+		add("Source", displayPath(i.Conn.Source))
+	}
+	add("Address", i.FinalAddr())
+	_, port, _ := net.SplitHostPort(i.HostPort())
+	add("Port", port)
+
+	switch {
+	case r.pending:
+		add("ssh", treeDimStyle.Render("resolving..."))
+	case r.err != nil:
+		add("ssh", treeDimStyle.Render("could not resolve: "+r.err.Error()))
+	case r.config.Hostname != "":
+		add("ssh", fmt.Sprintf("%v@%v port %v", r.config.User, r.config.Hostname, r.config.Port))
+		if len(r.config.ProxyJump) > 0 {
+			add("Jump", strings.Join(r.config.ProxyJump, " → ")+" → "+r.config.Hostname)
+		}
+		add("Proxy", r.config.Proxy)
+	}
+
+	add("Identity", file(i.Conn.IdentityFile))
+	add("Passfile", file(i.Conn.PassFile))
+	if i.Conn.Password != "" {
+		add("Password", i.Conn.Password+" (secret reference)")
+	}
+	add("TOTP", file(i.Conn.Totp))
+	if i.Conn.HostKey != "" {
+		add("Host key", i.Conn.HostKey+" (pinned)")
+	}
+	add("Tags", strings.Join(i.Conn.Tags, ", "))
+	add("Group", i.Conn.Group)
+	add("Folder", i.Conn.Folder)
+	var forwards []string
+	for _, f := range i.Conn.Forwards {
+		forwards = append(forwards, f.Label())
+	}
+	add("Forwards", strings.Join(forwards, ", "))
+	add("Health", i.Health.Badge())
+	add("Facts", i.FactsSummary())
+	if e, ok := m.usage.LastUsed(i.Name); ok {
+		add("Last used", e.Time.Local().Format("2006-01-02 15:04")+" ("+e.Action+")")
+	} else {
+		add("Last used", "never")
+	}
+
+	if !r.pending && r.command.Err != "" {
+		lines = append(lines, "", previewKeyStyle.Render("Connect")+"refused: "+r.command.Err)
+	} else if !r.pending && r.command.Args != nil {
+		lines = append(lines, "", previewKeyStyle.Render("Connect")+r.command.Command())
+		for _, note := range r.command.Notes {
+			lines = append(lines, previewKeyStyle.Render("")+treeDimStyle.Render(note))
+		}
+		if r.command.Fallback != nil {
+			lines = append(lines, previewKeyStyle.Render("Fallback")+strings.Join(r.command.Fallback, " "),
+				previewKeyStyle.Render("")+treeDimStyle.Render("when the secrets can not be decrypted"))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// previewView is the details pane for the highlighted connection
+func (m connectionlistModel) previewView() string {
+	content := treeDimStyle.Render("No connection selected.")
+	if i, ok := m.selected(); ok {
+		content = m.details(i)
+	}
+
+	// the border is outside the width and height
+	style := previewPaneStyle
+	if m.previewSide() {
+		listWidth := m.width * 3 / 5
+		style = style.Width(max(1, m.width-listWidth-3)).Height(max(1, m.height-2)).MarginLeft(1)
+	} else {
+		style = style.Width(max(1, m.width-2)).Height(previewHeight - 2)
+	}
+	return style.MaxHeight(style.GetHeight() + 2).Render(content)
+}
@@ -0,0 +1,89 @@
+package menus
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/sshconfig"
+	"github.com/nicknickel/gossh/internal/usage"
+)
+
+func TestFileStatus(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "plain")
+	os.WriteFile(plain, []byte("key"), 0600)
+	encrypted := filepath.Join(dir, "encrypted")
+	os.WriteFile(encrypted, []byte("age-encryption.org/v1\n"), 0600)
+
+	tests := map[string]string{
+		plain:                         "not encrypted",
+		encrypted:                     "encrypted",
+		filepath.Join(dir, "missing"): "missing",
+		"op://infra/bastion/key":      "secret reference",
+	}
+	for file, expected := range tests {
+		if got := fileStatus(file); got != expected {
+			t.Errorf("fileStatus(%v) = %v, want %v", file, got, expected)
+		}
+	}
+}
+
+func TestConnectionlistModel_Preview(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("GOSSH_KNOWN_HOSTS", filepath.Join(dir, "known_hosts"))
+	key := filepath.Join(dir, "key.age")
+	os.WriteFile(key, []byte("age-encryption.org/v1\n"), 0600)
+	st := usage.State{Events: []usage.Event{{Connection: "web1", Action: "RunCommand", Time: time.Now()}}}
+	m := newConnectionlistModel([]list.Item{
+		connection.Item{Name: "web1", Index: 0, Conn: connection.Connection{
+			Address: "10.0.0.5:2222", User: "ops", Tags: []string{"prod"}, Source: "/etc/gossh/prod.yml", IdentityFile: key}},
+	}, st)
+	model, _ := m.Update(tea.WindowSizeMsg{Width: 154, Height: 40})
+	m = model.(connectionlistModel)
+	if m.preview || m.list.Width() != 150 {
+		t.Fatalf("preview = %v, list width %v", m.preview, m.list.Width())
+	}
+
+	model, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("D")})
+	m = model.(connectionlistModel)
+	if !m.preview || !m.previewSide() || m.list.Width() != 90 {
+		t.Errorf("preview = %v beside = %v, list width %v", m.preview, m.previewSide(), m.list.Width())
+	}
+	if cmd == nil || !m.resolutions["web1"].pending {
+		t.Errorf("resolving was not started")
+	}
+
+	r := resolve(m.list.Items()[0].(connection.Item))
+	r.config = sshconfig.Config{Hostname: "10.0.0.5", Port: "2222", User: "ops", ProxyJump: []string{"bastion"}}
+	r.err = nil
+	model, _ = m.Update(resolutionMsg{name: "web1", resolution: r})
+	m = model.(connectionlistModel)
+	// the resolution is kept, not worked out again for each view
+	os.Remove(key)
+	view := m.View()
+	for _, expected := range []string{"/etc/gossh/prod.yml", "2222", "bastion → 10.0.0.5", "prod", "(RunCommand)", "ssh ops@10.0.0.5:2222"} {
+		if !strings.Contains(view, expected) {
+			t.Errorf("View() does not contain %q:\n%v", expected, view)
+		}
+	}
+
+	details := m.details(m.list.Items()[0].(connection.Item))
+	for _, expected := range []string{"key.age (encrypted)", "ssh -i " + key + " ops@10.0.0.5:2222"} {
+		if !strings.Contains(details, expected) {
+			t.Errorf("details() does not contain %q:\n%v", expected, details)
+		}
+	}
+
+	// narrow terminals show the pane below the list
+	model, _ = m.Update(tea.WindowSizeMsg{Width: 84, Height: 40})
+	m = model.(connectionlistModel)
+	if m.previewSide() || m.list.Width() != 80 || m.list.Height() != 38-previewHeight {
+		t.Errorf("bottom pane list size = %vx%v", m.list.Width(), m.list.Height())
+	}
+}
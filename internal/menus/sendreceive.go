@@ -2,22 +2,71 @@ package menus
 
 import (
 	"fmt"
+	"strings"
 
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/transfer"
+	"github.com/pkg/sftp"
+)
+
+// TransferRequest is what the user chose to transfer. No sources means the
+// user quit.
+type TransferRequest struct {
+	Sources []string
+	Dest    string
+	// Globs is set when the sources were typed and may contain patterns
+	Globs bool
+	// Layout names received files, see transfer.ReceiveLayout
+	Layout string
+}
+
+const (
+	inputSrc = iota
+	inputDest
+	inputLayout
 )
 
 type sendreceiveModel struct {
+	note         string
 	focusedInput int
-	srcInput     textinput.Model
-	destInput    textinput.Model
-	err          error
+	inputs       []textinput.Model
+	// completers for each input, nil when an input does not complete paths
+	completers []*pathCompleter
+	submitted  bool
+	err        error
 }
 
 func (m sendreceiveModel) Init() tea.Cmd {
 	return textinput.Blink
 }
 
+func (m *sendreceiveModel) focus(input int) {
+	m.focusedInput = (input + len(m.inputs)) % len(m.inputs)
+	for ind := range m.inputs {
+		if ind == m.focusedInput {
+			m.inputs[ind].Focus()
+		} else {
+			m.inputs[ind].Blur()
+		}
+	}
+}
+
+// complete refreshes the suggestions of the focused input, listing its
+// directory in the background when it has not been seen yet
+func (m *sendreceiveModel) complete() tea.Cmd {
+	completer := m.completers[m.focusedInput]
+	if completer == nil {
+		return nil
+	}
+	input := &m.inputs[m.focusedInput]
+	suggestions, cmd := completer.suggestions(input.Value())
+	input.SetSuggestions(suggestions)
+	return cmd
+}
+
 func (m sendreceiveModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmd tea.Cmd
 
@@ -26,82 +75,166 @@ func (m sendreceiveModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		switch msg.String() {
 		case "enter":
 			// switch input focus if we don't have all the data
-			if m.srcInput.Value() == "" {
-				m.focusedInput = 1
-				m.srcInput.Focus()
-				m.destInput.Blur()
-			} else if m.destInput.Value() == "" {
-				m.focusedInput = 0
-				m.destInput.Focus()
-				m.srcInput.Blur()
-			} else {
-				return m, tea.Quit
+			for ind, input := range m.inputs {
+				if input.Value() == "" {
+					m.focus(ind)
+					return m, m.complete()
+				}
+			}
+			if len(m.inputs) > inputLayout {
+				if _, err := transfer.ParseLayout(m.inputs[inputLayout].Value()); err != nil {
+					m.err = err
+					m.focus(inputLayout)
+					return m, nil
+				}
 			}
+			m.submitted = true
+			return m, tea.Quit
 		case "ctrl+c", "esc":
 			return m, tea.Quit
-		case "tab", "shift+tab", "down", "up":
-			switch m.focusedInput {
-			case 0:
-				m.focusedInput = 1
-				m.destInput.Focus()
-				m.srcInput.Blur()
-			case 1:
-				m.focusedInput = 0
-				m.srcInput.Focus()
-				m.destInput.Blur()
+		case "tab":
+			// tab completes when there is a suggestion, otherwise moves on
+			input := m.inputs[m.focusedInput]
+			if len(input.MatchedSuggestions()) == 0 || input.CurrentSuggestion() == input.Value() {
+				m.focus(m.focusedInput + 1)
+				return m, m.complete()
 			}
+		case "down":
+			m.focus(m.focusedInput + 1)
+			return m, m.complete()
+		case "shift+tab", "up":
+			m.focus(m.focusedInput - 1)
+			return m, m.complete()
 		}
 
+	case completionMsg:
+		msg.completer.store(msg)
+		return m, m.complete()
+
 	// We handle errors just like any other message
 	case errMsg:
 		m.err = msg
 		return m, nil
 	}
 
-	m.srcInput, cmd = m.srcInput.Update(msg)
-	m.destInput, cmd = m.destInput.Update(msg)
-	return m, cmd
+	m.inputs[m.focusedInput], cmd = m.inputs[m.focusedInput].Update(msg)
+	return m, tea.Batch(cmd, m.complete())
 }
 
 func (m sendreceiveModel) View() string {
 	title := StyleTitle("File Details")
-	return globalStyle(fmt.Sprintf(
-		"%v\n\n%s\n\n%s\n\n%s\n\n%s\n\n%s",
+	if m.note != "" {
+		title += "\n\n" + m.note
+	}
+
+	lines := []string{
 		title,
-		"File(s) to copy:",
-		m.srcInput.View(),
+		"File(s) to copy (separated by spaces, globs such as *.log are expanded):",
+		m.inputs[inputSrc].View(),
 		"Place to copy them to:",
-		m.destInput.View(),
-		"(esc to quit)",
-	) + "\n")
+		m.inputs[inputDest].View(),
+	}
+	if len(m.inputs) > inputLayout {
+		lines = append(lines,
+			"Name received files with ("+strings.Join(transfer.LayoutNames, ", ")+" or a template):",
+			m.inputs[inputLayout].View())
+	}
+	if m.err != nil {
+		lines = append(lines, m.err.Error())
+	}
+	lines = append(lines, "(tab to complete or move, ctrl+n/ctrl+p for other completions, esc to quit)")
+
+	return globalStyle(strings.Join(lines, "\n\n") + "\n")
 }
 
-func newSendreceiveModel() sendreceiveModel {
-	src := textinput.New()
-	src.Placeholder = "/file/to/copy"
-	src.CharLimit = 156
-	src.Width = 20
-	src.Focus()
+// Request returns what was typed, or an empty request when the prompt was
+// quit
+func (m sendreceiveModel) Request() TransferRequest {
+	if !m.submitted {
+		return TransferRequest{}
+	}
+	r := TransferRequest{
+		Sources: transfer.SplitSources(m.inputs[inputSrc].Value()),
+		Dest:    m.inputs[inputDest].Value(),
+		Globs:   true,
+	}
+	if len(m.inputs) > inputLayout {
+		r.Layout = m.inputs[inputLayout].Value()
+	}
+	return r
+}
 
-	dest := textinput.New()
-	dest.Placeholder = "/place/to/copy/to"
-	dest.CharLimit = 156
-	dest.Width = 20
+func newPathInput(placeholder string) textinput.Model {
+	ti := textinput.New()
+	ti.Placeholder = placeholder
+	ti.CharLimit = 1024
+	ti.Width = 60
+	ti.ShowSuggestions = true
+	// up and down move between the inputs
+	ti.KeyMap.NextSuggestion = key.NewBinding(key.WithKeys("ctrl+n"))
+	ti.KeyMap.PrevSuggestion = key.NewBinding(key.WithKeys("ctrl+p"))
+	return ti
+}
+
+// newSendreceiveModel completes the local side from the filesystem and the
+// remote side with the remote lister, when there is one. Several sources can
+// be typed, so the source input completes its last word. Receiving also asks
+// how to name the received files.
+func newSendreceiveModel(note string, d transfer.Direction, remote lister) sendreceiveModel {
+	var remoteCompleter *pathCompleter
+	if remote != nil {
+		remoteCompleter = newPathCompleter(remote)
+	}
 
-	return sendreceiveModel{
-		focusedInput: 0,
-		srcInput:     src,
-		destInput:    dest,
-		err:          nil,
+	m := sendreceiveModel{
+		note:   note,
+		inputs: []textinput.Model{newPathInput("/file/to/copy"), newPathInput("/place/to/copy/to")},
+	}
+	if d == transfer.Send {
+		m.completers = []*pathCompleter{newPathCompleter(localLister), remoteCompleter}
+	} else {
+		layout := newPathInput(transfer.GetLayout())
+		layout.SetValue(transfer.GetLayout())
+		layout.SetSuggestions(transfer.LayoutNames)
+		m.inputs = append(m.inputs, layout)
+		m.completers = []*pathCompleter{remoteCompleter, newPathCompleter(localLister), nil}
+	}
+	if m.completers[inputSrc] != nil {
+		m.completers[inputSrc].words = true
 	}
+	m.focus(inputSrc)
+	return m
 }
 
-func SendReceive() (string, string, error) {
-	p := tea.NewProgram(newSendreceiveModel(), tea.WithAltScreen())
+func typedSendReceive(note string, d transfer.Direction, remote lister) (TransferRequest, error) {
+	p := tea.NewProgram(newSendreceiveModel(note, d, remote), tea.WithAltScreen())
 	if m, err := p.Run(); err != nil {
-		return "", "", err
+		return TransferRequest{}, err
 	} else {
-		model := m.(sendreceiveModel)
-		return model.srcInput.Value(), model.destInput.Value(), nil
+		return m.(sendreceiveModel).Request(), nil
+	}
+}
+
+// SendReceive asks for the sources and destination of a transfer. It browses
+// the first connection over SFTP and falls back to typing the paths when the
+// connection cannot be opened.
+func SendReceive(items []connection.Item, d transfer.Direction) (TransferRequest, error) {
+	fmt.Printf("Connecting to %v to browse its files...\n", items[0].WindowName())
+	client, err := transfer.Dial(items[0])
+	if err != nil {
+		return typedSendReceive(fmt.Sprintf("Could not browse %v: %v", items[0].WindowName(), err), d, sshLister(items[0]))
+	}
+	defer client.Close()
+
+	c, err := sftp.NewClient(client)
+	if err != nil {
+		return typedSendReceive(fmt.Sprintf("Could not browse %v: %v", items[0].WindowName(), err), d, sshLister(items[0]))
+	}
+	defer c.Close()
+
+	r, typed, err := FileBrowser(c, items[0], d)
+	if typed {
+		return typedSendReceive("", d, sftpLister(c))
 	}
+	return r, err
 }
@@ -0,0 +1,110 @@
+package menus
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/tunnel"
+)
+
+// proxyExitMsg is sent when the proxy's supervisor gives up
+type proxyExitMsg struct {
+	err error
+}
+
+type socksproxyModel struct {
+	item    connection.Item
+	forward connection.Forward
+	state   tunnel.State
+	running bool
+	err     error
+	exited  <-chan error
+
+	// the tunnel package, replaced in tests
+	find func(conn string, forward string) (tunnel.State, bool)
+}
+
+func (m socksproxyModel) waitExit() tea.Cmd {
+	exited := m.exited
+	return func() tea.Msg {
+		return proxyExitMsg{err: <-exited}
+	}
+}
+
+func (m socksproxyModel) Init() tea.Cmd {
+	return tea.Batch(tunnelTick(), m.waitExit())
+}
+
+func (m socksproxyModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c", "esc", "q", "enter":
+			return m, tea.Quit
+		}
+	case tunnelTickMsg:
+		m.state, m.running = m.find(m.item.Name, m.forward.Label())
+		return m, tunnelTick()
+	case proxyExitMsg:
+		m.err = msg.err
+		if m.err == nil {
+			m.err = fmt.Errorf("proxy stopped")
+		}
+		m.running = false
+	}
+	return m, nil
+}
+
+func (m socksproxyModel) View() string {
+	addr := "socks5://" + m.forward.Listen
+	status := tunnel.StatusConnecting
+	if m.running {
+		status = tunnel.Describe(m.state, time.Now())
+	}
+	if m.err != nil {
+		status = "failed: " + m.err.Error()
+	}
+
+	return globalStyle(strings.Join([]string{
+		StyleTitle("SOCKS Proxy"),
+		"",
+		fmt.Sprintf("Through %v on %v", m.item.WindowName(), addr),
+		"Status: " + status,
+		"",
+		"Point your browser or tool at the proxy, for example:",
+		fmt.Sprintf("  chromium --proxy-server=%v", addr),
+		fmt.Sprintf("  curl --proxy socks5h://%v http://internal-ui/", m.forward.Listen),
+		"",
+		"(enter or esc to stop the proxy)",
+	}, "\n") + "\n")
+}
+
+// SocksProxy runs a SOCKS proxy through the connection on a free local port
+// until the user dismisses it. It reconnects like a tunnel if the connection
+// drops.
+func SocksProxy(item connection.Item) error {
+	port, err := tunnel.FreePort()
+	if err != nil {
+		return err
+	}
+	f := tunnel.SocksForward(port)
+
+	stop := make(chan os.Signal, 1)
+	exited := make(chan error, 1)
+	finished := make(chan struct{})
+	go func() {
+		exited <- tunnel.SuperviseInteractive(item, f, stop)
+		close(finished)
+	}()
+
+	m := socksproxyModel{item: item, forward: f, exited: exited, find: tunnel.Find}
+	_, err = tea.NewProgram(m, tea.WithAltScreen()).Run()
+
+	stop <- os.Interrupt
+	<-finished
+	return err
+}
@@ -0,0 +1,43 @@
+package menus
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/tunnel"
+)
+
+func TestSocksproxyModel(t *testing.T) {
+	f := tunnel.SocksForward(40123)
+	running := false
+	m := socksproxyModel{
+		item:    connection.Item{Name: "bastion"},
+		forward: f,
+		find: func(conn string, forward string) (tunnel.State, bool) {
+			if conn != "bastion" || forward != "socks-40123" {
+				t.Errorf("find(%v, %v)", conn, forward)
+			}
+			return tunnel.State{Status: tunnel.StatusUp, Since: time.Now()}, running
+		},
+	}
+
+	if !strings.Contains(m.View(), "socks5://127.0.0.1:40123") || !strings.Contains(m.View(), "Status: connecting") {
+		t.Errorf("View() before the proxy is up:\n%v", m.View())
+	}
+
+	running = true
+	model, cmd := m.Update(tunnelTickMsg(time.Now()))
+	m = model.(socksproxyModel)
+	if cmd == nil || !strings.Contains(m.View(), "Status: up for") {
+		t.Errorf("View() once up:\n%v", m.View())
+	}
+
+	model, _ = m.Update(proxyExitMsg{err: errors.New("no runtime dir")})
+	m = model.(socksproxyModel)
+	if !strings.Contains(m.View(), "failed: no runtime dir") {
+		t.Errorf("View() after the proxy failed:\n%v", m.View())
+	}
+}
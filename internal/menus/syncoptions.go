@@ -0,0 +1,196 @@
+package menus
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/nicknickel/gossh/internal/rsync"
+)
+
+const (
+	syncFieldDirection = iota
+	syncFieldLocal
+	syncFieldRemote
+	syncFieldInclude
+	syncFieldExclude
+	syncFieldBwLimit
+	syncFieldDelete
+	syncFieldDryRun
+	syncFieldCount
+)
+
+type syncoptionsModel struct {
+	focused   int
+	direction rsync.Direction
+	delete    bool
+	dryRun    bool
+	inputs    map[int]*textinput.Model
+	submitted bool
+	err       error
+}
+
+func (m syncoptionsModel) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (m *syncoptionsModel) focus(field int) {
+	m.focused = (field + syncFieldCount) % syncFieldCount
+	for ind, input := range m.inputs {
+		if ind == m.focused {
+			input.Focus()
+		} else {
+			input.Blur()
+		}
+	}
+}
+
+func (m *syncoptionsModel) toggle() {
+	switch m.focused {
+	case syncFieldDirection:
+		if m.direction == rsync.Push {
+			m.direction = rsync.Pull
+		} else {
+			m.direction = rsync.Push
+		}
+	case syncFieldDelete:
+		m.delete = !m.delete
+	case syncFieldDryRun:
+		m.dryRun = !m.dryRun
+	}
+}
+
+func (m syncoptionsModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "enter":
+			// move to the first missing path before accepting
+			if m.inputs[syncFieldLocal].Value() == "" {
+				m.focus(syncFieldLocal)
+			} else if m.inputs[syncFieldRemote].Value() == "" {
+				m.focus(syncFieldRemote)
+			} else {
+				m.submitted = true
+				return m, tea.Quit
+			}
+			return m, nil
+		case "ctrl+c", "esc":
+			return m, tea.Quit
+		case "tab", "down":
+			m.focus(m.focused + 1)
+			return m, nil
+		case "shift+tab", "up":
+			m.focus(m.focused - 1)
+			return m, nil
+		case " ", "left", "right":
+			if _, ok := m.inputs[m.focused]; !ok {
+				m.toggle()
+				return m, nil
+			}
+		}
+
+	// We handle errors just like any other message
+	case errMsg:
+		m.err = msg
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	if input, ok := m.inputs[m.focused]; ok {
+		*input, cmd = input.Update(msg)
+	}
+	return m, cmd
+}
+
+func checkbox(label string, checked bool) string {
+	if checked {
+		return "[x] " + label
+	}
+	return "[ ] " + label
+}
+
+func (m syncoptionsModel) fieldView(field int, label string, view string) string {
+	cursor := "  "
+	if m.focused == field {
+		cursor = "> "
+	}
+	if label == "" {
+		return cursor + view
+	}
+	return fmt.Sprintf("%v%v\n  %v", cursor, label, view)
+}
+
+func (m syncoptionsModel) View() string {
+	direction := "push (local -> remote)"
+	if m.direction == rsync.Pull {
+		direction = "pull (remote -> local, one directory per host)"
+	}
+
+	lines := []string{
+		StyleTitle("Sync Details"),
+		m.fieldView(syncFieldDirection, "", "Direction: "+direction),
+		m.fieldView(syncFieldLocal, "Local path:", m.inputs[syncFieldLocal].View()),
+		m.fieldView(syncFieldRemote, "Remote path:", m.inputs[syncFieldRemote].View()),
+		m.fieldView(syncFieldInclude, "Include patterns (comma separated):", m.inputs[syncFieldInclude].View()),
+		m.fieldView(syncFieldExclude, "Exclude patterns (comma separated):", m.inputs[syncFieldExclude].View()),
+		m.fieldView(syncFieldBwLimit, "Bandwidth limit (KiB/s, or with K/M/G suffix):", m.inputs[syncFieldBwLimit].View()),
+		m.fieldView(syncFieldDelete, "", checkbox("Delete files missing from the source", m.delete)),
+		m.fieldView(syncFieldDryRun, "", checkbox("Dry run (preview changes first)", m.dryRun)),
+		"(tab to move, space to toggle, enter to start, esc to quit)",
+	}
+	return globalStyle(strings.Join(lines, "\n\n") + "\n")
+}
+
+func (m syncoptionsModel) Options() rsync.Options {
+	return rsync.Options{
+		Direction: m.direction,
+		Local:     m.inputs[syncFieldLocal].Value(),
+		Remote:    m.inputs[syncFieldRemote].Value(),
+		Delete:    m.delete,
+		DryRun:    m.dryRun,
+		Include:   rsync.SplitPatterns(m.inputs[syncFieldInclude].Value()),
+		Exclude:   rsync.SplitPatterns(m.inputs[syncFieldExclude].Value()),
+		BwLimit:   strings.TrimSpace(m.inputs[syncFieldBwLimit].Value()),
+	}
+}
+
+func newSyncoptionsModel() syncoptionsModel {
+	placeholders := map[int]string{
+		syncFieldLocal:   "/local/dir/",
+		syncFieldRemote:  "/remote/dir/",
+		syncFieldInclude: "*.conf, keep/",
+		syncFieldExclude: "*.log, .git/",
+		syncFieldBwLimit: "unlimited",
+	}
+
+	inputs := map[int]*textinput.Model{}
+	for field, placeholder := range placeholders {
+		ti := textinput.New()
+		ti.Placeholder = placeholder
+		ti.CharLimit = 156
+		ti.Width = 40
+		inputs[field] = &ti
+	}
+
+	m := syncoptionsModel{
+		direction: rsync.Push,
+		dryRun:    true,
+		inputs:    inputs,
+	}
+	m.focus(syncFieldLocal)
+	return m
+}
+
+// SyncOptions asks for the sync paths and options. The bool is false when the
+// user quit without starting the sync.
+func SyncOptions() (rsync.Options, bool, error) {
+	p := tea.NewProgram(newSyncoptionsModel(), tea.WithAltScreen())
+	m, err := p.Run()
+	if err != nil {
+		return rsync.Options{}, false, err
+	}
+	model := m.(syncoptionsModel)
+	return model.Options(), model.submitted, nil
+}
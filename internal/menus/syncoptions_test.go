@@ -0,0 +1,59 @@
+package menus
+
+import (
+	"slices"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/nicknickel/gossh/internal/rsync"
+)
+
+func typeKeys(m syncoptionsModel, keys ...tea.KeyMsg) syncoptionsModel {
+	for _, k := range keys {
+		model, _ := m.Update(k)
+		m = model.(syncoptionsModel)
+	}
+	return m
+}
+
+func runes(s string) tea.KeyMsg {
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)}
+}
+
+func TestSyncOptionsModel(t *testing.T) {
+	tab := tea.KeyMsg{Type: tea.KeyTab}
+	shiftTab := tea.KeyMsg{Type: tea.KeyShiftTab}
+	space := tea.KeyMsg{Type: tea.KeySpace, Runes: []rune(" ")}
+	enter := tea.KeyMsg{Type: tea.KeyEnter}
+
+	m := newSyncoptionsModel()
+
+	// enter without paths moves to the missing path instead of starting
+	m = typeKeys(m, enter)
+	if m.submitted || m.focused != syncFieldLocal {
+		t.Fatalf("enter without paths: submitted = %v, focused = %v", m.submitted, m.focused)
+	}
+
+	m = typeKeys(m, runes("site/"), shiftTab, space, tab, tab, runes("/var/www"), tab, runes("keep.log"),
+		tab, runes("*.log, .git/"), tab, runes("2M"), tab, space, tab, space, enter)
+	if !m.submitted {
+		t.Fatalf("expected form to be submitted")
+	}
+
+	got := m.Options()
+	expected := rsync.Options{
+		Direction: rsync.Pull,
+		Local:     "site/",
+		Remote:    "/var/www",
+		Delete:    true,
+		DryRun:    false,
+		Include:   []string{"keep.log"},
+		Exclude:   []string{"*.log", ".git/"},
+		BwLimit:   "2M",
+	}
+	if got.Direction != expected.Direction || got.Local != expected.Local || got.Remote != expected.Remote ||
+		got.Delete != expected.Delete || got.DryRun != expected.DryRun || got.BwLimit != expected.BwLimit ||
+		!slices.Equal(got.Include, expected.Include) || !slices.Equal(got.Exclude, expected.Exclude) {
+		t.Errorf("Options() = %+v, want %+v", got, expected)
+	}
+}
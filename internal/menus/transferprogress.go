@@ -0,0 +1,172 @@
+package menus
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/progress"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/nicknickel/gossh/internal/transfer"
+)
+
+const progressInterval = 200 * time.Millisecond
+
+type progressTickMsg time.Time
+
+type transfersDoneMsg struct{}
+
+type transferprogressModel struct {
+	jobs    []*transfer.Job
+	bar     progress.Model
+	started time.Time
+	done    bool
+}
+
+func progressTick() tea.Cmd {
+	return tea.Tick(progressInterval, func(t time.Time) tea.Msg {
+		return progressTickMsg(t)
+	})
+}
+
+func (m transferprogressModel) Init() tea.Cmd {
+	jobs := m.jobs
+	run := func() tea.Msg {
+		transfer.RunAll(jobs)
+		return transfersDoneMsg{}
+	}
+	return tea.Batch(run, progressTick())
+}
+
+func (m transferprogressModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		if msg.String() == "ctrl+c" {
+			return m, tea.Quit
+		}
+	case tea.WindowSizeMsg:
+		h, _ := docStyle.GetFrameSize()
+		m.bar.Width = max(10, min(60, msg.Width-h-50))
+	case progressTickMsg:
+		if m.done {
+			return m, nil
+		}
+		return m, progressTick()
+	case transfersDoneMsg:
+		m.done = true
+		return m, tea.Quit
+	}
+
+	return m, nil
+}
+
+func FormatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+// TransferPreview lists what each job will copy, or why it can not run
+func TransferPreview(jobs []*transfer.Job) string {
+	var b strings.Builder
+	for _, job := range jobs {
+		if job.Err != nil {
+			fmt.Fprintf(&b, "%v: %v\n", job.Item.WindowName(), job.Err)
+			continue
+		}
+		_, total := job.Progress()
+		fmt.Fprintf(&b, "%v: %v item(s), %v\n", job.Item.WindowName(), len(job.Paths), FormatBytes(total))
+		for _, p := range job.Paths {
+			fmt.Fprintf(&b, "  %v -> %v\n", p.Src, p.Dest)
+		}
+	}
+	return b.String()
+}
+
+// transferStats returns the rate in bytes per second and the time remaining
+func transferStats(done int64, total int64, elapsed time.Duration) (float64, time.Duration) {
+	if elapsed <= 0 || done <= 0 {
+		return 0, 0
+	}
+	rate := float64(done) / elapsed.Seconds()
+	remaining := time.Duration(float64(total-done)/rate) * time.Second
+	return rate, remaining.Round(time.Second)
+}
+
+func (m transferprogressModel) jobLine(name string, done int64, total int64, started time.Time, status string) string {
+	percent := 0.0
+	if total > 0 {
+		percent = float64(done) / float64(total)
+	} else if status == "done" {
+		percent = 1
+	}
+
+	stats := ""
+	if !started.IsZero() && status == "" {
+		rate, eta := transferStats(done, total, time.Since(started))
+		stats = fmt.Sprintf("%v/s ETA %v", FormatBytes(int64(rate)), eta)
+	}
+
+	return fmt.Sprintf("%-30.30s %s %10s / %-10s %s%s",
+		name, m.bar.ViewAs(percent), FormatBytes(done), FormatBytes(total), stats, status)
+}
+
+func (m transferprogressModel) View() string {
+	var sb strings.Builder
+	sb.WriteString(StyleTitle("File Transfers") + "\n\n")
+
+	var allDone, allTotal int64
+	for _, job := range m.jobs {
+		done, total := job.Progress()
+		allDone += done
+		allTotal += total
+
+		status := ""
+		if job.Verifying() {
+			status = "verifying"
+		}
+		if job.Finished() {
+			if job.Err != nil {
+				status = lipgloss.NewStyle().Foreground(lipgloss.Color("#db0404")).Render("failed")
+			} else {
+				status = "done"
+			}
+		}
+		sb.WriteString(m.jobLine(job.Item.WindowName(), done, total, job.Started(), status) + "\n")
+	}
+
+	status := ""
+	if m.done {
+		status = "done"
+	}
+	sb.WriteString("\n" + m.jobLine("Total", allDone, allTotal, m.started, status) + "\n\n")
+	sb.WriteString("(ctrl+c to quit)")
+
+	return globalStyle(sb.String() + "\n")
+}
+
+func newTransferprogressModel(jobs []*transfer.Job) transferprogressModel {
+	return transferprogressModel{
+		jobs:    jobs,
+		bar:     progress.New(progress.WithDefaultGradient(), progress.WithoutPercentage(), progress.WithWidth(30)),
+		started: time.Now(),
+	}
+}
+
+// TransferProgress runs the jobs showing per host and aggregate progress. It
+// returns once all jobs have finished or the user quits.
+func TransferProgress(jobs []*transfer.Job) error {
+	p := tea.NewProgram(newTransferprogressModel(jobs))
+	if _, err := p.Run(); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package menus
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/transfer"
+)
+
+func TestFormatBytes(t *testing.T) {
+	tests := map[int64]string{
+		0:                      "0 B",
+		1023:                   "1023 B",
+		1024:                   "1.0 KiB",
+		1536:                   "1.5 KiB",
+		5 * 1024 * 1024:        "5.0 MiB",
+		3 * 1024 * 1024 * 1024: "3.0 GiB",
+	}
+
+	for b, expected := range tests {
+		if got := FormatBytes(b); got != expected {
+			t.Errorf("FormatBytes(%d) = %v, want %v", b, got, expected)
+		}
+	}
+}
+
+func TestTransferStats(t *testing.T) {
+	rate, eta := transferStats(500, 1500, 5*time.Second)
+	if rate != 100 {
+		t.Errorf("transferStats() rate = %v, want 100", rate)
+	}
+	if eta != 10*time.Second {
+		t.Errorf("transferStats() eta = %v, want 10s", eta)
+	}
+
+	if rate, eta := transferStats(0, 1500, time.Second); rate != 0 || eta != 0 {
+		t.Errorf("transferStats() before any progress = %v, %v, want 0, 0", rate, eta)
+	}
+}
+
+func TestTransferPreview(t *testing.T) {
+	local := t.TempDir()
+	os.WriteFile(filepath.Join(local, "a.log"), make([]byte, 2048), 0644)
+
+	jobs, err := transfer.PlanSend([]connection.Item{{Name: "web1"}}, []string{filepath.Join(local, "*.log")}, "/srv", true)
+	if err != nil {
+		t.Fatalf("PlanSend() err = %v", err)
+	}
+	failed := transfer.NewMultiJob(connection.Item{Name: "web2"}, transfer.Receive, nil)
+	failed.Err = errors.New("connection refused")
+
+	preview := TransferPreview(append(jobs, failed))
+	for _, expected := range []string{"web1: 1 item(s), 2.0 KiB", filepath.Join(local, "a.log") + " -> /srv/a.log", "web2: connection refused"} {
+		if !strings.Contains(preview, expected) {
+			t.Errorf("TransferPreview() missing %q:\n%v", expected, preview)
+		}
+	}
+}
@@ -0,0 +1,452 @@
+package menus
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/nicknickel/gossh/internal/config"
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/log"
+)
+
+const (
+	GroupFile   = "file"
+	GroupGroup  = "group"
+	GroupTag    = "tag"
+	GroupFolder = "folder"
+)
+
+// treeGroupings in the order they are cycled through
+var treeGroupings = []string{GroupFile, GroupGroup, GroupTag, GroupFolder}
+
+// GetTreeView reads GOSSH_VIEW: list (default) or tree
+func GetTreeView() bool {
+	switch view := strings.ToLower(os.Getenv("GOSSH_VIEW")); view {
+	case "tree":
+		return true
+	case "", "list":
+		return false
+	default:
+		log.Logger.Error("Unknown GOSSH_VIEW, using list", "value", view)
+		return false
+	}
+}
+
+// GetTreeGrouping reads GOSSH_TREE_GROUP: file (default), group, tag or folder
+func GetTreeGrouping() string {
+	grouping := strings.ToLower(os.Getenv("GOSSH_TREE_GROUP"))
+	if grouping == "" {
+		return GroupFile
+	}
+	if !slices.Contains(treeGroupings, grouping) {
+		log.Logger.Error("Unknown GOSSH_TREE_GROUP, using file", "value", grouping)
+		return GroupFile
+	}
+	return grouping
+}
+
+// treeNode is a folder of connections
+type treeNode struct {
+	name     string
+	path     string
+	children []*treeNode
+	items    []connection.Item
+}
+
+func (n *treeNode) child(name string) *treeNode {
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	c := &treeNode{name: name, path: n.path + "/" + name}
+	n.children = append(n.children, c)
+	return c
+}
+
+// all is the connections of the folder and its subfolders, each once
+func (n *treeNode) all() []connection.Item {
+	var items []connection.Item
+	seen := map[string]bool{}
+	var walk func(*treeNode)
+	walk = func(n *treeNode) {
+		for _, i := range n.items {
+			if !seen[i.Name] {
+				seen[i.Name] = true
+				items = append(items, i)
+			}
+		}
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	walk(n)
+	return items
+}
+
+// displayPath shortens the config file for the tree
+func displayPath(file string) string {
+	file = filepath.Clean(file)
+	if home, err := os.UserHomeDir(); err == nil && strings.HasPrefix(file, home+string(filepath.Separator)) {
+		return "~" + file[len(home):]
+	}
+	return file
+}
+
+// folderPaths is the folders the grouping puts the connection in. Tags can
+// put it in several, an empty path is the top of the tree.
+func folderPaths(i connection.Item, grouping string) [][]string {
+	switch grouping {
+	case GroupGroup:
+		if i.Conn.Group == "" {
+			return [][]string{{"(no group)"}}
+		}
+		return [][]string{{i.Conn.Group}}
+	case GroupTag:
+		if len(i.Conn.Tags) == 0 {
+			return [][]string{{"(untagged)"}}
+		}
+		var paths [][]string
+		for _, tag := range i.Conn.Tags {
+			paths = append(paths, []string{tag})
+		}
+		return paths
+	case GroupFolder:
+		var path []string
+		for _, part := range strings.Split(i.Conn.Folder, "/") {
+			if part = strings.TrimSpace(part); part != "" {
+				path = append(path, part)
+			}
+		}
+		return [][]string{path}
+	default:
+		if i.Conn.Source == "" {
+			return [][]string{{"(unknown file)"}}
+		}
+		return [][]string{{displayPath(i.Conn.Source)}}
+	}
+}
+
+// buildTree groups the items, which keep their order within a folder, into
+// alphabetically ordered folders
+func buildTree(items []connection.Item, grouping string) *treeNode {
+	root := &treeNode{}
+	for _, i := range items {
+		for _, path := range folderPaths(i, grouping) {
+			n := root
+			for _, name := range path {
+				n = n.child(name)
+			}
+			n.items = append(n.items, i)
+		}
+	}
+
+	var sortChildren func(*treeNode)
+	sortChildren = func(n *treeNode) {
+		slices.SortStableFunc(n.children, func(a, b *treeNode) int {
+			return strings.Compare(config.NormalizeString(a.name), config.NormalizeString(b.name))
+		})
+		for _, c := range n.children {
+			sortChildren(c)
+		}
+	}
+	sortChildren(root)
+	return root
+}
+
+// treeRow is a folder, when node is set, or a connection of the tree
+type treeRow struct {
+	depth    int
+	node     *treeNode
+	item     connection.Item
+	expanded bool
+}
+
+// treeModel is the state of the tree view of the connection list
+type treeModel struct {
+	enabled   bool
+	grouping  string
+	folded    map[string]bool
+	cursor    int
+	offset    int
+	filter    textinput.Model
+	filtering bool
+	height    int
+	width     int
+}
+
+func newTreeModel() treeModel {
+	ti := textinput.New()
+	ti.Prompt = "Filter: "
+	return treeModel{
+		enabled:  GetTreeView(),
+		grouping: GetTreeGrouping(),
+		folded:   map[string]bool{},
+		filter:   ti,
+	}
+}
+
+// isExpanded reports whether a folder is open. Folders start folded unless
+// they are the only folder at their level, and are all open while filtering.
+func (t treeModel) isExpanded(n *treeNode, parent *treeNode) bool {
+	if t.filter.Value() != "" {
+		return true
+	}
+	if folded, ok := t.folded[n.path]; ok {
+		return !folded
+	}
+	return len(parent.children) == 1
+}
+
+// treeItems is the connections matching the tree filter in list order
+func (m connectionlistModel) treeItems() []connection.Item {
+	var items []connection.Item
+	for _, val := range m.list.Items() {
+		items = append(items, val.(connection.Item))
+	}
+	term := m.tree.filter.Value()
+	if term == "" {
+		return items
+	}
+
+	targets := make([]string, len(items))
+	for ind, i := range items {
+		targets[ind] = i.FilterValue()
+	}
+	var matched []int
+	for _, r := range m.list.Filter(term, targets) {
+		matched = append(matched, r.Index)
+	}
+	slices.Sort(matched)
+
+	var filtered []connection.Item
+	for _, ind := range matched {
+		filtered = append(filtered, items[ind])
+	}
+	return filtered
+}
+
+// treeRows is the folders and connections shown in the tree
+func (m connectionlistModel) treeRows() []treeRow {
+	var rows []treeRow
+	var walk func(n *treeNode, depth int)
+	walk = func(n *treeNode, depth int) {
+		for _, c := range n.children {
+			expanded := m.tree.isExpanded(c, n)
+			rows = append(rows, treeRow{depth: depth, node: c, expanded: expanded})
+			if expanded {
+				walk(c, depth+1)
+			}
+		}
+		for _, i := range n.items {
+			rows = append(rows, treeRow{depth: depth, item: i})
+		}
+	}
+	walk(buildTree(m.treeItems(), m.tree.grouping), 0)
+	return rows
+}
+
+// treeFolder is the folder of the row at the cursor: the folder itself, or
+// the folder containing the connection. Connections at the top of the tree
+// belong to the whole tree.
+func (m connectionlistModel) treeFolder(rows []treeRow) []connection.Item {
+	if m.tree.cursor >= len(rows) {
+		return nil
+	}
+	row := rows[m.tree.cursor]
+	if row.node != nil {
+		return row.node.all()
+	}
+	for ind := m.tree.cursor - 1; ind >= 0; ind-- {
+		if rows[ind].node != nil && rows[ind].depth < row.depth {
+			return rows[ind].node.all()
+		}
+	}
+	return m.treeItems()
+}
+
+// checkAll checks the items, or unchecks them when all are checked already
+func (m *connectionlistModel) checkAll(items []connection.Item) {
+	all := true
+	for _, i := range items {
+		all = all && m.list.Items()[i.Index].(connection.Item).Checked
+	}
+	for _, i := range items {
+		m.setChecked(i, !all)
+	}
+}
+
+// scroll keeps the cursor within the rows and on screen
+func (t *treeModel) scroll(rows int) {
+	t.cursor = max(0, min(t.cursor, rows-1))
+	visible := max(1, t.height-4)
+	if t.cursor < t.offset {
+		t.offset = t.cursor
+	}
+	if t.cursor >= t.offset+visible {
+		t.offset = t.cursor - visible + 1
+	}
+}
+
+// updateTree handles the keys of the tree view, and reports whether the key
+// was handled. Action keys are left to the connection list.
+func (m connectionlistModel) updateTree(msg tea.KeyMsg) (connectionlistModel, tea.Cmd, bool) {
+	if m.tree.filtering {
+		switch msg.String() {
+		case "enter":
+			m.tree.filtering = false
+			m.tree.filter.Blur()
+		case "esc":
+			m.tree.filtering = false
+			m.tree.filter.Blur()
+			m.tree.filter.SetValue("")
+		default:
+			var cmd tea.Cmd
+			m.tree.filter, cmd = m.tree.filter.Update(msg)
+			m.tree.cursor, m.tree.offset = 0, 0
+			return m, cmd, true
+		}
+		m.tree.cursor, m.tree.offset = 0, 0
+		return m, nil, true
+	}
+
+	rows := m.treeRows()
+	var row treeRow
+	if m.tree.cursor < len(rows) {
+		row = rows[m.tree.cursor]
+	}
+	visible := max(1, m.tree.height-4)
+
+	switch msg.String() {
+	case "/":
+		m.tree.filtering = true
+		return m, m.tree.filter.Focus(), true
+	case "esc":
+		m.tree.filter.SetValue("")
+		m.tree.cursor, m.tree.offset = 0, 0
+	case "q":
+		return m, tea.Quit, true
+	case "up", "k":
+		m.tree.cursor--
+	case "down", "j":
+		m.tree.cursor++
+	case "pgup", "b":
+		m.tree.cursor -= visible
+	case "pgdown", "f":
+		m.tree.cursor += visible
+	case "home", "g":
+		m.tree.cursor = 0
+	case "end", "G":
+		m.tree.cursor = len(rows) - 1
+	case "left", "h":
+		if row.node != nil && row.expanded {
+			m.tree.folded[row.node.path] = true
+			break
+		}
+		for ind := m.tree.cursor - 1; ind >= 0; ind-- {
+			if rows[ind].node != nil && rows[ind].depth < row.depth {
+				m.tree.cursor = ind
+				break
+			}
+		}
+	case "right", "l":
+		if row.node != nil {
+			m.tree.folded[row.node.path] = false
+		}
+	default:
+		switch {
+		case key.Matches(msg, connectionListKeyBindings.Choose) && row.node != nil:
+			m.tree.folded[row.node.path] = row.expanded
+		case key.Matches(msg, connectionListKeyBindings.Select):
+			if row.node != nil {
+				m.checkAll(row.node.all())
+			} else if len(rows) > 0 {
+				m.setChecked(row.item, !m.list.Items()[row.item.Index].(connection.Item).Checked)
+			}
+		case key.Matches(msg, connectionListKeyBindings.SelectAll):
+			m.checkAll(m.treeFolder(rows))
+		default:
+			return m, nil, false
+		}
+	}
+	m.tree.scroll(len(m.treeRows()))
+	return m, nil, true
+}
+
+var (
+	treeFolderStyle   = lipgloss.NewStyle().Bold(true)
+	treeDimStyle      = lipgloss.NewStyle().Foreground(lipgloss.AdaptiveColor{Light: "#A49FA5", Dark: "#777777"})
+	treeSelectedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#06bf18"))
+)
+
+func (m connectionlistModel) treeView() string {
+	rows := m.treeRows()
+	var lines []string
+	lines = append(lines, m.list.Styles.Title.Render(m.list.Title+" · by "+m.tree.grouping), "")
+
+	visible := max(1, m.tree.height-4)
+	end := min(len(rows), m.tree.offset+visible)
+	for ind := m.tree.offset; ind < end; ind++ {
+		row := rows[ind]
+		indent := strings.Repeat("  ", row.depth)
+		var line string
+		if row.node != nil {
+			arrow := "▸ "
+			if row.expanded {
+				arrow = "▾ "
+			}
+			items := row.node.all()
+			checked := 0
+			for _, i := range items {
+				if m.list.Items()[i.Index].(connection.Item).Checked {
+					checked++
+				}
+			}
+			count := fmt.Sprintf(" (%v)", len(items))
+			if checked > 0 {
+				count = fmt.Sprintf(" (%v/%v)", checked, len(items))
+			}
+			line = indent + arrow + treeFolderStyle.Render(row.node.name) + treeDimStyle.Render(count)
+		} else {
+			item := m.list.Items()[row.item.Index].(connection.Item)
+			line = indent + "  " + item.Title() + "  " + treeDimStyle.Render(item.Description())
+		}
+		if ind == m.tree.cursor {
+			line = treeSelectedStyle.Render("│ ") + line
+		} else {
+			line = "  " + line
+		}
+		if m.tree.width > 0 {
+			line = lipgloss.NewStyle().MaxWidth(m.tree.width).Render(line)
+		}
+		lines = append(lines, line)
+	}
+	if len(rows) == 0 {
+		lines = append(lines, treeDimStyle.Render("  No connections."))
+	}
+	for len(lines) < visible+2 {
+		lines = append(lines, "")
+	}
+
+	lines = append(lines, "")
+	switch {
+	case m.tree.filtering:
+		lines = append(lines, m.tree.filter.View())
+	case m.tree.filter.Value() != "":
+		lines = append(lines, treeDimStyle.Render(fmt.Sprintf("filter: %v • esc clear • ", m.tree.filter.Value()))+m.treeHelp())
+	default:
+		lines = append(lines, m.treeHelp())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (m connectionlistModel) treeHelp() string {
+	return treeDimStyle.Render("enter connect/fold • ←/→ fold • space select • a select folder • / filter • T group • v list • q quit")
+}
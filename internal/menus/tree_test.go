@@ -0,0 +1,172 @@
+package menus
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/usage"
+)
+
+func treeTestItems() []list.Item {
+	conns := []connection.Connection{
+		{Source: "/etc/gossh/prod.yml", Group: "web", Folder: "prod/eu", Tags: []string{"prod", "web"}},
+		{Source: "/etc/gossh/prod.yml", Group: "web", Folder: "prod/us", Tags: []string{"prod"}},
+		{Source: "/etc/gossh/prod.yml", Group: "db", Folder: "prod/eu/db"},
+		{Source: "/etc/gossh/lab.yml", Folder: ""},
+	}
+	names := []string{"web-eu", "web-us", "db-eu", "lab"}
+	var items []list.Item
+	for ind, c := range conns {
+		items = append(items, connection.Item{Name: names[ind], Conn: c, Index: ind})
+	}
+	return items
+}
+
+// describeTree renders the rows as "name" lines indented by depth, folders
+// suffixed with /
+func describeTree(rows []treeRow) []string {
+	var lines []string
+	for _, r := range rows {
+		name := r.item.Name
+		if r.node != nil {
+			name = r.node.name + "/"
+		}
+		lines = append(lines, strings.Repeat(" ", r.depth)+name)
+	}
+	return lines
+}
+
+func TestTreeRows(t *testing.T) {
+	tests := []struct {
+		grouping string
+		expected []string
+	}{
+		{grouping: GroupFile, expected: []string{"/etc/gossh/lab.yml/", "/etc/gossh/prod.yml/"}},
+		{grouping: GroupGroup, expected: []string{"(no group)/", "db/", "web/"}},
+		{grouping: GroupTag, expected: []string{"(untagged)/", "prod/", "web/"}},
+		// a single folder is opened
+		{grouping: GroupFolder, expected: []string{"prod/", " eu/", " us/", "lab"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.grouping, func(t *testing.T) {
+			m := newConnectionlistModel(treeTestItems(), usage.State{})
+			m.tree.grouping = tt.grouping
+			if got := describeTree(m.treeRows()); !slices.Equal(got, tt.expected) {
+				t.Errorf("treeRows() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBuildTree_Tags(t *testing.T) {
+	var items []connection.Item
+	for _, val := range treeTestItems() {
+		items = append(items, val.(connection.Item))
+	}
+	root := buildTree(items, GroupTag)
+	var prod *treeNode
+	for _, c := range root.children {
+		if c.name == "prod" {
+			prod = c
+		}
+	}
+	if prod == nil || len(prod.all()) != 2 {
+		t.Fatalf("prod folder = %+v", prod)
+	}
+	// web-eu is in both the prod and web folders but counted once
+	if got := len(root.all()); got != 4 {
+		t.Errorf("all() = %v connections, want 4", got)
+	}
+}
+
+func TestConnectionlistModel_Tree(t *testing.T) {
+	t.Setenv("GOSSH_VIEW", "tree")
+	t.Setenv("GOSSH_TREE_GROUP", "folder")
+	m := newConnectionlistModel(treeTestItems(), usage.State{})
+	m.tree.height = 20
+	press := func(keys ...string) {
+		for _, k := range keys {
+			msg := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)}
+			switch k {
+			case "enter":
+				msg = tea.KeyMsg{Type: tea.KeyEnter}
+			case "esc":
+				msg = tea.KeyMsg{Type: tea.KeyEsc}
+			case " ":
+				msg = tea.KeyMsg{Type: tea.KeySpace, Runes: []rune(" ")}
+			}
+			model, _ := m.Update(msg)
+			m = model.(connectionlistModel)
+		}
+	}
+	if !m.tree.enabled || m.tree.grouping != GroupFolder {
+		t.Fatalf("tree = %v by %v", m.tree.enabled, m.tree.grouping)
+	}
+
+	// open prod/eu
+	press("j", "l")
+	if got := describeTree(m.treeRows()); !slices.Equal(got, []string{"prod/", " eu/", "  db/", "   db-eu", "  web-eu", " us/", "lab"}) {
+		t.Errorf("after expanding = %q", got)
+	}
+
+	// space checks the folder, a on web-eu then unchecks its folder
+	press(" ")
+	if m.CheckedCount != 2 {
+		t.Errorf("CheckedCount after checking eu/ = %v", m.CheckedCount)
+	}
+	press("j", "j", "j", "a")
+	if m.CheckedCount != 0 {
+		t.Errorf("CheckedCount after a = %v", m.CheckedCount)
+	}
+
+	// h moves to the parent and folds it
+	press("h", "h")
+	if got := describeTree(m.treeRows()); !slices.Equal(got, []string{"prod/", " eu/", " us/", "lab"}) || m.tree.cursor != 1 {
+		t.Errorf("after folding = %q, cursor %v", got, m.tree.cursor)
+	}
+
+	// the filter opens the matching folders only
+	press("/", "w", "e", "b", "-", "u", "enter")
+	if got := describeTree(m.treeRows()); !slices.Equal(got, []string{"prod/", " us/", "  web-us"}) {
+		t.Errorf("filtered = %q", got)
+	}
+	press("esc")
+	if m.tree.filter.Value() != "" {
+		t.Errorf("esc did not clear the filter")
+	}
+
+	// actions on a folder take all of its connections
+	press("j")
+	model, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("c")})
+	m = model.(connectionlistModel)
+	var names []string
+	for _, i := range m.GetCheckedItems() {
+		names = append(names, i.Name)
+	}
+	if cmd == nil || m.Action != "RunCommand" || !slices.Equal(names, []string{"web-eu", "db-eu"}) {
+		t.Errorf("action = %v on %v", m.Action, names)
+	}
+}
+
+func TestConnectionlistModel_TreeToggle(t *testing.T) {
+	m := newConnectionlistModel(treeTestItems(), usage.State{})
+	m.list.SetFilterText("lab")
+	model, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("v")})
+	m = model.(connectionlistModel)
+	if !m.tree.enabled || m.tree.filter.Value() != "lab" {
+		t.Errorf("tree = %v with filter %q", m.tree.enabled, m.tree.filter.Value())
+	}
+	if view := m.View(); !strings.Contains(view, "lab") || strings.Contains(view, "web-eu") {
+		t.Errorf("View() =\n%v", view)
+	}
+
+	model, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("v")})
+	if model.(connectionlistModel).tree.enabled {
+		t.Errorf("v did not return to the list")
+	}
+}
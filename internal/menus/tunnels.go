@@ -0,0 +1,219 @@
+package menus
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/tunnel"
+)
+
+const tunnelRefreshInterval = time.Second
+
+type tunnelRow struct {
+	item    connection.Item
+	forward connection.Forward
+}
+
+type tunnelRefreshMsg struct {
+	states []tunnel.State
+	err    error
+}
+
+type tunnelTickMsg time.Time
+
+// tunnelActionMsg reports the result of starting or stopping tunnels
+type tunnelActionMsg string
+
+type tunnelsModel struct {
+	rows    []tunnelRow
+	states  map[string]tunnel.State
+	cursor  int
+	notes   []string
+	message string
+	busy    bool
+
+	// the tunnel package, replaced in tests
+	start func(connection.Item, connection.Forward) error
+	stop  func(tunnel.State) error
+	list  func() ([]tunnel.State, error)
+}
+
+func (m tunnelsModel) refresh() tea.Cmd {
+	list := m.list
+	return func() tea.Msg {
+		states, err := list()
+		return tunnelRefreshMsg{states: states, err: err}
+	}
+}
+
+func tunnelTick() tea.Cmd {
+	return tea.Tick(tunnelRefreshInterval, func(t time.Time) tea.Msg {
+		return tunnelTickMsg(t)
+	})
+}
+
+func (m tunnelsModel) Init() tea.Cmd {
+	return tea.Batch(m.refresh(), tunnelTick())
+}
+
+func (m tunnelsModel) state(r tunnelRow) (tunnel.State, bool) {
+	st, ok := m.states[tunnel.ID(r.item.Name, r.forward.Label())]
+	return st, ok
+}
+
+// act starts or stops the rows in the background, as starting waits for the
+// tunnel's supervisor
+func (m tunnelsModel) act(start bool, rows []tunnelRow) tea.Cmd {
+	var todo []tunnelRow
+	var states []tunnel.State
+	for _, r := range rows {
+		st, running := m.state(r)
+		if start && !running {
+			todo = append(todo, r)
+		} else if !start && running {
+			states = append(states, st)
+		}
+	}
+	startFn, stopFn := m.start, m.stop
+
+	return func() tea.Msg {
+		var results []string
+		for _, r := range todo {
+			if err := startFn(r.item, r.forward); err != nil {
+				results = append(results, err.Error())
+			} else {
+				results = append(results, fmt.Sprintf("started %v on %v", r.forward.Label(), r.item.Name))
+			}
+		}
+		for _, st := range states {
+			if err := stopFn(st); err != nil {
+				results = append(results, err.Error())
+			} else {
+				results = append(results, fmt.Sprintf("stopped %v on %v", st.Forward, st.Connection))
+			}
+		}
+		if len(results) == 0 {
+			return tunnelActionMsg("nothing to do")
+		}
+		return tunnelActionMsg(strings.Join(results, "\n"))
+	}
+}
+
+func (m tunnelsModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c", "esc", "q":
+			return m, tea.Quit
+		case "up", "k":
+			m.cursor = max(0, m.cursor-1)
+		case "down", "j":
+			m.cursor = min(len(m.rows)-1, m.cursor+1)
+		}
+		if m.busy || len(m.rows) == 0 {
+			return m, nil
+		}
+		var cmd tea.Cmd
+		switch msg.String() {
+		case "s", "enter":
+			cmd = m.act(true, m.rows[m.cursor:m.cursor+1])
+		case "x":
+			cmd = m.act(false, m.rows[m.cursor:m.cursor+1])
+		case "S":
+			cmd = m.act(true, m.rows)
+		case "X":
+			cmd = m.act(false, m.rows)
+		}
+		if cmd != nil {
+			m.busy = true
+			m.message = "working..."
+		}
+		return m, cmd
+	case tunnelActionMsg:
+		m.busy = false
+		m.message = string(msg)
+		return m, m.refresh()
+	case tunnelTickMsg:
+		return m, tea.Batch(m.refresh(), tunnelTick())
+	case tunnelRefreshMsg:
+		if msg.err != nil {
+			m.message = msg.err.Error()
+			return m, nil
+		}
+		m.states = map[string]tunnel.State{}
+		for _, st := range msg.states {
+			m.states[tunnel.ID(st.Connection, st.Forward)] = st
+		}
+	}
+	return m, nil
+}
+
+func (m tunnelsModel) View() string {
+	cursorStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#06bf18")).Bold(true)
+	downStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#db0404"))
+
+	lines := []string{StyleTitle("Tunnels"), ""}
+	now := time.Now()
+	for ind, r := range m.rows {
+		spec := "invalid"
+		if args, err := tunnel.Args(r.forward); err == nil {
+			spec = strings.Join(args, " ")
+		}
+		status := "stopped"
+		if st, ok := m.state(r); ok {
+			status = tunnel.Describe(st, now)
+		}
+
+		line := fmt.Sprintf("%-20v %-15v %-35v %v", r.item.Name, r.forward.Label(), spec, status)
+		switch {
+		case ind == m.cursor:
+			line = cursorStyle.Render("> " + line)
+		case strings.HasPrefix(status, tunnel.StatusReconnecting):
+			line = downStyle.Render("  " + line)
+		default:
+			line = "  " + line
+		}
+		lines = append(lines, line)
+	}
+	if len(m.rows) == 0 {
+		lines = append(lines, "No forwards configured for the selected connections.")
+	}
+	lines = append(lines, "")
+	lines = append(lines, m.notes...)
+	if m.message != "" {
+		lines = append(lines, m.message)
+	}
+	lines = append(lines, "(s start, x stop, S start all, X stop all, esc quit; tunnels keep running after gossh exits)")
+
+	return globalStyle(strings.Join(lines, "\n") + "\n")
+}
+
+func newTunnelsModel(items []connection.Item) tunnelsModel {
+	m := tunnelsModel{
+		states: map[string]tunnel.State{},
+		start:  tunnel.Start,
+		stop:   tunnel.Stop,
+		list:   tunnel.List,
+	}
+	for _, item := range items {
+		if len(item.Conn.Forwards) == 0 {
+			m.notes = append(m.notes, fmt.Sprintf("%v has no forwards", item.Name))
+		}
+		for _, f := range item.Conn.Forwards {
+			m.rows = append(m.rows, tunnelRow{item: item, forward: f})
+		}
+	}
+	return m
+}
+
+// Tunnels shows the forwards of the connections with their status and starts
+// and stops them
+func Tunnels(items []connection.Item) error {
+	p := tea.NewProgram(newTunnelsModel(items), tea.WithAltScreen())
+	_, err := p.Run()
+	return err
+}
@@ -0,0 +1,73 @@
+package menus
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/tunnel"
+)
+
+func TestTunnelsModel(t *testing.T) {
+	bastion := connection.Item{Name: "bastion", Conn: connection.Connection{Forwards: []connection.Forward{
+		{Name: "db", Type: "local", Listen: "5432", Target: "db:5432"},
+		{Type: "dynamic", Listen: "1080"},
+	}}}
+	m := newTunnelsModel([]connection.Item{bastion, {Name: "web1"}})
+
+	var running []tunnel.State
+	m.list = func() ([]tunnel.State, error) { return running, nil }
+	m.start = func(i connection.Item, f connection.Forward) error {
+		if f.Type == "dynamic" {
+			return errors.New("port 1080 in use")
+		}
+		running = append(running, tunnel.State{Connection: i.Name, Forward: f.Label(), Status: tunnel.StatusUp, Since: time.Now()})
+		return nil
+	}
+	var stopped []string
+	m.stop = func(st tunnel.State) error {
+		stopped = append(stopped, st.Forward)
+		return nil
+	}
+
+	if len(m.rows) != 2 || !strings.Contains(m.View(), "web1 has no forwards") {
+		t.Fatalf("rows = %v", len(m.rows))
+	}
+
+	// run each command and feed its message back, like the program would
+	update := func(msg tea.Msg) {
+		for msg != nil {
+			model, cmd := m.Update(msg)
+			m = model.(tunnelsModel)
+			msg = nil
+			if cmd != nil {
+				msg = cmd()
+			}
+		}
+	}
+
+	update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("S")})
+	if !strings.Contains(m.message, "started db on bastion") || !strings.Contains(m.message, "port 1080 in use") {
+		t.Errorf("message = %q", m.message)
+	}
+	if st, ok := m.state(m.rows[0]); !ok || st.Status != tunnel.StatusUp {
+		t.Errorf("db not shown as running")
+	}
+	if !strings.Contains(m.View(), "up for") || !strings.Contains(m.View(), "-D 1080") {
+		t.Errorf("View() missing status:\n%v", m.View())
+	}
+
+	// stopping the stopped dynamic forward does nothing
+	update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("j")})
+	update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("x")})
+	if len(stopped) != 0 || m.message != "nothing to do" {
+		t.Errorf("stopped = %v, message = %q", stopped, m.message)
+	}
+	update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("X")})
+	if len(stopped) != 1 || stopped[0] != "db" {
+		t.Errorf("stopped = %v", stopped)
+	}
+}
@@ -0,0 +1,283 @@
+package rotate
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/encryption"
+	"github.com/nicknickel/gossh/internal/log"
+	"github.com/nicknickel/gossh/internal/runcommand"
+)
+
+const (
+	defaultLength  = 24
+	defaultCharset = "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz23456789-_.+=%@"
+)
+
+type Policy struct {
+	Length  int
+	Charset string
+}
+
+const (
+	StatusRotated    = "rotated"
+	StatusUnchanged  = "unchanged"
+	StatusRolledBack = "rolled back"
+	StatusManual     = "MANUAL ACTION REQUIRED"
+)
+
+type Result struct {
+	Host   string
+	Status string
+	Err    error
+	Detail string
+}
+
+// The remote steps are variables so tests can replace them
+var (
+	changePassword = remoteChangePassword
+	verifyLogin    = remoteVerifyLogin
+)
+
+func GetPolicy() Policy {
+	p := Policy{Length: defaultLength, Charset: defaultCharset}
+
+	lengthEnv := os.Getenv("GOSSH_ROTATE_LENGTH")
+	if lengthEnv != "" {
+		parsed, err := strconv.Atoi(lengthEnv)
+		if err == nil && parsed >= 8 {
+			p.Length = parsed
+		}
+	}
+
+	charsetEnv := os.Getenv("GOSSH_ROTATE_CHARSET")
+	if charsetEnv != "" && !strings.ContainsAny(charsetEnv, ": \t\n") {
+		p.Charset = charsetEnv
+	}
+
+	return p
+}
+
+func (p Policy) Generate() (string, error) {
+	if p.Length <= 0 || p.Charset == "" {
+		return "", errors.New("invalid password policy")
+	}
+
+	charset := []rune(p.Charset)
+	max := big.NewInt(int64(len(charset)))
+	var sb strings.Builder
+	for range p.Length {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteRune(charset[n.Int64()])
+	}
+
+	return sb.String(), nil
+}
+
+func RemoteUser(i connection.Item) string {
+	if i.Conn.User != "" {
+		return i.Conn.User
+	}
+	u, err := user.Current()
+	if err != nil {
+		return ""
+	}
+	return u.Username
+}
+
+// changeScript runs chpasswd with sudo when not root. The current password is
+// the first line of stdin and only sent to sudo when sudo asks for one, so a
+// NOPASSWD sudo never hands it to chpasswd.
+const changeScript = `sh -c 'IFS= read -r pw; if sudo -n true 2>/dev/null; then sudo -n chpasswd; ` +
+	`else { printf "%s\\n" "$pw"; cat; } | sudo -S -p "" chpasswd; fi'`
+
+// ChangeCommand returns the remote command and its stdin
+func ChangeCommand(remoteUser string, oldPw string, newPw string) ([]string, string) {
+	if remoteUser == "root" {
+		return []string{"chpasswd"}, fmt.Sprintf("%v:%v\n", remoteUser, newPw)
+	}
+	return []string{changeScript}, fmt.Sprintf("%v\n%v:%v\n", oldPw, remoteUser, newPw)
+}
+
+// remoteChangePassword authenticates with authPw when set, otherwise with the
+// connection's configured authentication
+func remoteChangePassword(i connection.Item, authPw string, oldPw string, newPw string) error {
+	remote, stdin := ChangeCommand(RemoteUser(i), oldPw, newPw)
+	c := append([]string{"ssh", "{{.FinalAddr}}"}, remote...)
+
+	var cmd *exec.Cmd
+	cleanup := func() {}
+	if authPw != "" {
+		c = append([]string{"sshpass", "-e"}, c...)
+		env := append(runcommand.GetEnv(), "SSHPASS="+authPw)
+		cmd = runcommand.CreateCommand(&c, &env, i)
+	} else {
+		var responders []*runcommand.Responder
+		var err error
+		cmd, responders, cleanup, err = runcommand.PrepareCommand(&i, c)
+		if err != nil {
+			cleanup()
+			return err
+		}
+		if len(responders) > 0 {
+			cleanup()
+			return errors.New("connections requiring totp or automation are not supported")
+		}
+	}
+	defer cleanup()
+
+	cmd.Stdin = strings.NewReader(stdin)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func remoteVerifyLogin(i connection.Item, pw string) error {
+	c := []string{"sshpass", "-e", "ssh", "-o", "PubkeyAuthentication=no", "-o", "PreferredAuthentications=password,keyboard-interactive", "{{.FinalAddr}}", "true"}
+	env := append(runcommand.GetEnv(), "SSHPASS="+pw)
+	cmd := runcommand.CreateCommand(&c, &env, i)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// RotateHost changes the password on the remote and only writes the new
+// encrypted passfile once a login with the new password succeeds
+func RotateHost(i connection.Item, policy Policy) Result {
+	r := Result{Host: i.WindowName(), Status: StatusUnchanged}
+
+	if i.Conn.PassFile == "" {
+		r.Err = errors.New("no passfile configured")
+		return r
+	}
+	// the new password is encrypted to the same passphrase or recipients
+	recipients, err := encryption.RecipientsOf(i.Conn.PassFile)
+	if err != nil {
+		r.Err = fmt.Errorf("cannot encrypt the new password like the old one: %w", err)
+		return r
+	}
+	oldPw := strings.TrimSpace(encryption.GetEncryptedContents(i.Conn.PassFile))
+	if oldPw == "" {
+		r.Err = fmt.Errorf("could not decrypt %v", i.Conn.PassFile)
+		return r
+	}
+	newPw, err := policy.Generate()
+	if err != nil {
+		r.Err = err
+		return r
+	}
+
+	if err := changePassword(i, "", oldPw, newPw); err != nil {
+		r.Err = fmt.Errorf("password change failed: %w", err)
+		// chpasswd may have applied it before failing, then the new password
+		// is the only one that works and must be kept
+		if verifyLogin(i, newPw) != nil {
+			return r
+		}
+		if err := encryption.Encrypt([]byte(newPw), recipients, i.Conn.PassFile); err != nil {
+			r.Status = StatusManual
+			r.Detail = saveNewPassword(i.Conn.PassFile+".new", newPw, recipients)
+			return r
+		}
+		r.Status = StatusRotated
+		r.Detail = "the change reported an error but the new password works and was saved"
+		return r
+	}
+
+	if err := verifyLogin(i, newPw); err != nil {
+		r.Err = fmt.Errorf("login with new password failed: %w", err)
+		// the change may or may not have applied so try putting the old one back
+		if rbErr := changePassword(i, newPw, newPw, oldPw); rbErr == nil {
+			r.Status = StatusRolledBack
+			return r
+		}
+		if verifyLogin(i, oldPw) == nil {
+			r.Status = StatusUnchanged
+			return r
+		}
+		r.Status = StatusManual
+		r.Detail = saveNewPassword(i.Conn.PassFile+".new", newPw, recipients)
+		return r
+	}
+
+	if err := encryption.Encrypt([]byte(newPw), recipients, i.Conn.PassFile); err != nil {
+		r.Err = fmt.Errorf("could not write %v: %w", i.Conn.PassFile, err)
+		if rbErr := changePassword(i, newPw, newPw, oldPw); rbErr == nil {
+			r.Status = StatusRolledBack
+			return r
+		}
+		r.Status = StatusManual
+		r.Detail = saveNewPassword(i.Conn.PassFile+".new", newPw, recipients)
+		return r
+	}
+
+	r.Status = StatusRotated
+	return r
+}
+
+// saveNewPassword keeps the new password encrypted next to the passfile when
+// the remote state is unknown so it is never lost
+func saveNewPassword(file string, pw string, recipients []age.Recipient) string {
+	if err := encryption.Encrypt([]byte(pw), recipients, file); err != nil {
+		return fmt.Sprintf("new password could not be saved: %v", err)
+	}
+	return fmt.Sprintf("remote password may have changed, new password saved encrypted to %v", file)
+}
+
+func RotateHosts(items []connection.Item, policy Policy) []Result {
+	results := make([]Result, len(items))
+
+	var wg sync.WaitGroup
+	limiter := make(chan int, runcommand.GetConcurrency())
+	for ind, item := range items {
+		wg.Go(func() {
+			limiter <- 1
+			results[ind] = RotateHost(item, policy)
+			log.Logger.Info("Password rotation", "host", results[ind].Host, "status", results[ind].Status, "err", results[ind].Err)
+			<-limiter
+		})
+	}
+	wg.Wait()
+
+	return results
+}
+
+func Report(results []Result) string {
+	var sb strings.Builder
+	failed := 0
+
+	for _, r := range results {
+		line := fmt.Sprintf("%v: %v", r.Host, r.Status)
+		if r.Err != nil {
+			line += fmt.Sprintf(" (%v)", r.Err)
+		}
+		if r.Detail != "" {
+			line += "\n\t" + r.Detail
+		}
+		sb.WriteString(line + "\n")
+		if r.Status != StatusRotated {
+			failed++
+		}
+	}
+	sb.WriteString(fmt.Sprintf("\n%v of %v passwords rotated\n", len(results)-failed, len(results)))
+
+	return sb.String()
+}
@@ -0,0 +1,215 @@
+package rotate
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/charmbracelet/log"
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/encryption"
+	internal_log "github.com/nicknickel/gossh/internal/log"
+)
+
+func TestGetPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		length  string
+		charset string
+		want    Policy
+	}{
+		{name: "defaults", want: Policy{Length: defaultLength, Charset: defaultCharset}},
+		{name: "custom", length: "32", charset: "abc123", want: Policy{Length: 32, Charset: "abc123"}},
+		{name: "too short", length: "4", want: Policy{Length: defaultLength, Charset: defaultCharset}},
+		{name: "charset with colon", charset: "ab:c", want: Policy{Length: defaultLength, Charset: defaultCharset}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GOSSH_ROTATE_LENGTH", tt.length)
+			t.Setenv("GOSSH_ROTATE_CHARSET", tt.charset)
+			if got := GetPolicy(); got != tt.want {
+				t.Errorf("GetPolicy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicy_Generate(t *testing.T) {
+	p := Policy{Length: 40, Charset: "ab"}
+	pw, err := p.Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if len(pw) != 40 || strings.Trim(pw, "ab") != "" {
+		t.Errorf("Generate() = %v, want 40 chars of [ab]", pw)
+	}
+
+	if _, err := (Policy{Length: 10}).Generate(); err == nil {
+		t.Errorf("Generate() with empty charset expected error")
+	}
+}
+
+func TestChangeCommand(t *testing.T) {
+	cmd, stdin := ChangeCommand("root", "old", "new")
+	if strings.Join(cmd, " ") != "chpasswd" || stdin != "root:new\n" {
+		t.Errorf("ChangeCommand(root) = %v %q", cmd, stdin)
+	}
+
+	// run the script with a fake sudo, which only asks for the password
+	// without NOPASSWD, and a chpasswd recording its input
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "sudo"), []byte(`#!/bin/sh
+if [ "$1" = "-n" ]; then
+	[ -n "$NOPASSWD" ] || exit 1
+	shift
+	exec "$@"
+fi
+IFS= read -r pw
+[ "$pw" = old ] || exit 1
+shift 3
+exec "$@"
+`), 0700)
+	os.WriteFile(filepath.Join(dir, "chpasswd"), []byte("#!/bin/sh\ncat > \"$OUT\"\n"), 0700)
+	t.Setenv("PATH", dir+":"+os.Getenv("PATH"))
+
+	for _, nopasswd := range []string{"", "1"} {
+		t.Setenv("NOPASSWD", nopasswd)
+		out := filepath.Join(dir, "out"+nopasswd)
+		t.Setenv("OUT", out)
+		cmd, stdin = ChangeCommand("opc", "old", "new")
+		c := exec.Command("sh", "-c", strings.Join(cmd, " "))
+		c.Stdin = strings.NewReader(stdin)
+		if b, err := c.CombinedOutput(); err != nil {
+			t.Errorf("ChangeCommand(opc) with NOPASSWD=%q error = %v: %s", nopasswd, err, b)
+		}
+		if got, _ := os.ReadFile(out); string(got) != "opc:new\n" {
+			t.Errorf("ChangeCommand(opc) with NOPASSWD=%q sent %q to chpasswd", nopasswd, got)
+		}
+	}
+}
+
+func TestRotateHost(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	t.Setenv("GOSSH_PASSPHRASE", "testpass")
+	scrypt, _ := age.NewScryptIdentity("testpass")
+	recipients, _ := encryption.PassphraseRecipient("testpass")
+	policy := Policy{Length: 16, Charset: "xyz"}
+
+	tests := []struct {
+		name          string
+		changeErr     error
+		rollbackErr   error
+		verifyErr     error
+		wantStatus    string
+		wantPassfile  string // "old" or "new"
+		wantSavedFile bool
+	}{
+		{name: "success", wantStatus: StatusRotated, wantPassfile: "new"},
+		{name: "change rejected", changeErr: errors.New("denied"), verifyErr: errors.New("bad login"), wantStatus: StatusUnchanged, wantPassfile: "old"},
+		{name: "change fails after applying", changeErr: errors.New("exit status 1"), wantStatus: StatusRotated, wantPassfile: "new"},
+		{name: "verify fails and rolls back", verifyErr: errors.New("bad login"), wantStatus: StatusRolledBack, wantPassfile: "old"},
+		{name: "verify and rollback fail", verifyErr: errors.New("bad login"), rollbackErr: errors.New("denied"), wantStatus: StatusManual, wantPassfile: "old", wantSavedFile: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			passfile := t.TempDir() + "/host.pass.age"
+			if err := encryption.Encrypt([]byte("oldpassword\n"), recipients, passfile); err != nil {
+				t.Fatalf("Encrypt() error = %v", err)
+			}
+
+			changePassword = func(i connection.Item, authPw string, oldPw string, newPw string) error {
+				if authPw != "" {
+					return tt.rollbackErr
+				}
+				return tt.changeErr
+			}
+			verifyLogin = func(i connection.Item, pw string) error {
+				if pw == "oldpassword" {
+					return errors.New("old password no longer valid")
+				}
+				return tt.verifyErr
+			}
+			defer func() {
+				changePassword = remoteChangePassword
+				verifyLogin = remoteVerifyLogin
+			}()
+
+			item := connection.Item{Name: "host", Conn: connection.Connection{User: "root", PassFile: passfile}}
+			r := RotateHost(item, policy)
+			if r.Status != tt.wantStatus {
+				t.Errorf("RotateHost() status = %v (err %v), want %v", r.Status, r.Err, tt.wantStatus)
+			}
+
+			got, err := encryption.Decrypt(passfile, []age.Identity{scrypt})
+			if err != nil {
+				t.Fatalf("Decrypt() error = %v", err)
+			}
+			isOld := string(got) == "oldpassword\n"
+			if (tt.wantPassfile == "old") != isOld {
+				t.Errorf("passfile contents = %q, want %v password", got, tt.wantPassfile)
+			}
+
+			_, err = os.Stat(passfile + ".new")
+			if (err == nil) != tt.wantSavedFile {
+				t.Errorf("saved new password file exists = %v, want %v", err == nil, tt.wantSavedFile)
+			}
+		})
+	}
+}
+
+func TestRotateHost_Recipients(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	dir := t.TempDir()
+	identity, _ := age.GenerateX25519Identity()
+	idFile := filepath.Join(dir, "key.txt")
+	os.WriteFile(idFile, []byte(identity.String()+"\n"), 0600)
+	t.Setenv("GOSSH_AGE_IDENTITY", idFile)
+	t.Setenv("GOSSH_PASSPHRASE", "unrelated")
+
+	passfile := filepath.Join(dir, "host.pass.age")
+	encryption.Encrypt([]byte("oldpassword\n"), []age.Recipient{identity.Recipient()}, passfile)
+
+	changePassword = func(i connection.Item, authPw string, oldPw string, newPw string) error { return nil }
+	verifyLogin = func(i connection.Item, pw string) error { return nil }
+	defer func() {
+		changePassword = remoteChangePassword
+		verifyLogin = remoteVerifyLogin
+	}()
+
+	item := connection.Item{Name: "host", Conn: connection.Connection{User: "root", PassFile: passfile}}
+	if r := RotateHost(item, Policy{Length: 16, Charset: "xyz"}); r.Status != StatusRotated {
+		t.Fatalf("RotateHost() status = %v (err %v)", r.Status, r.Err)
+	}
+	got, err := encryption.Decrypt(passfile, []age.Identity{identity})
+	if err != nil || len(got) != 16 {
+		t.Errorf("passfile not encrypted to the age identity: %q, %v", got, err)
+	}
+
+	// a second recipient without an identity would be dropped
+	other, _ := age.GenerateX25519Identity()
+	encryption.Encrypt([]byte("oldpassword\n"), []age.Recipient{identity.Recipient(), other.Recipient()}, passfile)
+	if r := RotateHost(item, Policy{Length: 16, Charset: "xyz"}); r.Status != StatusUnchanged || r.Err == nil {
+		t.Errorf("RotateHost() with unknown recipient = %v (err %v)", r.Status, r.Err)
+	}
+}
+
+func TestReport(t *testing.T) {
+	results := []Result{
+		{Host: "a", Status: StatusRotated},
+		{Host: "b", Status: StatusRolledBack, Err: errors.New("bad login")},
+	}
+
+	got := Report(results)
+	for _, want := range []string{"a: rotated", "b: rolled back (bad login)", "1 of 2 passwords rotated"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Report() missing %q:\n%v", want, got)
+		}
+	}
+}
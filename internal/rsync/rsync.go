@@ -0,0 +1,222 @@
+package rsync
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/log"
+	"github.com/nicknickel/gossh/internal/runcommand"
+)
+
+type Direction int
+
+const (
+	Push Direction = iota
+	Pull
+)
+
+type Options struct {
+	Direction Direction
+	Local     string
+	Remote    string
+	Delete    bool
+	DryRun    bool
+	Include   []string
+	Exclude   []string
+	BwLimit   string
+}
+
+type Result struct {
+	Host    string
+	Dest    string
+	Changes []string
+	Err     error
+}
+
+var (
+	bwLimitPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[KMGkmg]?$`)
+	// itemized lines look like ">f+++++++++ path" or "*deleting   path"
+	changePattern = regexp.MustCompile(`^([<>ch.][fdLDS][^ ]{9}|\*deleting) +.+$`)
+)
+
+// SplitPatterns turns a comma separated list of patterns into a slice
+func SplitPatterns(s string) []string {
+	var patterns []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+func (o Options) Validate() error {
+	if o.Local == "" || o.Remote == "" {
+		return errors.New("local and remote paths are required")
+	}
+	if o.BwLimit != "" && !bwLimitPattern.MatchString(o.BwLimit) {
+		return fmt.Errorf("invalid bandwidth limit %q, use a rate like 500 (KiB/s), 2M or 1.5G", o.BwLimit)
+	}
+	return nil
+}
+
+// Args returns the rsync options. Changes are always itemized so they can be
+// reported, and includes come before excludes so they take precedence.
+func (o Options) Args() []string {
+	args := []string{"-az", "--itemize-changes"}
+	if o.Delete {
+		args = append(args, "--delete")
+	}
+	if o.DryRun {
+		args = append(args, "--dry-run")
+	}
+	for _, p := range o.Include {
+		args = append(args, "--include="+p)
+	}
+	for _, p := range o.Exclude {
+		args = append(args, "--exclude="+p)
+	}
+	if o.BwLimit != "" {
+		args = append(args, "--bwlimit="+o.BwLimit)
+	}
+	return args
+}
+
+// Paths returns the rsync source and destination for the connection. Pulls
+// from several hosts land in separate <local>/<name>_<connection> directories
+// like received files do.
+func (o Options) Paths(i connection.Item) (string, string) {
+	remote := i.FinalAddr() + ":" + o.Remote
+	if o.Direction == Push {
+		return o.Local, remote
+	}
+
+	dest := path.Clean(path.Join(o.Local, path.Base(o.Remote))) + "_" + i.CleanTitle()
+	return strings.TrimSuffix(remote, "/") + "/", dest + "/"
+}
+
+// rshQuote joins the ssh command for rsync's -e option, which splits on
+// spaces and honours single and double quotes
+func rshQuote(args []string) string {
+	quoted := make([]string, len(args))
+	for ind, a := range args {
+		switch {
+		case a != "" && !strings.ContainsAny(a, ` '"`):
+			quoted[ind] = a
+		case !strings.Contains(a, "'"):
+			quoted[ind] = "'" + a + "'"
+		default:
+			quoted[ind] = `"` + a + `"`
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// Command builds the rsync command using the connection's ssh authentication
+// (sshpass, identity or agent) as the remote shell. The returned cleanup
+// function must be called once the command has finished.
+func Command(i connection.Item, o Options) (*exec.Cmd, []*runcommand.Responder, func(), error) {
+	// automation is for interactive sessions, rsync only needs the login
+	i.Conn.Automation = nil
+	ssh, responders, cleanup, err := runcommand.PrepareCommand(&i, []string{"ssh"})
+	if err != nil {
+		return nil, nil, cleanup, err
+	}
+
+	src, dest := o.Paths(i)
+	args := append(o.Args(), "-e", rshQuote(ssh.Args), src, dest)
+	cmd := exec.Command("rsync", args...)
+	// rsync runs the remote shell itself so it needs PATH and HOME to find
+	// and configure sshpass and ssh, along with the authentication
+	cmd.Env = append(os.Environ(), ssh.Env...)
+
+	return cmd, responders, cleanup, nil
+}
+
+// ParseChanges returns the itemized changes from the rsync output
+func ParseChanges(out string) []string {
+	var changes []string
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if changePattern.MatchString(line) {
+			changes = append(changes, line)
+		}
+	}
+	return changes
+}
+
+func SyncHost(i connection.Item, o Options) Result {
+	_, dest := o.Paths(i)
+	r := Result{Host: i.WindowName(), Dest: dest}
+	cmd, responders, cleanup, err := Command(i, o)
+	defer cleanup()
+	if err != nil {
+		r.Err = err
+		return r
+	}
+
+	var out string
+	if len(responders) > 0 {
+		out, err = runcommand.PtyCommandOutput(cmd, responders)
+	} else {
+		var b []byte
+		b, err = cmd.CombinedOutput()
+		out = string(b)
+	}
+
+	r.Changes = ParseChanges(out)
+	if err != nil {
+		r.Err = fmt.Errorf("%w: %s", err, strings.TrimSpace(out))
+	}
+	return r
+}
+
+func SyncHosts(items []connection.Item, o Options) []Result {
+	results := make([]Result, len(items))
+
+	var wg sync.WaitGroup
+	limiter := make(chan int, runcommand.GetConcurrency())
+	for ind, item := range items {
+		wg.Go(func() {
+			limiter <- 1
+			results[ind] = SyncHost(item, o)
+			log.Logger.Info("Sync", "host", results[ind].Host, "changes", len(results[ind].Changes), "dryrun", o.DryRun, "err", results[ind].Err)
+			<-limiter
+		})
+	}
+	wg.Wait()
+
+	return results
+}
+
+// Report lists the changes made (or that would be made on a dry run) per host
+func Report(results []Result, dryRun bool) string {
+	var sb strings.Builder
+	failed := 0
+	verb := "changed"
+	if dryRun {
+		verb = "would change"
+	}
+
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+			sb.WriteString(fmt.Sprintf("%v: failed (%v)\n", r.Host, r.Err))
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("%v: %v %v in %v\n", r.Host, len(r.Changes), verb, r.Dest))
+		for _, c := range r.Changes {
+			sb.WriteString("\t" + c + "\n")
+		}
+	}
+	sb.WriteString(fmt.Sprintf("\n%v of %v hosts synced\n", len(results)-failed, len(results)))
+
+	return sb.String()
+}
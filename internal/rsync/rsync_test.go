@@ -0,0 +1,151 @@
+package rsync
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/log"
+	"github.com/nicknickel/gossh/internal/connection"
+	internal_log "github.com/nicknickel/gossh/internal/log"
+)
+
+// writeFakeProgram puts a script named program on the PATH
+func writeFakeProgram(t *testing.T, dir string, program string, script string) {
+	f := filepath.Join(dir, program)
+	if err := os.WriteFile(f, []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatalf("Failed to write fake %v: %v", program, err)
+	}
+}
+
+func TestArgs(t *testing.T) {
+	o := Options{
+		Delete:  true,
+		DryRun:  true,
+		Include: []string{"keep.log"},
+		Exclude: []string{"*.log", ".git/"},
+		BwLimit: "2M",
+	}
+	expected := []string{"-az", "--itemize-changes", "--delete", "--dry-run", "--include=keep.log", "--exclude=*.log", "--exclude=.git/", "--bwlimit=2M"}
+	if got := o.Args(); !slices.Equal(got, expected) {
+		t.Errorf("Args() = %v, want %v", got, expected)
+	}
+
+	if got := (Options{}).Args(); !slices.Equal(got, []string{"-az", "--itemize-changes"}) {
+		t.Errorf("Args() with no options = %v", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      Options
+		expectErr bool
+	}{
+		{name: "valid", opts: Options{Local: "a", Remote: "b"}},
+		{name: "bwlimit", opts: Options{Local: "a", Remote: "b", BwLimit: "1.5M"}},
+		{name: "missing remote", opts: Options{Local: "a"}, expectErr: true},
+		{name: "bad bwlimit", opts: Options{Local: "a", Remote: "b", BwLimit: "fast"}, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.Validate(); (err != nil) != tt.expectErr {
+				t.Errorf("Validate() error = %v, expectErr %v", err, tt.expectErr)
+			}
+		})
+	}
+}
+
+func TestSplitPatterns(t *testing.T) {
+	got := SplitPatterns(" *.log, .git/ ,,tmp ")
+	if !slices.Equal(got, []string{"*.log", ".git/", "tmp"}) {
+		t.Errorf("SplitPatterns() = %v", got)
+	}
+	if got := SplitPatterns(""); got != nil {
+		t.Errorf("SplitPatterns(\"\") = %v, want nil", got)
+	}
+}
+
+func TestPaths(t *testing.T) {
+	item := connection.Item{Name: "web 1", Conn: connection.Connection{Address: "10.0.0.1", User: "deploy"}}
+
+	src, dest := Options{Direction: Push, Local: "site/", Remote: "/var/www"}.Paths(item)
+	if src != "site/" || dest != "deploy@10.0.0.1:/var/www" {
+		t.Errorf("push Paths() = %v, %v", src, dest)
+	}
+
+	src, dest = Options{Direction: Pull, Local: "backups", Remote: "/etc/nginx/"}.Paths(item)
+	if src != "deploy@10.0.0.1:/etc/nginx/" || dest != "backups/nginx_"+item.CleanTitle()+"/" {
+		t.Errorf("pull Paths() = %v, %v", src, dest)
+	}
+}
+
+func TestRshQuote(t *testing.T) {
+	got := rshQuote([]string{"ssh", "-i", "/keys/my key", "it's", ""})
+	expected := `ssh -i '/keys/my key' "it's" ''`
+	if got != expected {
+		t.Errorf("rshQuote() = %v, want %v", got, expected)
+	}
+}
+
+func TestParseChanges(t *testing.T) {
+	out := "sending incremental file list\n" +
+		">f+++++++++ new.txt\r\n" +
+		"cd+++++++++ dir/\n" +
+		".d..t...... ./\n" +
+		"*deleting   old.txt\n" +
+		"\nsent 100 bytes  received 20 bytes\n"
+	expected := []string{">f+++++++++ new.txt", "cd+++++++++ dir/", ".d..t...... ./", "*deleting   old.txt"}
+	if got := ParseChanges(out); !slices.Equal(got, expected) {
+		t.Errorf("ParseChanges() = %q, want %q", got, expected)
+	}
+}
+
+func TestSyncHosts(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	dir := t.TempDir()
+	writeFakeProgram(t, dir, "sshpass", "exit 0")
+	writeFakeProgram(t, dir, "rsync", `for a in "$@"; do echo "arg: $a"; done
+echo "env: $SSHPASS"
+case "$*" in *fail*) echo "connection refused"; exit 12;; esac
+echo ">f+++++++++ file.txt"
+echo "*deleting   stale.txt"`)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	items := []connection.Item{
+		{Name: "web1", Conn: connection.Connection{Password: "exec:echo hunter2"}},
+		{Name: "fail", Conn: connection.Connection{}},
+	}
+	opts := Options{Direction: Push, Local: "site/", Remote: "/var/www", Delete: true, DryRun: true}
+
+	cmd, _, cleanup, err := Command(items[0], opts)
+	cleanup()
+	if err != nil {
+		t.Fatalf("Command() err = %v", err)
+	}
+	args := strings.Join(cmd.Args, "|")
+	if !strings.Contains(args, "|-e|sshpass -e ssh|site/|web1:/var/www") || !slices.Contains(cmd.Env, "SSHPASS=hunter2") ||
+		!slices.Contains(cmd.Env, "PATH="+os.Getenv("PATH")) || !slices.Contains(cmd.Env, "HOME="+os.Getenv("HOME")) {
+		t.Errorf("Command() args = %v, env = %v", cmd.Args, cmd.Env)
+	}
+
+	results := SyncHosts(items, opts)
+	if results[0].Err != nil || !slices.Equal(results[0].Changes, []string{">f+++++++++ file.txt", "*deleting   stale.txt"}) {
+		t.Errorf("web1 result = %+v", results[0])
+	}
+	if results[1].Err == nil || !strings.Contains(results[1].Err.Error(), "connection refused") {
+		t.Errorf("fail result err = %v", results[1].Err)
+	}
+
+	report := Report(results, true)
+	for _, s := range []string{"web1: 2 would change in web1:/var/www", "\t*deleting   stale.txt", "fail: failed", "1 of 2 hosts synced"} {
+		if !strings.Contains(report, s) {
+			t.Errorf("Report() missing %q:\n%v", s, report)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package runcommand
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/encryption"
+	"github.com/nicknickel/gossh/internal/log"
+)
+
+func getStepSecret(s string) (string, error) {
+	if encryption.IsSecretRef(s) {
+		return encryption.GetSecretPassword(s)
+	}
+
+	secret := strings.TrimSpace(encryption.GetEncryptedContents(s))
+	if secret == "" {
+		return "", fmt.Errorf("could not decrypt %v", s)
+	}
+	return secret, nil
+}
+
+// GetAutomationResponders turns the connection automation steps into
+// responders that must answer in order. An invalid step stops the automation
+// there so later steps never run out of sequence.
+func GetAutomationResponders(i *connection.Item) []*Responder {
+	var responders []*Responder
+	var previous *Responder
+
+	for ind, step := range i.Conn.Automation {
+		prompt, err := regexp.Compile(step.Expect)
+		if err != nil || step.Expect == "" {
+			log.Logger.Error("Invalid automation expect, skipping remaining steps", "connection", i.Name, "step", ind+1, "err", err)
+			break
+		}
+
+		r := &Responder{
+			Prompt: prompt,
+			After:  previous,
+			Answer: func() (string, error) {
+				if step.SendSecret != "" {
+					return getStepSecret(step.SendSecret)
+				}
+				return step.Send, nil
+			},
+		}
+		responders = append(responders, r)
+		previous = r
+	}
+
+	return responders
+}
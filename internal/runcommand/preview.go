@@ -0,0 +1,105 @@
+package runcommand
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/encryption"
+	"github.com/nicknickel/gossh/internal/hostkey"
+)
+
+// Preview is the command PrepareCommand would build and how it would
+// authenticate, worked out without decrypting secrets or contacting the host.
+// Fallback is the command it builds when the secrets can not be decrypted,
+// and Err why the connection is refused.
+type Preview struct {
+	Args     []string
+	Fallback []string
+	Notes    []string
+	Err      string
+}
+
+func (p Preview) Command() string {
+	return strings.Join(p.Args, " ")
+}
+
+// isEncryptedFile reports whether the file is a secret reference or age
+// encrypted, which gossh decrypts itself
+func isEncryptedFile(file string) bool {
+	if encryption.IsSecretRef(file) {
+		return true
+	}
+	encrypted, _ := encryption.IsEncrypted(file)
+	return encrypted
+}
+
+var errDryRunFailing = errors.New("decrypting fails in this dry run")
+
+// dryRun describes the secrets without decrypting them. Steps that decrypt
+// set fallible, and fail when failing is set, like PrepareCommand does when
+// the secrets can not be decrypted.
+type dryRun struct {
+	failing  bool
+	fallible bool
+}
+
+func (d *dryRun) sshOptions(i connection.Item) (login, error) {
+	opts, err := hostkey.PreviewSshOptions(i)
+	if opts == nil {
+		return login{}, err
+	}
+	return login{args: opts, note: "host key checked against " + hostkey.File()}, nil
+}
+
+func (d *dryRun) password(i *connection.Item) (login, error) {
+	if i.Conn.Password == "" && i.Conn.PassFile == "" {
+		return login{}, errors.New("no password")
+	}
+	if _, err := exec.LookPath("sshpass"); err != nil {
+		return login{note: "sshpass not found, the password is not sent"}, err
+	}
+	if i.Conn.Password != "" || isEncryptedFile(i.Conn.PassFile) {
+		d.fallible = true
+		if d.failing {
+			if i.Conn.Password != "" {
+				return login{}, errDryRunFailing
+			}
+			// the encrypted file itself is sent when it can not be decrypted
+			return login{args: []string{"sshpass", "-f", "{{.Conn.PassFile}}"}}, nil
+		}
+		return login{args: []string{"sshpass", "-e"}, note: "password decrypted into SSHPASS"}, nil
+	}
+	return login{args: []string{"sshpass", "-f", "{{.Conn.PassFile}}"}}, nil
+}
+
+func (d *dryRun) identity(i *connection.Item) (login, error) {
+	if i.Conn.IdentityFile == "" {
+		return login{}, errors.New("no identity")
+	}
+	if isEncryptedFile(i.Conn.IdentityFile) {
+		d.fallible = true
+		if !d.failing {
+			return login{note: "identity decrypted into ssh-agent"}, nil
+		}
+	}
+	return login{args: []string{"-i", i.Conn.IdentityFile}}, nil
+}
+
+// DryRunCommand is PrepareCommand for the command c without decrypting
+// secrets or contacting the host
+func DryRunCommand(i connection.Item, c []string) Preview {
+	d := &dryRun{}
+	p, err := prepare(&i, c, d)
+	if err != nil {
+		return Preview{Notes: p.notes, Err: err.Error()}
+	}
+	preview := Preview{Args: RenderTemplateSlice(&p.args, i), Notes: p.notes}
+	if d.fallible {
+		d.failing = true
+		f, _ := prepare(&i, c, d)
+		preview.Fallback = RenderTemplateSlice(&f.args, i)
+	}
+	return preview
+}
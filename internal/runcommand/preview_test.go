@@ -0,0 +1,102 @@
+package runcommand
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/log"
+	"github.com/nicknickel/gossh/internal/connection"
+	internal_log "github.com/nicknickel/gossh/internal/log"
+)
+
+func TestDryRunCommand_RefusedPin(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	t.Setenv("GOSSH_KNOWN_HOSTS", filepath.Join(t.TempDir(), "known hosts"))
+
+	item := connection.Item{Name: "web1", Conn: connection.Connection{HostKey: "SHA256:abc"}}
+	p := DryRunCommand(item, []string{"ssh", "{{.FinalAddr}}"})
+	if p.Args != nil || !strings.Contains(p.Err, "whitespace") {
+		t.Errorf("DryRunCommand() = %q err %q, want the pin refused", p.Args, p.Err)
+	}
+}
+
+func TestDryRunCommand(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	t.Setenv("GOSSH_PASSPHRASE", "")
+	t.Setenv("GOSSH_AGE_IDENTITY", "")
+	dir := t.TempDir()
+	knownHosts := filepath.Join(dir, "known_hosts")
+	t.Setenv("GOSSH_KNOWN_HOSTS", knownHosts)
+	bin := filepath.Join(dir, "bin")
+	os.Mkdir(bin, 0700)
+	os.WriteFile(filepath.Join(bin, "sshpass"), []byte("#!/bin/sh\n"), 0700)
+	t.Setenv("PATH", bin)
+
+	plain := filepath.Join(dir, "plain")
+	os.WriteFile(plain, []byte("secret\n"), 0600)
+	encrypted := filepath.Join(dir, "encrypted")
+	os.WriteFile(encrypted, []byte("age-encryption.org/v1\n-> X25519 abc\n"), 0600)
+
+	tests := []struct {
+		name     string
+		conn     connection.Connection
+		expected []string
+		fallback []string
+		notes    int
+	}{
+		{name: "plain", conn: connection.Connection{User: "ops"}, expected: []string{"ssh", "ops@web1"}},
+		{name: "identity", conn: connection.Connection{IdentityFile: plain}, expected: []string{"ssh", "-i", plain, "web1"}},
+		{name: "encrypted identity", conn: connection.Connection{IdentityFile: encrypted}, expected: []string{"ssh", "web1"},
+			fallback: []string{"ssh", "-i", encrypted, "web1"}, notes: 1},
+		{name: "secret identity", conn: connection.Connection{IdentityFile: "pass:infra/key"}, expected: []string{"ssh", "web1"},
+			fallback: []string{"ssh", "-i", "pass:infra/key", "web1"}, notes: 1},
+		{name: "passfile", conn: connection.Connection{PassFile: plain}, expected: []string{"sshpass", "-f", plain, "ssh", "web1"}},
+		{name: "encrypted passfile", conn: connection.Connection{PassFile: encrypted}, expected: []string{"sshpass", "-e", "ssh", "web1"},
+			fallback: []string{"sshpass", "-f", encrypted, "ssh", "web1"}, notes: 1},
+		{name: "password ref", conn: connection.Connection{Password: "pass:web1", IdentityFile: plain}, expected: []string{"sshpass", "-e", "ssh", "web1"},
+			fallback: []string{"ssh", "-i", plain, "web1"}, notes: 1},
+		{name: "totp", conn: connection.Connection{PassFile: encrypted, Totp: encrypted, IdentityFile: plain}, expected: []string{"ssh", "-i", plain, "web1"}, notes: 1},
+		{name: "pinned", conn: connection.Connection{HostKey: "SHA256:abc"},
+			expected: []string{"ssh", "-o", "UserKnownHostsFile=" + knownHosts, "-o", "StrictHostKeyChecking=yes", "-o", "HostKeyAlias=web1", "web1"}, notes: 1},
+		{name: "automation", conn: connection.Connection{Automation: []connection.AutomationStep{{Expect: "$", Send: "ls"}}},
+			expected: []string{"ssh", "web1"}, notes: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := connection.Item{Name: "web1", Conn: tt.conn}
+			c := []string{"ssh", "{{.FinalAddr}}"}
+			p := DryRunCommand(item, c)
+			if !slices.Equal(p.Args, tt.expected) || !slices.Equal(p.Fallback, tt.fallback) || len(p.Notes) != tt.notes {
+				t.Errorf("DryRunCommand() = %q fallback %q %q, want %q fallback %q with %v notes", p.Args, p.Fallback, p.Notes, tt.expected, tt.fallback, tt.notes)
+			}
+			if tt.conn.HostKey != "" {
+				return
+			}
+
+			// without a passphrase nothing decrypts, so PrepareCommand falls back
+			cmd, _, cleanup, err := PrepareCommand(&item, c)
+			if err != nil {
+				t.Fatalf("PrepareCommand() err = %v", err)
+			}
+			cleanup()
+			expected := tt.expected
+			if tt.fallback != nil {
+				expected = tt.fallback
+			}
+			if !slices.Equal(cmd.Args, expected) {
+				t.Errorf("PrepareCommand() = %q, dry run %q", cmd.Args, expected)
+			}
+		})
+	}
+
+	t.Setenv("PATH", dir)
+	p := DryRunCommand(connection.Item{Name: "web1", Conn: connection.Connection{PassFile: plain, IdentityFile: plain}}, []string{"ssh", "{{.FinalAddr}}"})
+	if !slices.Equal(p.Args, []string{"ssh", "-i", plain, "web1"}) || len(p.Notes) != 1 {
+		t.Errorf("DryRunCommand() without sshpass = %q %q", p.Args, p.Notes)
+	}
+}
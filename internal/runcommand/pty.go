@@ -0,0 +1,226 @@
+package runcommand
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/creack/pty"
+	"github.com/muesli/cancelreader"
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/encryption"
+	"github.com/nicknickel/gossh/internal/log"
+	"github.com/nicknickel/gossh/internal/totp"
+	"golang.org/x/term"
+)
+
+const (
+	defaultPasswordPrompt = `(?i)password[^\n]*:\s*$`
+	defaultTotpPrompt     = `(?i)(verification code|one[- ]time|otp|token|2fa|authenticator)[^\n]*:\s*$`
+	promptBufferSize      = 1024
+)
+
+// Responder answers a prompt seen on the pty with the result of Answer
+type Responder struct {
+	Prompt   *regexp.Regexp
+	Answer   func() (string, error)
+	After    *Responder // only active once this responder has answered
+	answered bool
+}
+
+func getPromptRegexp(env string, def string) *regexp.Regexp {
+	prompt := os.Getenv(env)
+	if prompt != "" {
+		r, err := regexp.Compile(prompt)
+		if err == nil {
+			return r
+		}
+		log.Logger.Warn("Invalid prompt regex, using default", "env", env, "err", err)
+	}
+	return regexp.MustCompile(def)
+}
+
+func PasswordPrompt() *regexp.Regexp {
+	return getPromptRegexp("GOSSH_PASSWORD_PROMPT", defaultPasswordPrompt)
+}
+
+func TotpPrompt() *regexp.Regexp {
+	return getPromptRegexp("GOSSH_TOTP_PROMPT", defaultTotpPrompt)
+}
+
+// GetPassword returns the connection password from its provider or passfile
+func GetPassword(i *connection.Item) (string, error) {
+	if i.Conn.Password != "" {
+		return encryption.GetSecretPassword(i.Conn.Password)
+	}
+
+	pw := strings.TrimSpace(encryption.GetEncryptedContents(i.Conn.PassFile))
+	if pw == "" {
+		return "", fmt.Errorf("could not decrypt %v", i.Conn.PassFile)
+	}
+	return pw, nil
+}
+
+// GetTotpResponders answers the password and keyboard-interactive TOTP prompts
+// for connections with a totp key, which sshpass can't handle
+func GetTotpResponders(i *connection.Item) []*Responder {
+	if i.Conn.Totp == "" {
+		return nil
+	}
+
+	var responders []*Responder
+	if i.Conn.Password != "" || i.Conn.PassFile != "" {
+		responders = append(responders, &Responder{
+			Prompt: PasswordPrompt(),
+			Answer: func() (string, error) { return GetPassword(i) },
+		})
+	}
+	responders = append(responders, &Responder{
+		Prompt: TotpPrompt(),
+		Answer: func() (string, error) {
+			// generated when prompted so the code is as fresh as possible
+			code, _, err := totp.GetCode(i.Conn.Totp)
+			return code, err
+		},
+	})
+
+	return responders
+}
+
+// promptWatcher scans output written to it and answers each responder once
+type promptWatcher struct {
+	mu         sync.Mutex
+	responders []*Responder
+	buf        []byte
+	answers    io.Writer
+}
+
+func (p *promptWatcher) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pending := false
+	for _, r := range p.responders {
+		pending = pending || !r.answered
+	}
+	if !pending {
+		return len(b), nil
+	}
+
+	p.buf = append(p.buf, b...)
+	if len(p.buf) > promptBufferSize {
+		p.buf = p.buf[len(p.buf)-promptBufferSize:]
+	}
+
+	for _, r := range p.responders {
+		if r.answered || (r.After != nil && !r.After.answered) || !r.Prompt.Match(p.buf) {
+			continue
+		}
+		r.answered = true
+		p.buf = p.buf[:0]
+
+		answer, err := r.Answer()
+		if err != nil {
+			log.Logger.Error("Could not answer prompt", "prompt", r.Prompt.String(), "err", err)
+			break
+		}
+		io.WriteString(p.answers, answer+"\n")
+		break
+	}
+
+	return len(b), nil
+}
+
+// ptyOutput collects the output of the command on ptmx, answering its
+// prompts through the watcher, until the command exits
+func ptyOutput(cmd *exec.Cmd, ptmx *os.File, watcher *promptWatcher) (string, error) {
+	var out bytes.Buffer
+	// reading the pty returns an error once the command exits
+	io.Copy(io.MultiWriter(&out, watcher), ptmx)
+	err := cmd.Wait()
+	return strings.ReplaceAll(out.String(), "\r\n", "\n"), err
+}
+
+// PtyCommandOutput runs the command on a pty answering the responders and
+// returns its combined output
+func PtyCommandOutput(cmd *exec.Cmd, responders []*Responder) (string, error) {
+	wait, err := StartPtyCommand(cmd, responders)
+	if err != nil {
+		return "", err
+	}
+	return wait()
+}
+
+// StartPtyCommand starts cmd on a pty that answers the responders. The
+// returned function waits for the command to exit and returns its output.
+func StartPtyCommand(cmd *exec.Cmd, responders []*Responder) (func() (string, error), error) {
+	ptmx, err := pty.Start(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("could not start pty: %w", err)
+	}
+
+	return func() (string, error) {
+		defer ptmx.Close()
+		return ptyOutput(cmd, ptmx, &promptWatcher{responders: responders, answers: ptmx})
+	}, nil
+}
+
+// RunPtyCommand runs the command on a pty so prompts can be answered by the
+// responders. Attached commands are then handed over to the user's terminal.
+func RunPtyCommand(cmd *exec.Cmd, responders []*Responder, attached bool) string {
+	ptmx, err := pty.Start(cmd)
+	if err != nil {
+		return fmt.Sprintf("could not start pty: %v", err)
+	}
+	defer ptmx.Close()
+
+	watcher := &promptWatcher{responders: responders, answers: ptmx}
+
+	if !attached {
+		outerr, err := ptyOutput(cmd, ptmx, watcher)
+		if err != nil {
+			return fmt.Sprintf("%s: %s", err.Error(), outerr)
+		}
+		if outerr == "" {
+			return "Success!"
+		}
+		return outerr
+	}
+
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		stopResize := watchResize(ptmx)
+		defer stopResize()
+
+		oldState, err := term.MakeRaw(fd)
+		if err == nil {
+			defer term.Restore(fd, oldState)
+		}
+	}
+
+	// stdin is read through a cancelable reader so the copy stops with the
+	// command instead of swallowing the next keystroke
+	stdin, err := cancelreader.NewReader(os.Stdin)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Sprintf("could not read stdin: %v", err)
+	}
+	defer stdin.Close()
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(ptmx, stdin)
+		close(copied)
+	}()
+	io.Copy(io.MultiWriter(os.Stdout, watcher), ptmx)
+	cmd.Wait()
+	stdin.Cancel()
+	<-copied
+
+	return fmt.Sprintf("\n%v\n", strings.Join(cmd.Args, " "))
+}
@@ -0,0 +1,222 @@
+//go:build !windows
+
+package runcommand
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/creack/pty"
+	"github.com/nicknickel/gossh/internal/connection"
+	internal_log "github.com/nicknickel/gossh/internal/log"
+)
+
+// fakeLogin behaves like an ssh login asking for a password then a TOTP code
+const fakeLogin = `#!/bin/sh
+printf 'user@host password: '
+read pw
+printf '(user@host) Verification code: '
+read code
+echo "password=$pw code=$code"
+`
+
+func writeFakeProgram(t *testing.T, script string) string {
+	f := filepath.Join(t.TempDir(), "fake")
+	if err := os.WriteFile(f, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write fake program: %v", err)
+	}
+	return f
+}
+
+func TestRunPtyCommand(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	prog := writeFakeProgram(t, fakeLogin)
+
+	responders := []*Responder{
+		{
+			Prompt: regexp.MustCompile(defaultPasswordPrompt),
+			Answer: func() (string, error) { return "hunter2", nil },
+		},
+		{
+			Prompt: regexp.MustCompile(defaultTotpPrompt),
+			Answer: func() (string, error) { return "123456", nil },
+		},
+	}
+
+	out := RunPtyCommand(exec.Command(prog), responders, false)
+	if !strings.Contains(out, "password=hunter2 code=123456") {
+		t.Errorf("RunPtyCommand() = %q, want answers to both prompts", out)
+	}
+	for _, r := range responders {
+		if !r.answered {
+			t.Errorf("responder %v not answered", r.Prompt)
+		}
+	}
+}
+
+func TestRunPtyCommand_Failure(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	prog := writeFakeProgram(t, "#!/bin/sh\necho denied\nexit 3\n")
+
+	out := RunPtyCommand(exec.Command(prog), nil, false)
+	if !strings.HasPrefix(out, "exit status 3") || !strings.Contains(out, "denied") {
+		t.Errorf("RunPtyCommand() = %q, want exit status and output", out)
+	}
+}
+
+func TestRunPtyCommand_AttachedStdin(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	// the terminal gossh runs in
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		t.Skipf("no pty: %v", err)
+	}
+	defer ptmx.Close()
+	defer tty.Close()
+	stdin, stdout := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = tty, tty
+	defer func() { os.Stdin, os.Stdout = stdin, stdout }()
+	go io.Copy(io.Discard, ptmx)
+
+	RunPtyCommand(exec.Command("true"), nil, true)
+
+	// input typed after the command exits is left for the next reader
+	time.Sleep(50 * time.Millisecond)
+	ptmx.WriteString("x\n")
+	read := make(chan string)
+	go func() {
+		b := make([]byte, 8)
+		n, _ := tty.Read(b)
+		read <- string(b[:n])
+	}()
+	select {
+	case got := <-read:
+		if got != "x\n" {
+			t.Errorf("stdin after RunPtyCommand() = %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("stdin after RunPtyCommand() was swallowed")
+	}
+}
+
+func TestPtyCommandOutput(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	prog := writeFakeProgram(t, fakeLogin)
+	responders := []*Responder{
+		{
+			Prompt: regexp.MustCompile(defaultPasswordPrompt),
+			Answer: func() (string, error) { return "hunter2", nil },
+		},
+		{
+			Prompt: regexp.MustCompile(defaultTotpPrompt),
+			Answer: func() (string, error) { return "123456", nil },
+		},
+	}
+
+	out, err := PtyCommandOutput(exec.Command(prog), responders)
+	if err != nil || !strings.Contains(out, "password=hunter2 code=123456\n") {
+		t.Errorf("PtyCommandOutput() = %q, %v", out, err)
+	}
+
+	failing := writeFakeProgram(t, "#!/bin/sh\necho denied\nexit 3\n")
+	out, err = PtyCommandOutput(exec.Command(failing), nil)
+	if err == nil || out != "denied\n" {
+		t.Errorf("PtyCommandOutput() = %q, %v, want output and error", out, err)
+	}
+}
+
+func TestGetPromptRegexp(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+
+	t.Setenv("GOSSH_TOTP_PROMPT", "(?i)passcode:")
+	if r := getPromptRegexp("GOSSH_TOTP_PROMPT", defaultTotpPrompt); !r.MatchString("Enter PASSCODE:") {
+		t.Errorf("getPromptRegexp() = %v, want custom prompt", r)
+	}
+
+	t.Setenv("GOSSH_TOTP_PROMPT", "([")
+	if r := getPromptRegexp("GOSSH_TOTP_PROMPT", defaultTotpPrompt); r.String() != defaultTotpPrompt {
+		t.Errorf("getPromptRegexp() with invalid regex = %v, want default", r)
+	}
+}
+
+// fakeSwitch behaves like network gear needing an enable password and a menu
+// selection after login
+const fakeSwitch = `#!/bin/sh
+printf 'Select option [1-2]: '
+read option
+printf 'switch> '
+read cmd
+printf 'Password: '
+read enablepw
+echo "option=$option cmd=$cmd enable=$enablepw"
+`
+
+func TestGetAutomationResponders(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	prog := writeFakeProgram(t, fakeSwitch)
+
+	item := connection.Item{
+		Name: "switch",
+		Conn: connection.Connection{
+			Automation: []connection.AutomationStep{
+				{Expect: `option \[1-2\]: $`, Send: "2"},
+				{Expect: `> $`, Send: "enable"},
+				{Expect: `Password: $`, SendSecret: "exec:echo enablesecret"},
+			},
+		},
+	}
+
+	responders := GetAutomationResponders(&item)
+	if len(responders) != 3 {
+		t.Fatalf("GetAutomationResponders() len = %d, want 3", len(responders))
+	}
+
+	out := RunPtyCommand(exec.Command(prog), responders, false)
+	if !strings.Contains(out, "option=2 cmd=enable enable=enablesecret") {
+		t.Errorf("RunPtyCommand() = %q, want automation answers", out)
+	}
+}
+
+func TestGetAutomationResponders_Order(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	// the second step's prompt appears first but must wait for the first step
+	prog := writeFakeProgram(t, "#!/bin/sh\nprintf 'b> '\nsleep 0.3\nprintf 'a> '\nread y\nprintf 'b> '\nread z\necho \"y=$y z=$z\"\n")
+
+	item := connection.Item{
+		Conn: connection.Connection{
+			Automation: []connection.AutomationStep{
+				{Expect: `a> $`, Send: "first"},
+				{Expect: `b> $`, Send: "second"},
+			},
+		},
+	}
+
+	out := RunPtyCommand(exec.Command(prog), GetAutomationResponders(&item), false)
+	if !strings.Contains(out, "y=first z=second") {
+		t.Errorf("RunPtyCommand() = %q, want steps answered in order", out)
+	}
+}
+
+func TestGetAutomationResponders_InvalidStep(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	item := connection.Item{
+		Conn: connection.Connection{
+			Automation: []connection.AutomationStep{
+				{Expect: `> $`, Send: "enable"},
+				{Expect: `([`, Send: "bad"},
+				{Expect: `# $`, Send: "never"},
+			},
+		},
+	}
+
+	if got := GetAutomationResponders(&item); len(got) != 1 {
+		t.Errorf("GetAutomationResponders() len = %d, want 1", len(got))
+	}
+}
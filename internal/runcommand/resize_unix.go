@@ -0,0 +1,28 @@
+//go:build !windows
+
+package runcommand
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/creack/pty"
+)
+
+// watchResize keeps the pty size in sync with the user's terminal
+func watchResize(ptmx *os.File) func() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGWINCH)
+	go func() {
+		for range ch {
+			pty.InheritSize(os.Stdin, ptmx)
+		}
+	}()
+	ch <- syscall.SIGWINCH
+
+	return func() {
+		signal.Stop(ch)
+		close(ch)
+	}
+}
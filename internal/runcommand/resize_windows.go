@@ -0,0 +1,10 @@
+//go:build windows
+
+package runcommand
+
+import "os"
+
+// watchResize is a no-op as ptys are not supported on windows
+func watchResize(ptmx *os.File) func() {
+	return func() {}
+}
@@ -13,7 +13,9 @@ import (
 	"github.com/charmbracelet/lipgloss"
 	"github.com/nicknickel/gossh/internal/connection"
 	"github.com/nicknickel/gossh/internal/encryption"
+	"github.com/nicknickel/gossh/internal/hostkey"
 	"github.com/nicknickel/gossh/internal/log"
+	"github.com/nicknickel/gossh/internal/sshagent"
 	"golang.org/x/term"
 	"sync"
 	"text/template"
@@ -26,6 +28,15 @@ func GetPasswordTemplate(i *connection.Item) ([]string, []string, error) {
 		return []string{}, []string{}, errors.New("sshpass not found")
 	}
 
+	if i.Conn.Password != "" {
+		pw, err := encryption.GetSecretPassword(i.Conn.Password)
+		if err != nil {
+			log.Logger.Error("Could not get password from provider", "ref", i.Conn.Password, "err", err)
+			return []string{}, []string{}, err
+		}
+		return []string{"sshpass", "-e"}, []string{"SSHPASS=" + pw}, nil
+	}
+
 	if i.Conn.PassFile == "" {
 		return []string{}, []string{}, errors.New("passfile parameter not defined")
 	}
@@ -38,17 +49,19 @@ func GetPasswordTemplate(i *connection.Item) ([]string, []string, error) {
 	}
 }
 
-func GetIdentityTemplate(i *connection.Item) ([]string, bool, error) {
+func GetIdentityTemplate(i *connection.Item) ([]string, []string, func(), error) {
 	if i.Conn.IdentityFile == "" {
-		return []string{}, false, errors.New("No identify file indicated")
+		return []string{}, nil, nil, errors.New("No identify file indicated")
 	}
 
-	tempIdFile := encryption.GetEncryptedIdentity(i.Conn.IdentityFile)
-	if tempIdFile != "" {
-		return []string{"-i", tempIdFile}, true, nil
+	// encrypted identities are loaded into an ssh-agent rather than written to disk
+	id, err := sshagent.LoadIdentity(i.Conn.IdentityFile)
+	if err == nil {
+		return []string{}, id.Env(), func() { id.Close() }, nil
 	}
+	log.Logger.Debug("Identity not loaded into agent", "file", i.Conn.IdentityFile, "err", err)
 
-	return []string{"-i", i.Conn.IdentityFile}, false, nil
+	return []string{"-i", i.Conn.IdentityFile}, nil, nil, nil
 }
 
 func RenderTemplateSlice(s *[]string, i connection.Item) []string {
@@ -101,25 +114,136 @@ func RunCommandWithOutput(cmd *exec.Cmd) string {
 	return string(outerr)
 }
 
-func RunCommand(i *connection.Item, c []string, a bool) string {
-	env := GetEnv()
+// login is what one step of the authentication adds to a command: its
+// arguments, environment and cleanup, and for dry runs a note on how it
+// authenticates
+type login struct {
+	args    []string
+	env     []string
+	cleanup func()
+	note    string
+}
 
-	passTemplate, passEnv, err := GetPasswordTemplate(i)
-	if err == nil {
-		c = slices.Insert(c, 0, passTemplate...)
-		if passEnv != nil {
-			env = append(env, passEnv...)
+// secrets look up the host keys, password and identity of a connection,
+// decrypting them for PrepareCommand or only describing them for
+// DryRunCommand
+type secrets interface {
+	sshOptions(i connection.Item) (login, error)
+	password(i *connection.Item) (login, error)
+	identity(i *connection.Item) (login, error)
+}
+
+// liveSecrets decrypts the secrets and records pinned host keys
+type liveSecrets struct{}
+
+func (liveSecrets) sshOptions(i connection.Item) (login, error) {
+	opts, err := hostkey.SshOptions(i)
+	return login{args: opts}, err
+}
+
+func (liveSecrets) password(i *connection.Item) (login, error) {
+	args, env, err := GetPasswordTemplate(i)
+	return login{args: args, env: env}, err
+}
+
+func (liveSecrets) identity(i *connection.Item) (login, error) {
+	args, env, cleanup, err := GetIdentityTemplate(i)
+	return login{args: args, env: env, cleanup: cleanup}, err
+}
+
+// prepared is a command with the connection's authentication added, its
+// arguments not rendered yet
+type prepared struct {
+	args       []string
+	env        []string
+	responders []*Responder
+	cleanup    func()
+	notes      []string
+}
+
+// prepare is the branching shared by PrepareCommand and DryRunCommand
+func prepare(i *connection.Item, c []string, s secrets) (prepared, error) {
+	p := prepared{env: GetEnv(), cleanup: func() {}}
+	add := func(l login) {
+		p.env = append(p.env, l.env...)
+		if l.note != "" {
+			p.notes = append(p.notes, l.note)
+		}
+	}
+
+	if len(c) > 0 && c[0] == "ssh" {
+		l, err := s.sshOptions(*i)
+		if err != nil {
+			return p, err
 		}
+		c = slices.Insert(slices.Clone(c), 1, l.args...)
+		add(l)
+	}
+
+	// sshpass only answers the password prompt so totp connections answer
+	// every prompt on a pty instead
+	loginResponders := GetTotpResponders(i)
+	usingSshpass := false
+	if loginResponders == nil {
+		l, err := s.password(i)
+		if err == nil {
+			c = slices.Insert(c, 0, l.args...)
+			usingSshpass = true
+		}
+		add(l)
 	} else {
-		idTemplate, cleanup, err := GetIdentityTemplate(i)
+		p.notes = append(p.notes, "password and verification code prompts answered on a pty")
+	}
+
+	if !usingSshpass {
+		l, err := s.identity(i)
 		if err == nil {
-			c = slices.Insert(c, 1, idTemplate...)
-			if cleanup {
-				defer os.Remove(idTemplate[1])
+			c = slices.Insert(c, 1, l.args...)
+			if l.cleanup != nil {
+				p.cleanup = l.cleanup
 			}
 		}
+		add(l)
+	}
+
+	p.responders = loginResponders
+	automation := GetAutomationResponders(i)
+	if len(automation) > 0 {
+		// automation starts once the login prompts have been answered
+		if len(loginResponders) > 0 {
+			automation[0].After = loginResponders[len(loginResponders)-1]
+		}
+		p.responders = append(p.responders, automation...)
+		p.notes = append(p.notes, fmt.Sprintf("%v automation step(s) after login", len(automation)))
+	}
+
+	p.args = c
+	return p, nil
+}
+
+// PrepareCommand adds the connection authentication to the command. Prompts
+// that must be answered on a pty are returned as responders. The returned
+// cleanup function must be called once the command has finished. It fails
+// when the host key of a pinned host can not be confirmed.
+func PrepareCommand(i *connection.Item, c []string) (*exec.Cmd, []*Responder, func(), error) {
+	p, err := prepare(i, c, liveSecrets{})
+	if err != nil {
+		return nil, nil, p.cleanup, err
+	}
+	return CreateCommand(&p.args, &p.env, *i), p.responders, p.cleanup, nil
+}
+
+func RunCommand(i *connection.Item, c []string, a bool) string {
+	cmd, responders, cleanup, err := PrepareCommand(i, c)
+	defer cleanup()
+	if err != nil {
+		log.Logger.Error("Could not prepare command", "connection", i.Name, "err", err)
+		return err.Error()
+	}
+
+	if len(responders) > 0 {
+		return RunPtyCommand(cmd, responders, a)
 	}
-	cmd := CreateCommand(&c, &env, *i)
 
 	var out string
 	if a {
@@ -140,12 +264,7 @@ func RunConcurrentCommandWithOutput(items []connection.Item, title string, c []s
 		Width(width - 10)
 
 	var wg sync.WaitGroup
-	maxConcurrent := 5
-	concurrentEnv := os.Getenv("GOSSH_CONCURRENCY")
-	if concurrentEnv != "" {
-		maxConcurrent, _ = strconv.Atoi(concurrentEnv)
-	}
-	limiter := make(chan int, maxConcurrent)
+	limiter := make(chan int, GetConcurrency())
 
 	t1 := template.New("title")
 	t1, _ = t1.Parse(title)
@@ -165,6 +284,15 @@ func RunConcurrentCommandWithOutput(items []connection.Item, title string, c []s
 	wg.Wait()
 }
 
+func GetConcurrency() int {
+	maxConcurrent := 5
+	concurrentEnv := os.Getenv("GOSSH_CONCURRENCY")
+	if concurrentEnv != "" {
+		maxConcurrent, _ = strconv.Atoi(concurrentEnv)
+	}
+	return maxConcurrent
+}
+
 func GetTermWidth() int {
 	fd := int(os.Stdout.Fd())
 	width, _, err := term.GetSize(fd)
@@ -0,0 +1,20 @@
+package runcommand
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/nicknickel/gossh/internal/connection"
+)
+
+func TestRenderTemplateSlice(t *testing.T) {
+	item := connection.Item{Name: "web1", Conn: connection.Connection{User: "ops"},
+		Facts: map[string]string{"os": "ubuntu"}}
+	c := []string{"ssh", "{{.FinalAddr}}", "echo", "{{.Fact", `"os"}}`, "{{.Fact", `"missing"}}`, "{{.Facts.os}}"}
+
+	got := RenderTemplateSlice(&c, item)
+	expected := []string{"ssh", "ops@web1", "echo", "ubuntu", "", "ubuntu"}
+	if !slices.Equal(got, expected) {
+		t.Errorf("RenderTemplateSlice() = %q, want %q", got, expected)
+	}
+}
@@ -0,0 +1,445 @@
+package secret
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/nicknickel/gossh/internal/config"
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/encryption"
+	"golang.org/x/term"
+)
+
+const secretUsage = `Usage: gossh secret <command> [options]
+
+Commands:
+  encrypt <connection>        encrypt a password (or key with -identity) and set it on the connection
+  decrypt <connection|file>   print the decrypted contents of a secret
+  rekey                       re-encrypt every referenced secret to a new passphrase or recipients
+  edit <connection|file>      edit a secret with $EDITOR
+`
+
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+type recipientFlags struct {
+	recipients     stringList
+	recipientsFile string
+}
+
+func (r *recipientFlags) register(fs *flag.FlagSet) {
+	fs.Var(&r.recipients, "recipient", "Encrypt to the age or ssh public key recipient (repeatable)")
+	fs.StringVar(&r.recipientsFile, "recipients-file", "", "Encrypt to the recipients listed in the file")
+}
+
+func (r *recipientFlags) given() bool {
+	return len(r.recipients) > 0 || r.recipientsFile != ""
+}
+
+// resolve returns the recipients from the flags, otherwise a passphrase
+// recipient using passEnv or an interactive prompt
+func (r *recipientFlags) resolve(passEnv string) ([]age.Recipient, error) {
+	if r.given() {
+		return encryption.ParseRecipients(r.recipients, r.recipientsFile)
+	}
+
+	p := os.Getenv(passEnv)
+	if p == "" {
+		var err error
+		p, err = readPassphrase("New passphrase: ", true)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return encryption.PassphraseRecipient(p)
+}
+
+func readPassphrase(prompt string, confirm bool) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.New("passphrase prompt requires a terminal")
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	p, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+
+	if confirm {
+		fmt.Fprint(os.Stderr, "Confirm passphrase: ")
+		c, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		if !bytes.Equal(p, c) {
+			return "", errors.New("passphrases do not match")
+		}
+	}
+
+	return string(p), nil
+}
+
+func secretIdentities() []age.Identity {
+	identities := encryption.GetIdentities()
+	if len(identities) > 0 {
+		return identities
+	}
+
+	p, err := readPassphrase("Passphrase: ", false)
+	if err != nil || p == "" {
+		return nil
+	}
+	os.Setenv("GOSSH_PASSPHRASE", p)
+	return encryption.GetIdentities()
+}
+
+// ResolveFile resolves a connection name or a path to the secret file to use
+func ResolveFile(arg string, identity bool) (string, error) {
+	if item, ok := config.FindConnection(arg); ok {
+		f := item.Conn.PassFile
+		if identity {
+			f = item.Conn.IdentityFile
+		}
+		if f == "" {
+			return "", fmt.Errorf("connection %v has no secret defined", arg)
+		}
+		return f, nil
+	}
+
+	if _, err := os.Stat(arg); err != nil {
+		return "", fmt.Errorf("%v is neither a connection nor a file", arg)
+	}
+	return arg, nil
+}
+
+// Referenced returns every unique passfile, identity, totp seed and
+// automation secret file across all connections
+func Referenced() []string {
+	var files []string
+	for _, val := range config.ReadConnections() {
+		item := val.(connection.Item)
+		refs := []string{item.Conn.PassFile, item.Conn.IdentityFile, item.Conn.Totp}
+		for _, step := range item.Conn.Automation {
+			refs = append(refs, step.SendSecret)
+		}
+		for _, f := range refs {
+			if f != "" && !encryption.IsSecretRef(f) && !slices.Contains(files, f) {
+				files = append(files, f)
+			}
+		}
+	}
+	slices.Sort(files)
+	return files
+}
+
+// Rekey decrypts every age encrypted file and re-encrypts them all next to
+// the originals before replacing any, so a wrong passphrase or failed write
+// never leaves secrets encrypted to a mix of keys. recipients returns the
+// recipients each file is re-encrypted to.
+func Rekey(files []string, identities []age.Identity, recipients func(string) ([]age.Recipient, error), dryRun bool) ([]string, error) {
+	var report []string
+	contents := make(map[string][]byte)
+	var rekeyed []string
+
+	for _, f := range files {
+		if encrypted, _ := encryption.IsEncrypted(f); !encrypted {
+			report = append(report, fmt.Sprintf("%v: not age encrypted, skipping", f))
+			continue
+		}
+		out, err := encryption.Decrypt(f, identities)
+		if err != nil {
+			return report, fmt.Errorf("could not decrypt %v, nothing was changed: %w", f, err)
+		}
+		contents[f] = out
+		rekeyed = append(rekeyed, f)
+	}
+
+	if dryRun {
+		for _, f := range rekeyed {
+			report = append(report, fmt.Sprintf("%v: would be re-encrypted", f))
+		}
+		return report, nil
+	}
+
+	var written []string
+	defer func() {
+		for _, tmp := range written {
+			os.Remove(tmp)
+		}
+	}()
+	for _, f := range rekeyed {
+		to, err := recipients(f)
+		if err != nil {
+			return report, fmt.Errorf("could not re-encrypt %v, nothing was changed: %w", f, err)
+		}
+		tmp := f + ".rekey"
+		if err := encryption.Encrypt(contents[f], to, tmp); err != nil {
+			return report, fmt.Errorf("could not re-encrypt %v, nothing was changed: %w", f, err)
+		}
+		written = append(written, tmp)
+	}
+
+	for _, f := range rekeyed {
+		if err := os.Rename(f+".rekey", f); err != nil {
+			return report, fmt.Errorf("could not replace %v: %w", f, err)
+		}
+		report = append(report, fmt.Sprintf("%v: re-encrypted", f))
+	}
+
+	return report, nil
+}
+
+func secretEncrypt(args []string) error {
+	fs := flag.NewFlagSet("secret encrypt", flag.ContinueOnError)
+	identity := fs.Bool("identity", false, "Store the secret as the connection identity instead of passfile")
+	in := fs.String("in", "", "Read the secret from this file instead of stdin")
+	out := fs.String("out", "", "Path of the encrypted file (default next to the config file)")
+	var rf recipientFlags
+	rf.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("encrypt requires a connection name")
+	}
+	name := fs.Arg(0)
+
+	configFile, err := config.FindConnectionFile(name)
+	if err != nil {
+		return err
+	}
+
+	var secret []byte
+	if *in != "" {
+		secret, err = os.ReadFile(*in)
+	} else if term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprint(os.Stderr, "Secret: ")
+		secret, err = term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+	} else {
+		secret, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return err
+	}
+	if len(secret) == 0 {
+		return errors.New("secret cannot be empty")
+	}
+
+	key, ext := "passfile", ".pass.age"
+	if *identity {
+		key, ext = "identity", ".key.age"
+	}
+	outFile := *out
+	if outFile == "" {
+		c := connection.Item{Name: name}
+		outFile = filepath.Join(filepath.Dir(configFile), c.CleanTitle()+ext)
+	}
+
+	recipients, err := rf.resolve("GOSSH_PASSPHRASE")
+	if err != nil {
+		return err
+	}
+	if err := encryption.Encrypt(secret, recipients, outFile); err != nil {
+		return err
+	}
+
+	if err := config.SetConnectionValue(configFile, name, key, config.RelativeToConfig(configFile, outFile)); err != nil {
+		return fmt.Errorf("secret written to %v but could not update %v: %w", outFile, configFile, err)
+	}
+	fmt.Printf("Encrypted %v for %v and updated %v\n", outFile, name, configFile)
+	return nil
+}
+
+func secretDecrypt(args []string) error {
+	fs := flag.NewFlagSet("secret decrypt", flag.ContinueOnError)
+	identity := fs.Bool("identity", false, "Use the connection identity instead of passfile")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("decrypt requires a connection name or file")
+	}
+
+	f, err := ResolveFile(fs.Arg(0), *identity)
+	if err != nil {
+		return err
+	}
+	out, err := encryption.Decrypt(f, secretIdentities())
+	if err != nil {
+		return err
+	}
+
+	os.Stdout.Write(out)
+	return nil
+}
+
+func secretRekey(args []string) error {
+	fs := flag.NewFlagSet("secret rekey", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "Only report the secrets that would be re-encrypted")
+	var rf recipientFlags
+	rf.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	identities := secretIdentities()
+	var newPassphrase []age.Recipient
+	recipients := func(f string) ([]age.Recipient, error) {
+		if rf.given() {
+			return rf.resolve("")
+		}
+		// files encrypted to public keys keep them, only the passphrase changes
+		to, err := encryption.RecipientsOf(f)
+		if err != nil {
+			return nil, fmt.Errorf("%w, pass -recipient to choose its recipients", err)
+		}
+		if _, ok := to[0].(*age.ScryptRecipient); !ok {
+			return to, nil
+		}
+		if newPassphrase == nil {
+			newPassphrase, err = rf.resolve("GOSSH_NEW_PASSPHRASE")
+		}
+		return newPassphrase, err
+	}
+
+	report, err := Rekey(Referenced(), identities, recipients, *dryRun)
+	for _, line := range report {
+		fmt.Println(line)
+	}
+	return err
+}
+
+// editorTempDir prefers memory backed storage so the plaintext never reaches disk
+func editorTempDir() (string, error) {
+	base := ""
+	if fi, err := os.Stat("/dev/shm"); err == nil && fi.IsDir() {
+		base = "/dev/shm"
+	}
+	return os.MkdirTemp(base, "gossh-edit-*")
+}
+
+// editRecipients keeps the recipients the file was encrypted to unless
+// others are given with the flags
+func editRecipients(f string, rf *recipientFlags) ([]age.Recipient, error) {
+	if rf.given() {
+		return rf.resolve("")
+	}
+	recipients, err := encryption.RecipientsOf(f)
+	if err != nil {
+		return nil, fmt.Errorf("%w, pass -recipient to choose its recipients", err)
+	}
+	return recipients, nil
+}
+
+func secretEdit(args []string) error {
+	fs := flag.NewFlagSet("secret edit", flag.ContinueOnError)
+	identity := fs.Bool("identity", false, "Edit the connection identity instead of passfile")
+	var rf recipientFlags
+	rf.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("edit requires a connection name or file")
+	}
+
+	f, err := ResolveFile(fs.Arg(0), *identity)
+	if err != nil {
+		return err
+	}
+	original, err := encryption.Decrypt(f, secretIdentities())
+	if err != nil {
+		return err
+	}
+	recipients, err := editRecipients(f, &rf)
+	if err != nil {
+		return err
+	}
+
+	dir, err := editorTempDir()
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, filepath.Base(strings.TrimSuffix(f, ".age")))
+	if err := os.WriteFile(tmp, original, 0600); err != nil {
+		return err
+	}
+	// overwrite the plaintext before it is removed
+	defer func() {
+		if fi, err := os.Stat(tmp); err == nil {
+			os.WriteFile(tmp, make([]byte, fi.Size()), 0600)
+		}
+	}()
+
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	editorArgs := append(strings.Fields(editor), tmp)
+	c := exec.Command(editorArgs[0], editorArgs[1:]...)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("editor failed, secret unchanged: %w", err)
+	}
+
+	edited, err := os.ReadFile(tmp)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(edited, original) {
+		fmt.Println("No changes made")
+		return nil
+	}
+
+	if err := encryption.Encrypt(edited, recipients, f); err != nil {
+		return err
+	}
+	fmt.Printf("Updated %v\n", f)
+	return nil
+}
+
+func Run(args []string) error {
+	if len(args) == 0 {
+		fmt.Print(secretUsage)
+		return errors.New("no secret command given")
+	}
+
+	switch args[0] {
+	case "encrypt":
+		return secretEncrypt(args[1:])
+	case "decrypt":
+		return secretDecrypt(args[1:])
+	case "rekey":
+		return secretRekey(args[1:])
+	case "edit":
+		return secretEdit(args[1:])
+	default:
+		fmt.Print(secretUsage)
+		return fmt.Errorf("unknown secret command %v", args[0])
+	}
+}
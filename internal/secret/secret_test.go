@@ -0,0 +1,170 @@
+package secret
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/charmbracelet/log"
+	"github.com/nicknickel/gossh/internal/encryption"
+	internal_log "github.com/nicknickel/gossh/internal/log"
+)
+
+func TestRekey(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	dir := t.TempDir()
+
+	oldRecipients, _ := encryption.PassphraseRecipient("oldpass")
+	oldIdentity, _ := age.NewScryptIdentity("oldpass")
+	newIdentity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+
+	encrypted := []string{dir + "/a.age", dir + "/b.age"}
+	for _, f := range encrypted {
+		if err := encryption.Encrypt([]byte("secret"), oldRecipients, f); err != nil {
+			t.Fatalf("Encrypt() error = %v", err)
+		}
+	}
+	armored := dir + "/c.age"
+	buf := new(bytes.Buffer)
+	aw := armor.NewWriter(buf)
+	w, _ := age.Encrypt(aw, oldRecipients...)
+	w.Write([]byte("secret"))
+	w.Close()
+	aw.Close()
+	os.WriteFile(armored, buf.Bytes(), 0600)
+	encrypted = append(encrypted, armored)
+
+	plain := dir + "/plain"
+	os.WriteFile(plain, []byte("secret"), 0600)
+	files := append(encrypted, plain)
+	to := func(string) ([]age.Recipient, error) {
+		return []age.Recipient{newIdentity.Recipient()}, nil
+	}
+
+	// a wrong identity must not change anything
+	wrong, _ := age.NewScryptIdentity("wrong")
+	before, _ := os.ReadFile(encrypted[0])
+	if _, err := Rekey(files, []age.Identity{wrong}, to, false); err == nil {
+		t.Errorf("Rekey() with wrong identity expected error")
+	}
+	after, _ := os.ReadFile(encrypted[0])
+	if string(before) != string(after) {
+		t.Errorf("Rekey() modified %v after failing", encrypted[0])
+	}
+
+	// neither is a failed write
+	blocker := encrypted[1] + ".rekey"
+	os.MkdirAll(blocker+"/x", 0700)
+	if _, err := Rekey(files, []age.Identity{oldIdentity}, to, false); err == nil {
+		t.Errorf("Rekey() with unwritable %v expected error", encrypted[1])
+	}
+	after, _ = os.ReadFile(encrypted[0])
+	if string(before) != string(after) {
+		t.Errorf("Rekey() modified %v after failing to write %v", encrypted[0], encrypted[1])
+	}
+	if _, err := os.Stat(encrypted[0] + ".rekey"); !os.IsNotExist(err) {
+		t.Errorf("Rekey() left %v.rekey behind", encrypted[0])
+	}
+	os.RemoveAll(blocker)
+
+	report, err := Rekey(files, []age.Identity{oldIdentity}, to, false)
+	if err != nil {
+		t.Fatalf("Rekey() error = %v", err)
+	}
+	if len(report) != 4 {
+		t.Errorf("Rekey() report = %v, want 4 lines", report)
+	}
+
+	for _, f := range encrypted {
+		got, err := encryption.Decrypt(f, []age.Identity{newIdentity})
+		if err != nil || string(got) != "secret" {
+			t.Errorf("Decrypt(%v) with new identity = %v, %v", f, string(got), err)
+		}
+	}
+	if got, _ := os.ReadFile(plain); string(got) != "secret" {
+		t.Errorf("Rekey() modified unencrypted file")
+	}
+}
+
+func TestRekey_Referenced(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	dir := t.TempDir()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GOSSH_CONFIGDIR", dir)
+
+	oldRecipients, _ := encryption.PassphraseRecipient("oldpass")
+	oldIdentity, _ := age.NewScryptIdentity("oldpass")
+	newIdentity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+
+	secrets := []string{"web.pass.age", "web.totp.age", "enable.age"}
+	for _, f := range secrets {
+		if err := encryption.Encrypt([]byte("secret"), oldRecipients, filepath.Join(dir, f)); err != nil {
+			t.Fatalf("Encrypt() error = %v", err)
+		}
+	}
+	conf := `web:
+  address: web.example.com
+  passfile: web.pass.age
+  totp: web.totp.age
+  automation:
+    - expect: "> $"
+      send: enable
+    - expect: "Password:"
+      sendsecret: enable.age
+`
+	os.WriteFile(filepath.Join(dir, "gossh.yml"), []byte(conf), 0600)
+
+	to := func(string) ([]age.Recipient, error) {
+		return []age.Recipient{newIdentity.Recipient()}, nil
+	}
+	if _, err := Rekey(Referenced(), []age.Identity{oldIdentity}, to, false); err != nil {
+		t.Fatalf("Rekey() error = %v", err)
+	}
+	for _, f := range secrets {
+		got, err := encryption.Decrypt(filepath.Join(dir, f), []age.Identity{newIdentity})
+		if err != nil || string(got) != "secret" {
+			t.Errorf("Decrypt(%v) with new identity = %v, %v", f, string(got), err)
+		}
+	}
+}
+
+func TestSecretEdit_KeepsRecipients(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	dir := t.TempDir()
+	t.Setenv("GOSSH_PASSPHRASE", "")
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+	idFile := filepath.Join(dir, "identity.txt")
+	os.WriteFile(idFile, []byte(identity.String()+"\n"), 0600)
+	t.Setenv("GOSSH_AGE_IDENTITY", idFile)
+
+	f := filepath.Join(dir, "secret.age")
+	if err := encryption.Encrypt([]byte("old"), []age.Recipient{identity.Recipient()}, f); err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	editor := filepath.Join(dir, "editor")
+	os.WriteFile(editor, []byte("#!/bin/sh\nprintf new > \"$1\"\n"), 0700)
+	t.Setenv("EDITOR", editor)
+
+	if err := secretEdit([]string{f}); err != nil {
+		t.Fatalf("secretEdit() error = %v", err)
+	}
+	got, err := encryption.Decrypt(f, []age.Identity{identity})
+	if err != nil || string(got) != "new" {
+		t.Errorf("Decrypt() after edit = %q, %v, want the edit encrypted to the same recipient", got, err)
+	}
+}
@@ -0,0 +1,310 @@
+package sshagent
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/nicknickel/gossh/internal/encryption"
+	"github.com/nicknickel/gossh/internal/log"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const defaultLifetime = 300
+
+// Keys added to a shared agent are reference counted so that concurrent
+// commands using the same identity don't remove it from under each other
+var (
+	loadedMu sync.Mutex
+	loaded   = make(map[string]int)
+)
+
+type Identity struct {
+	Socket    string
+	Ephemeral bool
+	Lifetime  uint32
+	// Present is set when the agent already held the key before gossh
+	// loaded it, so Close leaves it there
+	Present  bool
+	pubKey   ssh.PublicKey
+	client   agent.Agent
+	conn     net.Conn
+	listener net.Listener
+	dir      string
+}
+
+func GetLifetime() uint32 {
+	lifetime := uint32(defaultLifetime)
+
+	lifetimeEnv := os.Getenv("GOSSH_AGENT_LIFETIME")
+	if lifetimeEnv != "" {
+		parsed, err := strconv.ParseUint(lifetimeEnv, 10, 32)
+		if err == nil && parsed > 0 {
+			lifetime = uint32(parsed)
+		}
+	}
+
+	return lifetime
+}
+
+func decryptKey(encFile string) (any, error) {
+	if encFile == "" {
+		return nil, errors.New("no identity file indicated")
+	}
+
+	var contents string
+	if encryption.IsSecretRef(encFile) {
+		secret, err := encryption.GetSecret(encFile)
+		if err != nil {
+			return nil, err
+		}
+		contents = secret + "\n"
+	} else {
+		contents = encryption.GetEncryptedContents(encFile)
+	}
+	if contents == "" {
+		return nil, fmt.Errorf("could not decrypt identity file %v", encFile)
+	}
+
+	key, err := ssh.ParseRawPrivateKey([]byte(contents))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse identity file %v: %w", encFile, err)
+	}
+
+	return key, nil
+}
+
+func connectRunningAgent() (net.Conn, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, errors.New("SSH_AUTH_SOCK not set")
+	}
+
+	return net.Dial("unix", sock)
+}
+
+func (id *Identity) startEphemeral() error {
+	dir, err := os.MkdirTemp("", "gossh-agent-*")
+	if err != nil {
+		return err
+	}
+
+	sock := filepath.Join(dir, "agent.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	keyring := agent.NewKeyring()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				agent.ServeAgent(keyring, c)
+				c.Close()
+			}()
+		}
+	}()
+
+	id.Socket = sock
+	id.Ephemeral = true
+	id.client = keyring
+	id.listener = l
+	id.dir = dir
+	return nil
+}
+
+func (id *Identity) add(key any, encFile string) error {
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return err
+	}
+	id.pubKey = signer.PublicKey()
+
+	addedKey := agent.AddedKey{
+		PrivateKey:   key,
+		Comment:      "gossh:" + filepath.Base(encFile),
+		LifetimeSecs: id.Lifetime,
+	}
+
+	return id.client.Add(addedKey)
+}
+
+// LoadIdentity decrypts the identity file and adds it to the running ssh-agent
+// pointed to by SSH_AUTH_SOCK. When no agent is running, an ephemeral agent is
+// served by gossh until Close is called. The key is never written to disk.
+func LoadIdentity(encFile string) (*Identity, error) {
+	key, err := decryptKey(encFile)
+	if err != nil {
+		return nil, err
+	}
+
+	id := &Identity{Lifetime: GetLifetime()}
+
+	conn, err := connectRunningAgent()
+	if err == nil {
+		id.Socket = os.Getenv("SSH_AUTH_SOCK")
+		id.conn = conn
+		id.client = agent.NewClient(conn)
+	} else {
+		log.Logger.Debug("No running ssh-agent, starting ephemeral agent", "err", err)
+		if err := id.startEphemeral(); err != nil {
+			return nil, fmt.Errorf("could not start ephemeral agent: %w", err)
+		}
+	}
+
+	loadedMu.Lock()
+	defer loadedMu.Unlock()
+	if !id.Ephemeral {
+		present, err := id.holds(key)
+		if err != nil {
+			id.release()
+			return nil, fmt.Errorf("could not list agent keys: %w", err)
+		}
+		if present {
+			log.Logger.Debug("Identity already in ssh-agent, leaving it there", "file", encFile)
+			id.Present = true
+			return id, nil
+		}
+	}
+	if err := id.add(key, encFile); err != nil {
+		id.release()
+		return nil, fmt.Errorf("could not add identity to agent: %w", err)
+	}
+	if !id.Ephemeral {
+		loaded[string(id.pubKey.Marshal())]++
+	}
+
+	return id, nil
+}
+
+// holds reports whether the agent has the key without gossh having added it
+func (id *Identity) holds(key any) (bool, error) {
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return false, err
+	}
+	id.pubKey = signer.PublicKey()
+	k := string(id.pubKey.Marshal())
+	if loaded[k] > 0 {
+		return false, nil
+	}
+
+	keys, err := id.client.List()
+	if err != nil {
+		return false, err
+	}
+	for _, existing := range keys {
+		if string(existing.Marshal()) == k {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// AddToRunningAgent decrypts the identity file and leaves it in the running
+// ssh-agent until its lifetime expires. It returns the lifetime in seconds,
+// or 0 when the agent already held the key and it was left untouched.
+func AddToRunningAgent(encFile string) (uint32, error) {
+	key, err := decryptKey(encFile)
+	if err != nil {
+		return 0, err
+	}
+
+	conn, err := connectRunningAgent()
+	if err != nil {
+		return 0, fmt.Errorf("no running ssh-agent: %w", err)
+	}
+	defer conn.Close()
+
+	id := &Identity{Lifetime: GetLifetime(), client: agent.NewClient(conn)}
+	loadedMu.Lock()
+	defer loadedMu.Unlock()
+	present, err := id.holds(key)
+	if err != nil {
+		return 0, fmt.Errorf("could not list agent keys: %w", err)
+	}
+	if present {
+		return 0, nil
+	}
+	if err := id.add(key, encFile); err != nil {
+		return 0, fmt.Errorf("could not add identity to agent: %w", err)
+	}
+
+	return id.Lifetime, nil
+}
+
+// Env returns the environment needed for ssh to use the agent
+func (id *Identity) Env() []string {
+	return []string{"SSH_AUTH_SOCK=" + id.Socket}
+}
+
+func (id *Identity) release() {
+	if id.conn != nil {
+		id.conn.Close()
+	}
+	if id.listener != nil {
+		id.listener.Close()
+	}
+	if id.dir != "" {
+		os.RemoveAll(id.dir)
+	}
+}
+
+// Close removes the identity from the agent, or shuts down the ephemeral
+// agent. Keys the agent held before gossh loaded them are left in place.
+func (id *Identity) Close() error {
+	var err error
+
+	if !id.Ephemeral && !id.Present && id.pubKey != nil {
+		loadedMu.Lock()
+		k := string(id.pubKey.Marshal())
+		loaded[k]--
+		if loaded[k] <= 0 {
+			delete(loaded, k)
+			err = id.client.Remove(id.pubKey)
+		}
+		loadedMu.Unlock()
+	}
+
+	id.release()
+	return err
+}
+
+// Signer decrypts the identity in memory for native ssh connections. Identity
+// files that are not encrypted are parsed as they are.
+func Signer(file string) (ssh.Signer, error) {
+	key, err := decryptKey(file)
+	if err == nil {
+		return ssh.NewSignerFromKey(key)
+	}
+	if encryption.IsSecretRef(file) {
+		return nil, err
+	}
+
+	plain, readErr := os.ReadFile(file)
+	if readErr != nil {
+		return nil, readErr
+	}
+	return ssh.ParsePrivateKey(plain)
+}
+
+// AgentAuth authenticates with the keys held by the running ssh-agent. The
+// returned function closes the agent connection once the session is done.
+func AgentAuth() (ssh.AuthMethod, func(), error) {
+	conn, err := connectRunningAgent()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ssh.PublicKeysCallback(agent.NewClient(conn).Signers), func() { conn.Close() }, nil
+}
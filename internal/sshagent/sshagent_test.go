@@ -0,0 +1,223 @@
+package sshagent
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/charmbracelet/log"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	internal_log "github.com/nicknickel/gossh/internal/log"
+)
+
+func writeEncryptedKey(t *testing.T, passphrase string) string {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		t.Fatalf("Failed to create recipient: %v", err)
+	}
+	buf := new(bytes.Buffer)
+	w, err := age.Encrypt(buf, recipient)
+	if err != nil {
+		t.Fatalf("Failed to create encrypt writer: %v", err)
+	}
+	w.Write(pem.EncodeToMemory(block))
+	w.Close()
+
+	f := filepath.Join(t.TempDir(), "id_ed25519.age")
+	if err := os.WriteFile(f, buf.Bytes(), 0600); err != nil {
+		t.Fatalf("Failed to write encrypted key: %v", err)
+	}
+	return f
+}
+
+// serves a keyring on a unix socket to stand in for a running ssh-agent
+func startTestAgent(t *testing.T) agent.Agent {
+	keyring := agent.NewKeyring()
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("Failed to listen on %v: %v", sock, err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, c)
+		}
+	}()
+
+	t.Setenv("SSH_AUTH_SOCK", sock)
+	return keyring
+}
+
+func TestGetLifetime(t *testing.T) {
+	tests := []struct {
+		name     string
+		envValue string
+		expected uint32
+	}{
+		{name: "not set", envValue: "", expected: defaultLifetime},
+		{name: "valid", envValue: "60", expected: 60},
+		{name: "invalid", envValue: "abc", expected: defaultLifetime},
+		{name: "zero", envValue: "0", expected: defaultLifetime},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GOSSH_AGENT_LIFETIME", tt.envValue)
+			if got := GetLifetime(); got != tt.expected {
+				t.Errorf("GetLifetime() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLoadIdentity_Ephemeral(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	t.Setenv("GOSSH_PASSPHRASE", "testpass")
+	t.Setenv("SSH_AUTH_SOCK", "")
+	keyFile := writeEncryptedKey(t, "testpass")
+
+	id, err := LoadIdentity(keyFile)
+	if err != nil {
+		t.Fatalf("LoadIdentity() error = %v", err)
+	}
+	if !id.Ephemeral {
+		t.Errorf("LoadIdentity() expected ephemeral agent")
+	}
+
+	conn, err := net.Dial("unix", id.Socket)
+	if err != nil {
+		t.Fatalf("Could not dial ephemeral agent: %v", err)
+	}
+	keys, err := agent.NewClient(conn).List()
+	conn.Close()
+	if err != nil || len(keys) != 1 {
+		t.Errorf("ephemeral agent keys = %v (err %v), want 1 key", len(keys), err)
+	}
+
+	id.Close()
+	if _, err := os.Stat(filepath.Dir(id.Socket)); !os.IsNotExist(err) {
+		t.Errorf("ephemeral agent directory not removed: %v", err)
+	}
+}
+
+func TestLoadIdentity_RunningAgent(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	t.Setenv("GOSSH_PASSPHRASE", "testpass")
+	keyring := startTestAgent(t)
+	keyFile := writeEncryptedKey(t, "testpass")
+
+	first, err := LoadIdentity(keyFile)
+	if err != nil {
+		t.Fatalf("LoadIdentity() error = %v", err)
+	}
+	second, err := LoadIdentity(keyFile)
+	if err != nil {
+		t.Fatalf("LoadIdentity() error = %v", err)
+	}
+	if first.Ephemeral {
+		t.Errorf("LoadIdentity() used ephemeral agent with SSH_AUTH_SOCK set")
+	}
+
+	// key must stay loaded until the last user closes it
+	first.Close()
+	if keys, _ := keyring.List(); len(keys) != 1 {
+		t.Errorf("agent keys after first Close() = %v, want 1", len(keys))
+	}
+	second.Close()
+	if keys, _ := keyring.List(); len(keys) != 0 {
+		t.Errorf("agent keys after second Close() = %v, want 0", len(keys))
+	}
+}
+
+func TestLoadIdentity_AlreadyInAgent(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	t.Setenv("GOSSH_PASSPHRASE", "testpass")
+	keyring := startTestAgent(t)
+	keyFile := writeEncryptedKey(t, "testpass")
+
+	key, err := decryptKey(keyFile)
+	if err != nil {
+		t.Fatalf("decryptKey() error = %v", err)
+	}
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+		t.Fatalf("Failed to add key to agent: %v", err)
+	}
+
+	id, err := LoadIdentity(keyFile)
+	if err != nil {
+		t.Fatalf("LoadIdentity() error = %v", err)
+	}
+	if !id.Present {
+		t.Errorf("LoadIdentity() did not notice the key already in the agent")
+	}
+	id.Close()
+	if keys, _ := keyring.List(); len(keys) != 1 {
+		t.Errorf("agent keys after Close() = %v, want the user's key kept", len(keys))
+	}
+
+	if lifetime, err := AddToRunningAgent(keyFile); err != nil || lifetime != 0 {
+		t.Errorf("AddToRunningAgent() = %v, %v, want 0 for a key already loaded", lifetime, err)
+	}
+}
+
+func TestAddToRunningAgent(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	t.Setenv("GOSSH_PASSPHRASE", "testpass")
+	t.Setenv("GOSSH_AGENT_LIFETIME", "60")
+	keyFile := writeEncryptedKey(t, "testpass")
+
+	t.Setenv("SSH_AUTH_SOCK", "")
+	if _, err := AddToRunningAgent(keyFile); err == nil {
+		t.Errorf("AddToRunningAgent() without agent expected error")
+	}
+
+	keyring := startTestAgent(t)
+	lifetime, err := AddToRunningAgent(keyFile)
+	if err != nil {
+		t.Fatalf("AddToRunningAgent() error = %v", err)
+	}
+	if lifetime != 60 {
+		t.Errorf("AddToRunningAgent() lifetime = %v, want 60", lifetime)
+	}
+	if keys, _ := keyring.List(); len(keys) != 1 {
+		t.Errorf("agent keys = %v, want 1", len(keys))
+	}
+}
+
+func TestLoadIdentity_Errors(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	t.Setenv("GOSSH_PASSPHRASE", "wrong")
+	t.Setenv("SSH_AUTH_SOCK", "")
+	keyFile := writeEncryptedKey(t, "testpass")
+
+	for _, f := range []string{"", "nonexistent", keyFile} {
+		if id, err := LoadIdentity(f); err == nil {
+			id.Close()
+			t.Errorf("LoadIdentity(%q) expected error", f)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package sshconfig
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/log"
+)
+
+// Config is how ssh resolves a connection with the user's ssh config
+type Config struct {
+	Hostname      string
+	Port          string
+	User          string
+	ProxyJump     []string
+	Proxy         string
+	IdentityFiles []string
+}
+
+// Parse reads the output of ssh -G
+func Parse(out string) Config {
+	var cfg Config
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		switch strings.ToLower(key) {
+		case "hostname":
+			cfg.Hostname = value
+		case "port":
+			cfg.Port = value
+		case "user":
+			cfg.User = value
+		case "proxyjump":
+			if value != "none" {
+				cfg.ProxyJump = strings.Split(value, ",")
+			}
+		case "proxycommand":
+			if value != "none" {
+				cfg.Proxy = value
+			}
+		case "identityfile":
+			cfg.IdentityFiles = append(cfg.IdentityFiles, expandHome(value))
+		}
+	}
+	return cfg
+}
+
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
+
+// Args are the ssh -G arguments for the connection. The port is only passed
+// when the address has one so a Port from the ssh config applies otherwise.
+func Args(i connection.Item) []string {
+	args := []string{"-G"}
+	if i.Conn.User != "" {
+		args = append(args, "-l", i.Conn.User)
+	}
+	host := i.Conn.Address
+	if host == "" {
+		host = i.Name
+	}
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		host = h
+		args = append(args, "-p", port)
+	}
+	return append(args, host)
+}
+
+var (
+	resolved   = map[string]Config{}
+	resolvedMu sync.Mutex
+)
+
+// Resolve asks ssh how it would connect, without connecting. Results are kept
+// for the life of the process.
+func Resolve(i connection.Item) (Config, error) {
+	args := Args(i)
+	key := strings.Join(args, " ")
+	resolvedMu.Lock()
+	cfg, ok := resolved[key]
+	resolvedMu.Unlock()
+	if ok {
+		return cfg, nil
+	}
+
+	out, err := exec.Command("ssh", args...).Output()
+	if err != nil {
+		return Config{}, err
+	}
+	cfg = Parse(string(out))
+	resolvedMu.Lock()
+	resolved[key] = cfg
+	resolvedMu.Unlock()
+	return cfg, nil
+}
+
+// Lookup is Resolve, falling back to the connection's own address and user
+// when ssh can not resolve it
+func Lookup(i connection.Item) Config {
+	cfg, err := Resolve(i)
+	if err == nil && cfg.Hostname != "" && cfg.Port != "" {
+		return cfg
+	}
+	log.Logger.Debug("Could not resolve connection with ssh -G", "connection", i.Name, "err", err)
+	host, port, _ := net.SplitHostPort(i.HostPort())
+	return Config{Hostname: host, Port: port, User: i.Conn.User}
+}
+
+// Addr is the host and port ssh connects to
+func (c Config) Addr() string {
+	return net.JoinHostPort(c.Hostname, c.Port)
+}
+
+// Proxied reports whether ssh reaches the host through a proxy
+func (c Config) Proxied() bool {
+	return c.Proxy != "" || len(c.ProxyJump) > 0
+}
+
+// expandTokens replaces the ProxyCommand tokens ssh would
+func (c Config) expandTokens(s string) string {
+	return strings.NewReplacer("%%", "%", "%h", c.Hostname, "%p", c.Port, "%r", c.User).Replace(s)
+}
+
+// Dial connects to the host the way ssh would: through its ProxyCommand or
+// ProxyJump hosts, otherwise directly
+func (c Config) Dial(timeout time.Duration) (net.Conn, error) {
+	var cmd *exec.Cmd
+	switch {
+	case c.Proxy != "":
+		cmd = exec.Command("sh", "-c", c.expandTokens(c.Proxy))
+	case len(c.ProxyJump) > 0:
+		// like ssh, the last jump forwards to the host through the others
+		last := len(c.ProxyJump) - 1
+		args := []string{"-o", "BatchMode=yes", "-W", c.Addr()}
+		if timeout > 0 {
+			args = append(args, "-o", fmt.Sprintf("ConnectTimeout=%v", int(timeout.Seconds())))
+		}
+		if last > 0 {
+			args = append(args, "-J", strings.Join(c.ProxyJump[:last], ","))
+		}
+		cmd = exec.Command("ssh", append(args, c.ProxyJump[last])...)
+	default:
+		return net.DialTimeout("tcp", c.Addr(), timeout)
+	}
+	return commandConn(cmd, c.Addr())
+}
+
+// cmdConn is a connection over the stdin and stdout of a proxy command
+type cmdConn struct {
+	io.Reader
+	io.WriteCloser
+	cmd    *exec.Cmd
+	addr   string
+	closed sync.Once
+}
+
+func commandConn(cmd *exec.Cmd, addr string) (net.Conn, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not start proxy: %w", err)
+	}
+	return &cmdConn{Reader: stdout, WriteCloser: stdin, cmd: cmd, addr: addr}, nil
+}
+
+func (c *cmdConn) Close() error {
+	c.closed.Do(func() {
+		c.WriteCloser.Close()
+		c.cmd.Process.Kill()
+		c.cmd.Wait()
+	})
+	return nil
+}
+
+type proxyAddr string
+
+func (a proxyAddr) Network() string { return "proxy" }
+func (a proxyAddr) String() string  { return string(a) }
+
+func (c *cmdConn) LocalAddr() net.Addr                { return proxyAddr("local") }
+func (c *cmdConn) RemoteAddr() net.Addr               { return proxyAddr(c.addr) }
+func (c *cmdConn) SetDeadline(t time.Time) error      { return nil }
+func (c *cmdConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *cmdConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,73 @@
+package sshconfig
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/nicknickel/gossh/internal/connection"
+	internal_log "github.com/nicknickel/gossh/internal/log"
+)
+
+func TestParse(t *testing.T) {
+	home, _ := os.UserHomeDir()
+	out := "user ops\nhostname 10.0.0.5\nport 2222\nproxyjump bastion,ops@jump2:2200\nproxycommand none\n" +
+		"identityfile ~/.ssh/id_ed25519\nidentityfile /keys/web\n"
+	cfg := Parse(out)
+	if cfg.Hostname != "10.0.0.5" || cfg.Port != "2222" || cfg.User != "ops" || cfg.Proxy != "" ||
+		!slices.Equal(cfg.ProxyJump, []string{"bastion", "ops@jump2:2200"}) ||
+		!slices.Equal(cfg.IdentityFiles, []string{filepath.Join(home, ".ssh/id_ed25519"), "/keys/web"}) {
+		t.Errorf("Parse() = %+v", cfg)
+	}
+	if cfg := Parse("hostname web1\nport 22\n"); cfg.ProxyJump != nil || cfg.Addr() != "web1:22" {
+		t.Errorf("Parse() without a jump = %+v", cfg)
+	}
+}
+
+func TestArgs(t *testing.T) {
+	tests := []struct {
+		conn     connection.Connection
+		expected []string
+	}{
+		{conn: connection.Connection{}, expected: []string{"-G", "web1"}},
+		{conn: connection.Connection{User: "ops", Address: "10.0.0.5"}, expected: []string{"-G", "-l", "ops", "10.0.0.5"}},
+		{conn: connection.Connection{Address: "10.0.0.5:2222"}, expected: []string{"-G", "-p", "2222", "10.0.0.5"}},
+		{conn: connection.Connection{Address: "[fe80::1]:2222"}, expected: []string{"-G", "-p", "2222", "fe80::1"}},
+	}
+	for _, tt := range tests {
+		if got := Args(connection.Item{Name: "web1", Conn: tt.conn}); !slices.Equal(got, tt.expected) {
+			t.Errorf("Args(%+v) = %q, want %q", tt.conn, got, tt.expected)
+		}
+	}
+}
+
+func TestLookup_Fallback(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	t.Setenv("PATH", t.TempDir())
+	cfg := Lookup(connection.Item{Name: "web1", Conn: connection.Connection{User: "ops", Address: "10.0.0.5:2222"}})
+	if cfg.Addr() != "10.0.0.5:2222" || cfg.User != "ops" {
+		t.Errorf("Lookup() without ssh = %+v", cfg)
+	}
+}
+
+func TestDial_ProxyCommand(t *testing.T) {
+	// the proxy echoes back what is sent, after the expanded tokens
+	cfg := Config{Hostname: "web1", Port: "2222", User: "ops", Proxy: "echo %r@%h:%p; cat"}
+	conn, err := cfg.Dial(time.Second)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().String() != "web1:2222" {
+		t.Errorf("RemoteAddr() = %v", conn.RemoteAddr())
+	}
+	conn.Write([]byte("hello"))
+	b := make([]byte, len("ops@web1:2222\nhello"))
+	if _, err := io.ReadFull(conn, b); err != nil || string(b) != "ops@web1:2222\nhello" {
+		t.Errorf("read %q, %v", b, err)
+	}
+}
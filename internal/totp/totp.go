@@ -0,0 +1,127 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nicknickel/gossh/internal/encryption"
+)
+
+type Key struct {
+	Secret    []byte
+	Digits    int
+	Period    int
+	Algorithm func() hash.Hash
+}
+
+// ParseKey accepts a base32 seed or an otpauth://totp/ URI as exported by
+// most authenticator apps
+func ParseKey(s string) (Key, error) {
+	k := Key{Digits: 6, Period: 30, Algorithm: sha1.New}
+	s = strings.TrimSpace(s)
+	seed := s
+
+	if strings.HasPrefix(s, "otpauth://") {
+		u, err := url.Parse(s)
+		if err != nil {
+			return k, err
+		}
+		if u.Host != "totp" {
+			return k, fmt.Errorf("unsupported otpauth type %v", u.Host)
+		}
+		q := u.Query()
+		seed = q.Get("secret")
+		if d := q.Get("digits"); d != "" {
+			k.Digits, err = strconv.Atoi(d)
+			if err != nil || k.Digits < 6 || k.Digits > 8 {
+				return k, fmt.Errorf("invalid digits %v", d)
+			}
+		}
+		if p := q.Get("period"); p != "" {
+			k.Period, err = strconv.Atoi(p)
+			if err != nil || k.Period <= 0 {
+				return k, fmt.Errorf("invalid period %v", p)
+			}
+		}
+		switch strings.ToUpper(q.Get("algorithm")) {
+		case "", "SHA1":
+		case "SHA256":
+			k.Algorithm = sha256.New
+		case "SHA512":
+			k.Algorithm = sha512.New
+		default:
+			return k, fmt.Errorf("unsupported algorithm %v", q.Get("algorithm"))
+		}
+	}
+
+	seed = strings.ToUpper(strings.ReplaceAll(seed, " ", ""))
+	seed = strings.TrimRight(seed, "=")
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(seed)
+	if err != nil {
+		return k, fmt.Errorf("invalid base32 seed: %w", err)
+	}
+	if len(secret) == 0 {
+		return k, errors.New("empty seed")
+	}
+	k.Secret = secret
+
+	return k, nil
+}
+
+// Code returns the RFC 6238 code for t and the seconds it remains valid
+func (k Key) Code(t time.Time) (string, int) {
+	counter := uint64(t.Unix()) / uint64(k.Period)
+	remaining := k.Period - int(t.Unix()%int64(k.Period))
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+	mac := hmac.New(k.Algorithm, k.Secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for range k.Digits {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", k.Digits, value%mod), remaining
+}
+
+// GetCode decrypts the seed referenced by the connection totp key, which can
+// be an age encrypted file or a secret provider reference
+func GetCode(seedFile string) (string, int, error) {
+	var seed string
+	if encryption.IsSecretRef(seedFile) {
+		s, err := encryption.GetSecret(seedFile)
+		if err != nil {
+			return "", 0, err
+		}
+		seed = s
+	} else {
+		seed = encryption.GetEncryptedContents(seedFile)
+	}
+	if seed == "" {
+		return "", 0, fmt.Errorf("could not decrypt totp seed %v", seedFile)
+	}
+
+	k, err := ParseKey(seed)
+	if err != nil {
+		return "", 0, err
+	}
+
+	code, remaining := k.Code(time.Now())
+	return code, remaining, nil
+}
@@ -0,0 +1,66 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// Test vectors from RFC 6238 appendix B (truncated to 6 digits unless noted)
+func TestKey_Code(t *testing.T) {
+	// "12345678901234567890" in base32
+	sha1Seed := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	sha256URI := "otpauth://totp/test?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZA&algorithm=SHA256&digits=8"
+
+	tests := []struct {
+		name     string
+		key      string
+		time     int64
+		expected string
+	}{
+		{name: "sha1 59", key: sha1Seed, time: 59, expected: "287082"},
+		{name: "sha1 1111111109", key: sha1Seed, time: 1111111109, expected: "081804"},
+		{name: "sha1 lowercase with spaces", key: "gezd gnbv gy3t qojq gezd gnbv gy3t qojq", time: 1234567890, expected: "005924"},
+		{name: "sha256 uri 8 digits", key: sha256URI, time: 59, expected: "46119246"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := ParseKey(tt.key)
+			if err != nil {
+				t.Fatalf("ParseKey() error = %v", err)
+			}
+			got, _ := k.Code(time.Unix(tt.time, 0))
+			if got != tt.expected {
+				t.Errorf("Code() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestKey_CodeRemaining(t *testing.T) {
+	k, _ := ParseKey("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+	if _, remaining := k.Code(time.Unix(59, 0)); remaining != 1 {
+		t.Errorf("Code() remaining = %v, want 1", remaining)
+	}
+	if _, remaining := k.Code(time.Unix(60, 0)); remaining != 30 {
+		t.Errorf("Code() remaining = %v, want 30", remaining)
+	}
+}
+
+func TestParseKey_Errors(t *testing.T) {
+	for _, key := range []string{
+		"",
+		"not base32!",
+		"otpauth://hotp/test?secret=GEZDGNBV",
+		"otpauth://totp/test?secret=GEZDGNBV&algorithm=MD5",
+		"otpauth://totp/test?secret=GEZDGNBV&period=0",
+		"otpauth://totp/test?secret=GEZDGNBV&digits=0",
+		"otpauth://totp/test?secret=GEZDGNBV&digits=-6",
+		"otpauth://totp/test?secret=GEZDGNBV&digits=5",
+		"otpauth://totp/test?secret=GEZDGNBV&digits=10",
+	} {
+		if _, err := ParseKey(key); err == nil {
+			t.Errorf("ParseKey(%q) expected error", key)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package transfer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/hostkey"
+	"github.com/nicknickel/gossh/internal/log"
+	"github.com/nicknickel/gossh/internal/runcommand"
+	"github.com/nicknickel/gossh/internal/sshagent"
+	"github.com/nicknickel/gossh/internal/sshconfig"
+	"github.com/nicknickel/gossh/internal/totp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func User(i connection.Item) string {
+	if i.Conn.User != "" {
+		return i.Conn.User
+	}
+	u, err := user.Current()
+	if err != nil {
+		return ""
+	}
+	return u.Username
+}
+
+// keyboardInteractive answers password and TOTP prompts the same way the pty
+// engine does for the ssh command
+func keyboardInteractive(i connection.Item, pw string) ssh.KeyboardInteractiveChallenge {
+	passwordPrompt := runcommand.PasswordPrompt()
+	totpPrompt := runcommand.TotpPrompt()
+
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for ind, q := range questions {
+			switch {
+			case i.Conn.Totp != "" && totpPrompt.MatchString(q):
+				code, _, err := totp.GetCode(i.Conn.Totp)
+				if err != nil {
+					return nil, err
+				}
+				answers[ind] = code
+			case pw != "" && passwordPrompt.MatchString(q):
+				answers[ind] = pw
+			default:
+				return nil, fmt.Errorf("no answer for prompt %q", q)
+			}
+		}
+		return answers, nil
+	}
+}
+
+// AuthMethods resolves authentication like PrepareCommand: a password from
+// the provider or passfile is used instead of the identity, which is only used
+// without one, and TOTP codes answer keyboard-interactive prompts. Keys in the
+// running ssh-agent and the identity files of the ssh config are tried too,
+// as ssh does.
+func AuthMethods(i connection.Item, identityFiles []string) ([]ssh.AuthMethod, func()) {
+	var methods []ssh.AuthMethod
+	cleanup := func() {}
+
+	pw := ""
+	if i.Conn.Password != "" || i.Conn.PassFile != "" {
+		var err error
+		pw, err = runcommand.GetPassword(&i)
+		if err != nil {
+			log.Logger.Debug("Could not get password", "connection", i.Name, "err", err)
+		}
+	}
+
+	// totp logins answer every prompt on a pty and also use the identity
+	if i.Conn.IdentityFile != "" && (pw == "" || i.Conn.Totp != "") {
+		signer, err := sshagent.Signer(i.Conn.IdentityFile)
+		if err == nil {
+			methods = append(methods, ssh.PublicKeys(signer))
+		} else {
+			log.Logger.Debug("Could not load identity", "file", i.Conn.IdentityFile, "err", err)
+		}
+	}
+
+	agentAuth, agentClose, err := sshagent.AgentAuth()
+	if err == nil {
+		methods = append(methods, agentAuth)
+		cleanup = agentClose
+	}
+
+	var signers []ssh.Signer
+	for _, file := range identityFiles {
+		if b, err := os.ReadFile(file); err == nil {
+			if signer, err := ssh.ParsePrivateKey(b); err == nil {
+				signers = append(signers, signer)
+			}
+		}
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+
+	if pw != "" {
+		methods = append(methods, ssh.Password(pw))
+	}
+	if pw != "" || i.Conn.Totp != "" {
+		methods = append(methods, ssh.KeyboardInteractive(keyboardInteractive(i, pw)))
+	}
+
+	return methods, cleanup
+}
+
+// dialTimeout bounds connecting and the ssh handshake, like the
+// ConnectTimeout=10 gossh passes to ssh. It is replaced in tests.
+var dialTimeout = 10 * time.Second
+
+// Dial connects to the connection the way ssh would, with the host name,
+// port, user, jump hosts and identities of the ssh config, verifying the host
+// key against its pin or the known_hosts files
+func Dial(i connection.Item) (*ssh.Client, error) {
+	hostKeyCallback, err := hostkey.Callback(i)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := sshconfig.Lookup(i)
+	methods, cleanup := AuthMethods(i, cfg.IdentityFiles)
+	defer cleanup()
+	if len(methods) == 0 {
+		return nil, errors.New("no authentication methods available")
+	}
+
+	user := cfg.User
+	if user == "" {
+		user = User(i)
+	}
+	config := &ssh.ClientConfig{
+		User:            user,
+		Auth:            methods,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         dialTimeout,
+	}
+
+	addr := cfg.Addr()
+	conn, err := cfg.Dial(dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	// proxy connections ignore deadlines, so the handshake is ended by closing
+	timer := time.AfterFunc(dialTimeout, func() { conn.Close() })
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if !timer.Stop() {
+		if err == nil {
+			c.Close()
+		}
+		return nil, fmt.Errorf("timed out connecting to %v", addr)
+	}
+	if err != nil {
+		conn.Close()
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) && len(keyErr.Want) == 0 {
+			return nil, fmt.Errorf("host key for %v is not known, run gossh keyscan or connect with ssh once to accept it", addr)
+		}
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
@@ -0,0 +1,235 @@
+package transfer
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/runcommand"
+	"github.com/pkg/sftp"
+)
+
+// SplitSources splits typed sources on whitespace. Quotes group words and a
+// backslash escapes whitespace, quotes and glob characters; escaped glob
+// characters stay escaped so they match literally. Other backslashes are kept
+// as they are, so Windows paths can be typed.
+func SplitSources(s string) []string {
+	var sources []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+
+	runes := []rune(s)
+	for ind := 0; ind < len(runes); ind++ {
+		r := runes[ind]
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '\'' || r == '"'):
+			quote, inWord = r, true
+		case r == '\\' && quote != '\'' && ind+1 < len(runes) && strings.ContainsRune(" \t'\"\\*?[]", runes[ind+1]):
+			ind++
+			if strings.ContainsRune(globChars, runes[ind]) {
+				word.WriteRune('\\')
+			}
+			word.WriteRune(runes[ind])
+			inWord = true
+		case quote == 0 && (r == ' ' || r == '\t' || r == '\n'):
+			if inWord {
+				sources = append(sources, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		sources = append(sources, word.String())
+	}
+	return sources
+}
+
+// globChars are escaped with a backslash to match literally
+const globChars = "*?[]\\"
+
+// HasGlob reports whether p contains glob characters
+func HasGlob(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// unescape removes the escapes SplitSources keeps for literal glob characters
+func unescape(p string) string {
+	var b strings.Builder
+	for ind := 0; ind < len(p); ind++ {
+		if p[ind] == '\\' && ind+1 < len(p) && strings.ContainsRune(globChars, rune(p[ind+1])) {
+			ind++
+		}
+		b.WriteByte(p[ind])
+	}
+	return b.String()
+}
+
+// expand expands the glob sources with glob, keeping the order they were
+// given in and dropping duplicates
+func expand(srcs []string, where string, glob func(string) ([]string, error)) ([]string, error) {
+	var expanded []string
+	for _, src := range srcs {
+		if !HasGlob(src) {
+			expanded = append(expanded, unescape(src))
+			continue
+		}
+		matches, err := glob(src)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %v: %w", src, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%v matches no %v files", src, where)
+		}
+		slices.Sort(matches)
+		expanded = append(expanded, matches...)
+	}
+
+	var unique []string
+	for _, p := range expanded {
+		if !slices.Contains(unique, p) {
+			unique = append(unique, p)
+		}
+	}
+	return unique, nil
+}
+
+// ExpandLocal expands glob sources on the local machine
+func ExpandLocal(srcs []string) ([]string, error) {
+	return expand(srcs, "local", filepath.Glob)
+}
+
+// ExpandRemote expands glob sources on the remote host by listing its
+// directories over SFTP, so no remote shell sees the patterns. A leading ~/
+// is the remote home directory.
+func ExpandRemote(c *sftp.Client, srcs []string) ([]string, error) {
+	home := ""
+	var rooted []string
+	for _, src := range srcs {
+		if src == "~" || strings.HasPrefix(src, "~/") {
+			if home == "" {
+				wd, err := c.Getwd()
+				if err != nil {
+					return nil, err
+				}
+				home = wd
+			}
+			src = path.Join(home, src[1:])
+		}
+		rooted = append(rooted, src)
+	}
+	return expand(rooted, "remote", c.Glob)
+}
+
+// PlanSend returns a job for every connection, with the total size to copy.
+// When globs is set the sources are patterns expanded on the local machine,
+// otherwise they are used as they are.
+func PlanSend(items []connection.Item, srcs []string, dest string, globs bool) ([]*Job, error) {
+	expanded := srcs
+	if globs {
+		var err error
+		if expanded, err = ExpandLocal(srcs); err != nil {
+			return nil, err
+		}
+	}
+
+	var total int64
+	for _, src := range expanded {
+		size, err := LocalSize(src)
+		if err != nil {
+			return nil, err
+		}
+		total += size
+	}
+
+	// a pattern names files to copy into dest even when it matches only one
+	paths := SendPaths(expanded, dest)
+	if globs && len(expanded) == 1 && HasGlob(srcs[0]) {
+		paths = []Path{{Src: expanded[0], Dest: path.Join(dest, filepath.Base(expanded[0]))}}
+	}
+
+	var jobs []*Job
+	for _, item := range items {
+		job := NewMultiJob(item, Send, paths)
+		job.total.Store(total)
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// expandHost expands srcs on the job's host, when they are globs, and records
+// the size to copy
+func (j *Job) expandHost(srcs []string, globs bool) ([]string, error) {
+	client, err := Dial(j.Item)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	c, err := sftp.NewClient(client)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	expanded := srcs
+	if globs {
+		if expanded, err = ExpandRemote(c, srcs); err != nil {
+			return nil, err
+		}
+	}
+	var total int64
+	for _, src := range expanded {
+		size, err := RemoteSize(c, src)
+		if err != nil {
+			return nil, err
+		}
+		total += size
+	}
+	j.total.Store(total)
+	return expanded, nil
+}
+
+// PlanReceive expands the sources on every connection concurrently, when
+// globs is set, and names the received files with layout. Jobs for hosts that
+// could not be reached or expanded have Err set and should not be run.
+func PlanReceive(items []connection.Item, srcs []string, dest string, layout string, globs bool, now time.Time) ([]*Job, []string, error) {
+	if _, err := ParseLayout(layout); err != nil {
+		return nil, nil, err
+	}
+
+	jobs := make([]*Job, len(items))
+	expanded := make([][]string, len(items))
+	var wg sync.WaitGroup
+	limiter := make(chan int, runcommand.GetConcurrency())
+
+	for ind, item := range items {
+		jobs[ind] = NewMultiJob(item, Receive, nil)
+		wg.Go(func() {
+			limiter <- 1
+			expanded[ind], jobs[ind].Err = jobs[ind].expandHost(srcs, globs)
+			<-limiter
+		})
+	}
+	wg.Wait()
+
+	paths, warnings, err := ReceiveLayout(items, expanded, dest, layout, now)
+	if err != nil {
+		return nil, warnings, err
+	}
+	for ind, job := range jobs {
+		job.Paths = paths[ind]
+	}
+	return jobs, warnings, nil
+}
@@ -0,0 +1,124 @@
+package transfer
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/nicknickel/gossh/internal/connection"
+	internal_log "github.com/nicknickel/gossh/internal/log"
+)
+
+func TestSplitSources(t *testing.T) {
+	tests := map[string][]string{
+		"":                          nil,
+		"a.txt":                     {"a.txt"},
+		"  a.txt   /var/log/*.gz ":  {"a.txt", "/var/log/*.gz"},
+		`"my file" 'it''s'`:         {"my file", "its"},
+		`my\ file`:                  {"my file"},
+		`lit\*eral`:                 {`lit\*eral`},
+		`C:\Users\me\a.txt`:         {`C:\Users\me\a.txt`},
+		`'single \ quoted' "\"q\""`: {`single \ quoted`, `"q"`},
+	}
+	for s, expected := range tests {
+		if got := SplitSources(s); !slices.Equal(got, expected) {
+			t.Errorf("SplitSources(%q) = %q, want %q", s, got, expected)
+		}
+	}
+}
+
+func TestExpandLocal(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.log", "a.log", "c.txt", "lit*.log"} {
+		os.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+	}
+
+	got, err := ExpandLocal([]string{filepath.Join(dir, "c.txt"), filepath.Join(dir, "?.log"), filepath.Join(dir, "a.*")})
+	want := []string{filepath.Join(dir, "c.txt"), filepath.Join(dir, "a.log"), filepath.Join(dir, "b.log")}
+	if err != nil || !slices.Equal(got, want) {
+		t.Errorf("ExpandLocal() = %v, %v, want %v", got, err, want)
+	}
+
+	got, err = ExpandLocal(SplitSources(filepath.Join(dir, `lit\*.log`)))
+	if err != nil || !slices.Equal(got, []string{filepath.Join(dir, "lit*.log")}) {
+		t.Errorf("escaped glob = %v, %v", got, err)
+	}
+
+	if _, err := ExpandLocal([]string{filepath.Join(dir, "*.gz")}); err == nil || !strings.Contains(err.Error(), "matches no local files") {
+		t.Errorf("unmatched glob err = %v", err)
+	}
+}
+
+func TestPlanSend(t *testing.T) {
+	local := t.TempDir()
+	writeTree(t, local)
+	items := []connection.Item{{Name: "web1"}, {Name: "web2"}}
+
+	jobs, err := PlanSend(items, []string{filepath.Join(local, "*.txt")}, "/srv", true)
+	if err != nil || len(jobs) != 2 {
+		t.Fatalf("PlanSend() = %v, %v", jobs, err)
+	}
+	if !slices.Equal(jobs[1].Paths, []Path{{Src: filepath.Join(local, "a.txt"), Dest: "/srv/a.txt"}}) {
+		t.Errorf("a single match should be copied into dest, paths = %v", jobs[1].Paths)
+	}
+	if _, total := jobs[0].Progress(); total != 5 {
+		t.Errorf("planned size = %v, want 5", total)
+	}
+
+	// sources picked in the browser are not patterns
+	literal := filepath.Join(local, "[a].txt")
+	os.WriteFile(literal, []byte("literal"), 0644)
+	jobs, err = PlanSend(items, []string{literal}, "/srv", false)
+	if err != nil || !slices.Equal(jobs[0].Paths, []Path{{Src: literal, Dest: "/srv"}}) {
+		t.Errorf("PlanSend() without globs should not expand, paths = %v, err = %v", jobs[0].Paths, err)
+	}
+	if _, err := PlanSend(items, []string{filepath.Join(local, "*.gz")}, "/srv", true); err == nil {
+		t.Errorf("PlanSend() with an unmatched glob should fail")
+	}
+}
+
+func TestPlanReceive(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	addr := startServer(t, "hunter2")
+	web1 := connection.Item{Name: "web1", Conn: connection.Connection{Address: addr, Password: "exec:echo hunter2"}}
+	web2 := connection.Item{Name: "web2", Conn: connection.Connection{Address: addr, Password: "exec:echo hunter2"}}
+	wrong := connection.Item{Name: "wrong", Conn: connection.Connection{Address: addr, Password: "exec:echo wrong"}}
+
+	remote := t.TempDir()
+	writeTree(t, remote)
+	os.WriteFile(filepath.Join(remote, "sub", "it's $(id).txt"), []byte("x"), 0644)
+
+	jobs, warnings, err := PlanReceive([]connection.Item{web1, web2, wrong}, []string{filepath.Join(remote, "sub", "*.txt")}, "out", "dir", true, time.Now())
+	if err != nil || len(warnings) != 0 {
+		t.Fatalf("PlanReceive() err = %v, warnings = %v", err, warnings)
+	}
+	want := []Path{
+		{Src: filepath.Join(remote, "sub", "b.txt"), Dest: filepath.Join("out", "web1", "b.txt")},
+		{Src: filepath.Join(remote, "sub", "it's $(id).txt"), Dest: filepath.Join("out", "web1", "it's $(id).txt")},
+	}
+	if jobs[0].Err != nil || !slices.Equal(jobs[0].Paths, want) {
+		t.Errorf("web1 job err = %v, paths = %v", jobs[0].Err, jobs[0].Paths)
+	}
+	if _, total := jobs[1].Progress(); jobs[1].Err != nil || total != 100001 {
+		t.Errorf("web2 job err = %v, planned size = %v", jobs[1].Err, total)
+	}
+	if jobs[2].Err == nil || len(jobs[2].Paths) != 0 {
+		t.Errorf("unreachable host should have an error and no paths")
+	}
+
+	jobs, _, err = PlanReceive([]connection.Item{web1}, []string{filepath.Join(remote, "*.gz")}, "out", "dir", true, time.Now())
+	if err != nil || jobs[0].Err == nil || !strings.Contains(jobs[0].Err.Error(), "matches no remote files") {
+		t.Errorf("unmatched remote glob err = %v, job err = %v", err, jobs[0].Err)
+	}
+
+	// a file matched twice is only copied once
+	jobs, _, err = PlanReceive([]connection.Item{web1}, []string{filepath.Join(remote, "*", "*", "*.sh"), filepath.Join(remote, "sub", "deep", "c.sh")}, "out", "suffix", true, time.Now())
+	if err != nil || jobs[0].Err != nil || len(jobs[0].Paths) != 1 {
+		t.Errorf("PlanReceive() err = %v, job err = %v, paths = %v", err, jobs[0].Err, jobs[0].Paths)
+	}
+}
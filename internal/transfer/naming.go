@@ -0,0 +1,160 @@
+package transfer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/nicknickel/gossh/internal/connection"
+)
+
+// Layouts are the named receive layouts. Anything else is used as a template.
+var Layouts = map[string]string{
+	"suffix": "{{.Base}}_{{.Host}}{{.Ext}}",
+	"dir":    "{{.Host}}/{{.Name}}",
+	"prefix": "{{.Host}}_{{.Name}}",
+	"dated":  "{{.Host}}/{{.Date}}/{{.Name}}",
+	"append": "{{.Name}}_{{.Host}}",
+}
+
+// LayoutNames is the order the layouts are offered in
+var LayoutNames = []string{"suffix", "dir", "prefix", "dated", "append"}
+
+const defaultLayout = "suffix"
+
+var (
+	compressedExts = []string{".gz", ".bz2", ".xz", ".zst", ".z", ".lz4"}
+	unsafeHostChar = regexp.MustCompile(`[/\\:*?"<>|]`)
+)
+
+// NameFields are the values available to receive layout templates
+type NameFields struct {
+	Host      string
+	Name      string
+	Base      string
+	Ext       string
+	Date      string
+	Time      string
+	Timestamp string
+}
+
+// GetLayout reads GOSSH_RECEIVE_LAYOUT, a layout name or template
+func GetLayout() string {
+	if layout := os.Getenv("GOSSH_RECEIVE_LAYOUT"); layout != "" {
+		return layout
+	}
+	return defaultLayout
+}
+
+func ParseLayout(layout string) (*template.Template, error) {
+	text, ok := Layouts[layout]
+	if !ok {
+		text = layout
+	}
+	t, err := template.New("layout").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid receive layout %q: %w", layout, err)
+	}
+	// catch unknown fields before any transfer starts
+	if err := t.Execute(&bytes.Buffer{}, NameFields{}); err != nil {
+		return nil, fmt.Errorf("invalid receive layout %q: %w", layout, err)
+	}
+	return t, nil
+}
+
+// SplitExt splits a file name before its extension, keeping compression
+// extensions with the one before them (app.log.gz is app + .log.gz)
+func SplitExt(name string) (string, string) {
+	ext := path.Ext(name)
+	if ext == "" || ext == name {
+		return name, ""
+	}
+	base := strings.TrimSuffix(name, ext)
+	for _, c := range compressedExts {
+		if strings.EqualFold(ext, c) {
+			if inner := path.Ext(base); inner != "" && inner != base {
+				return strings.TrimSuffix(base, inner), inner + ext
+			}
+		}
+	}
+	return base, ext
+}
+
+// HostKeys returns the name used for each connection in receive layouts.
+// Connections whose cleaned names collide get a numbered suffix, which is
+// reported in the returned warnings.
+func HostKeys(items []connection.Item) ([]string, []string) {
+	keys := make([]string, len(items))
+	var warnings []string
+	seen := map[string]int{}
+	first := map[string]string{}
+
+	for ind, item := range items {
+		key := unsafeHostChar.ReplaceAllString(item.CleanTitle(), "_")
+		seen[key]++
+		if seen[key] == 1 {
+			first[key] = item.WindowName()
+			keys[ind] = key
+			continue
+		}
+		keys[ind] = fmt.Sprintf("%v-%d", key, seen[key])
+		warnings = append(warnings, fmt.Sprintf("%v and %v are both named %v, using %v for %v",
+			first[key], item.WindowName(), key, keys[ind], item.WindowName()))
+	}
+	return keys, warnings
+}
+
+// ReceiveLayout works out where the sources of each connection, srcs[i] for
+// items[i], are saved under dest. It fails when two files would be saved to
+// the same path.
+func ReceiveLayout(items []connection.Item, srcs [][]string, dest string, layout string, now time.Time) ([][]Path, []string, error) {
+	t, err := ParseLayout(layout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys, warnings := HostKeys(items)
+	all := make([][]Path, len(items))
+	used := map[string]string{}
+
+	for ind, item := range items {
+		for _, src := range srcs[ind] {
+			name := path.Base(strings.TrimSuffix(src, "/"))
+			base, ext := SplitExt(name)
+			fields := NameFields{
+				Host:      keys[ind],
+				Name:      name,
+				Base:      base,
+				Ext:       ext,
+				Date:      now.Format("2006-01-02"),
+				Time:      now.Format("150405"),
+				Timestamp: now.Format("20060102-150405"),
+			}
+
+			var buf bytes.Buffer
+			if err := t.Execute(&buf, fields); err != nil {
+				return nil, nil, err
+			}
+			rel := filepath.Clean(filepath.FromSlash(buf.String()))
+			if rel == "." || filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return nil, nil, fmt.Errorf("receive layout %q puts %v outside %v", layout, name, dest)
+			}
+
+			p := Path{Src: src, Dest: filepath.Join(dest, rel)}
+			from := fmt.Sprintf("%v on %v", src, item.WindowName())
+			if other, ok := used[p.Dest]; ok {
+				return nil, nil, fmt.Errorf("receive layout %q saves %v and %v to %v", layout, other, from, p.Dest)
+			}
+			used[p.Dest] = from
+			all[ind] = append(all[ind], p)
+		}
+	}
+
+	return all, warnings, nil
+}
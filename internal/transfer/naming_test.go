@@ -0,0 +1,119 @@
+package transfer
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nicknickel/gossh/internal/connection"
+)
+
+func TestSplitExt(t *testing.T) {
+	tests := map[string][2]string{
+		"syslog":      {"syslog", ""},
+		"syslog.gz":   {"syslog", ".gz"},
+		"app.log":     {"app", ".log"},
+		"app.log.gz":  {"app", ".log.gz"},
+		"a.b.tar.xz":  {"a.b", ".tar.xz"},
+		".bashrc":     {".bashrc", ""},
+		"archive.tgz": {"archive", ".tgz"},
+	}
+	for name, expected := range tests {
+		base, ext := SplitExt(name)
+		if base != expected[0] || ext != expected[1] {
+			t.Errorf("SplitExt(%q) = %q, %q, want %q, %q", name, base, ext, expected[0], expected[1])
+		}
+	}
+}
+
+func TestParseLayout(t *testing.T) {
+	tests := map[string]bool{
+		"suffix":                   true,
+		"dated":                    true,
+		"{{.Host}}-{{.Timestamp}}": true,
+		"{{.Hots}}/{{.Name}}":      false,
+		"{{.Host":                  false,
+	}
+	for layout, valid := range tests {
+		if _, err := ParseLayout(layout); (err == nil) != valid {
+			t.Errorf("ParseLayout(%q) error = %v, want valid = %v", layout, err, valid)
+		}
+	}
+}
+
+func TestGetLayout(t *testing.T) {
+	t.Setenv("GOSSH_RECEIVE_LAYOUT", "")
+	if GetLayout() != "suffix" {
+		t.Errorf("default layout = %v, want suffix", GetLayout())
+	}
+	t.Setenv("GOSSH_RECEIVE_LAYOUT", "dated")
+	if GetLayout() != "dated" {
+		t.Errorf("GetLayout() = %v, want dated", GetLayout())
+	}
+}
+
+func TestHostKeys(t *testing.T) {
+	items := []connection.Item{
+		{Name: "web1"},
+		{Name: "db:prod"},
+		{Name: "web1", Conn: connection.Connection{Address: "10.0.0.2"}},
+		{Name: "web 1", Conn: connection.Connection{Address: "10.0.0.3"}},
+	}
+	keys, warnings := HostKeys(items)
+	if !slices.Equal(keys, []string{"web1", "db_prod", "web1-2", "web1-3"}) {
+		t.Errorf("HostKeys() = %v", keys)
+	}
+	if len(warnings) != 2 {
+		t.Errorf("expected a warning per collision, got %v", warnings)
+	}
+}
+
+func TestReceiveLayout(t *testing.T) {
+	items := []connection.Item{{Name: "web1"}, {Name: "web2"}}
+	srcs := []string{"/var/log/syslog.1.gz", "/etc/nginx/"}
+	now := time.Date(2024, 3, 5, 14, 30, 0, 0, time.UTC)
+
+	tests := map[string][]string{
+		"suffix": {"syslog_web1.1.gz", "nginx_web1"},
+		"dir":    {"web1/syslog.1.gz", "web1/nginx"},
+		"prefix": {"web1_syslog.1.gz", "web1_nginx"},
+		"dated":  {"web1/2024-03-05/syslog.1.gz", "web1/2024-03-05/nginx"},
+		"append": {"syslog.1.gz_web1", "nginx_web1"},
+		"{{.Host}}/{{.Timestamp}}-{{.Base}}{{.Ext}}": {"web1/20240305-143000-syslog.1.gz", "web1/20240305-143000-nginx"},
+	}
+	for layout, expected := range tests {
+		paths, warnings, err := ReceiveLayout(items, [][]string{srcs, srcs}, "out", layout, now)
+		if err != nil || len(warnings) != 0 {
+			t.Errorf("ReceiveLayout(%q) error = %v, warnings = %v", layout, err, warnings)
+			continue
+		}
+		for ind, p := range paths[0] {
+			if p.Src != srcs[ind] || p.Dest != filepath.Join("out", filepath.FromSlash(expected[ind])) {
+				t.Errorf("ReceiveLayout(%q) = %v, want %v", layout, p, expected[ind])
+			}
+		}
+		if !strings.Contains(paths[1][0].Dest, "web2") {
+			t.Errorf("ReceiveLayout(%q) second host = %v", layout, paths[1][0].Dest)
+		}
+	}
+}
+
+func TestReceiveLayout_Errors(t *testing.T) {
+	items := []connection.Item{{Name: "web1"}, {Name: "web2"}}
+	now := time.Now()
+
+	tests := map[string]string{
+		"{{.Name}}":         "saves",
+		"../{{.Host}}":      "outside",
+		"/tmp/{{.Host}}":    "outside",
+		"{{.Host}}/../../x": "outside",
+		"{{.Unknown}}":      "invalid",
+	}
+	for layout, expected := range tests {
+		if _, _, err := ReceiveLayout(items, [][]string{{"/var/log/syslog"}, {"/var/log/syslog"}}, "out", layout, now); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("ReceiveLayout(%q) error = %v, want %q", layout, err, expected)
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package transfer
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/runcommand"
+	"github.com/pkg/sftp"
+)
+
+// PartSuffix is appended to files while they are transferred so an
+// interrupted transfer can be resumed
+const PartSuffix = ".gossh-part"
+
+type Direction int
+
+const (
+	Send Direction = iota
+	Receive
+)
+
+// Path is a source and where it is copied to
+type Path struct {
+	Src  string
+	Dest string
+}
+
+// Job is the transfer of one or more paths to or from a single host. Progress
+// is updated atomically so it can be read while the transfer runs.
+type Job struct {
+	Item      connection.Item
+	Direction Direction
+	Paths     []Path
+	Err       error
+
+	// Mismatches lists the remote paths that failed checksum verification
+	Mismatches []string
+	// Verified, Skipped and Resumed count files that passed verification, were
+	// already up to date and continued a partial transfer
+	Verified int
+	Skipped  int
+	Resumed  int
+
+	files     []copiedFile
+	remoteSum func(c *sftp.Client, p string) (string, error)
+
+	verifying atomic.Bool
+	total     atomic.Int64
+	done      atomic.Int64
+	started   atomic.Int64
+	finished  atomic.Bool
+}
+
+func NewJob(i connection.Item, d Direction, src string, dest string) *Job {
+	return &Job{Item: i, Direction: d, Paths: []Path{{Src: src, Dest: dest}}}
+}
+
+func NewMultiJob(i connection.Item, d Direction, paths []Path) *Job {
+	return &Job{Item: i, Direction: d, Paths: paths}
+}
+
+// SendPaths copies a single source like scp does and several sources into the
+// remote dest directory
+func SendPaths(srcs []string, dest string) []Path {
+	if len(srcs) == 1 {
+		return []Path{{Src: srcs[0], Dest: dest}}
+	}
+	var paths []Path
+	for _, src := range srcs {
+		paths = append(paths, Path{Src: src, Dest: path.Join(dest, filepath.Base(src))})
+	}
+	return paths
+}
+
+func (j *Job) Progress() (int64, int64) {
+	return j.done.Load(), j.total.Load()
+}
+
+func (j *Job) Started() time.Time {
+	s := j.started.Load()
+	if s == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, s)
+}
+
+// Verifying reports whether the copy is done and checksums are being compared
+func (j *Job) Verifying() bool {
+	return j.verifying.Load()
+}
+
+func (j *Job) Finished() bool {
+	return j.finished.Load()
+}
+
+func (j *Job) add(n int64) {
+	j.done.Add(n)
+}
+
+type copiedFile struct {
+	local  string
+	remote string
+}
+
+type progressWriter struct {
+	w        io.Writer
+	progress func(int64)
+}
+
+func (p progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.progress(int64(n))
+	return n, err
+}
+
+func LocalSize(src string) (int64, error) {
+	var size int64
+	err := filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+func RemoteSize(c *sftp.Client, src string) (int64, error) {
+	var size int64
+	walker := c.Walk(src)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return size, err
+		}
+		if walker.Stat().Mode().IsRegular() {
+			size += walker.Stat().Size()
+		}
+	}
+	return size, nil
+}
+
+// unchanged reports whether dest already holds src, compared by size and
+// modification time like rsync's quick check
+func unchanged(dest os.FileInfo, src os.FileInfo) bool {
+	return dest.Mode().IsRegular() && dest.Size() == src.Size() && dest.ModTime().Unix() == src.ModTime().Unix()
+}
+
+// SendPath copies src recursively to dest, like scp -rp it copies into dest
+// when it is an existing directory and preserves modes and times
+func (j *Job) SendPath(c *sftp.Client, src string, dest string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if fi, err := c.Stat(dest); err == nil && fi.IsDir() {
+		dest = path.Join(dest, filepath.Base(src))
+	} else if err := c.MkdirAll(path.Dir(dest)); err != nil {
+		return err
+	}
+	return j.sendPath(c, src, dest, info)
+}
+
+func (j *Job) sendPath(c *sftp.Client, src string, dest string, info os.FileInfo) error {
+	if info.IsDir() {
+		if err := c.MkdirAll(dest); err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			childInfo, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			if err := j.sendPath(c, filepath.Join(src, entry.Name()), path.Join(dest, entry.Name()), childInfo); err != nil {
+				return err
+			}
+		}
+	} else {
+		if err := j.sendFile(c, src, dest, info); err != nil {
+			return err
+		}
+		j.files = append(j.files, copiedFile{local: src, remote: dest})
+	}
+
+	if err := c.Chmod(dest, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return c.Chtimes(dest, info.ModTime(), info.ModTime())
+}
+
+// sendFile writes src to a partial file next to dest, appending to a partial
+// file left by an earlier attempt, and renames it into place when complete
+func (j *Job) sendFile(c *sftp.Client, src string, dest string, info os.FileInfo) error {
+	if fi, err := c.Stat(dest); err == nil && unchanged(fi, info) {
+		j.add(info.Size())
+		j.Skipped++
+		return nil
+	}
+
+	local, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer local.Close()
+
+	part := dest + PartSuffix
+	var offset int64
+	if fi, err := c.Stat(part); err == nil && fi.Mode().IsRegular() && fi.Size() <= info.Size() {
+		offset = fi.Size()
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	remote, err := c.OpenFile(part, flags)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		if _, err := remote.Seek(offset, io.SeekStart); err != nil {
+			remote.Close()
+			return err
+		}
+		if _, err := local.Seek(offset, io.SeekStart); err != nil {
+			remote.Close()
+			return err
+		}
+		j.add(offset)
+		j.Resumed++
+	}
+	if _, err := io.Copy(progressWriter{remote, j.add}, local); err != nil {
+		remote.Close()
+		return err
+	}
+	if err := remote.Close(); err != nil {
+		return err
+	}
+
+	if err := c.PosixRename(part, dest); err != nil {
+		c.Remove(dest)
+		return c.Rename(part, dest)
+	}
+	return nil
+}
+
+// ReceivePath copies the remote src recursively to dest preserving modes and
+// times. Unlike SendPath, dest is the exact path as worked out by
+// ReceiveLayout so repeated runs resume rather than nest.
+func (j *Job) ReceivePath(c *sftp.Client, src string, dest string) error {
+	info, err := c.Stat(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return err
+	}
+	return j.receivePath(c, src, dest, info)
+}
+
+func (j *Job) receivePath(c *sftp.Client, src string, dest string, info os.FileInfo) error {
+	if info.IsDir() {
+		if err := os.MkdirAll(dest, 0700); err != nil {
+			return err
+		}
+		entries, err := c.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := j.receivePath(c, path.Join(src, entry.Name()), filepath.Join(dest, entry.Name()), entry); err != nil {
+				return err
+			}
+		}
+	} else {
+		if err := j.receiveFile(c, src, dest, info); err != nil {
+			return err
+		}
+		j.files = append(j.files, copiedFile{local: dest, remote: src})
+	}
+
+	if err := os.Chmod(dest, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(dest, info.ModTime(), info.ModTime())
+}
+
+// receiveFile is the receiving counterpart of sendFile
+func (j *Job) receiveFile(c *sftp.Client, src string, dest string, info os.FileInfo) error {
+	if fi, err := os.Stat(dest); err == nil && unchanged(fi, info) {
+		j.add(info.Size())
+		j.Skipped++
+		return nil
+	}
+
+	remote, err := c.Open(src)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+
+	part := dest + PartSuffix
+	var offset int64
+	if fi, err := os.Stat(part); err == nil && fi.Mode().IsRegular() && fi.Size() <= info.Size() {
+		offset = fi.Size()
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	local, err := os.OpenFile(part, flags, 0600)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		if _, err := local.Seek(offset, io.SeekStart); err != nil {
+			local.Close()
+			return err
+		}
+		if _, err := remote.Seek(offset, io.SeekStart); err != nil {
+			local.Close()
+			return err
+		}
+		j.add(offset)
+		j.Resumed++
+	}
+	if _, err := io.Copy(progressWriter{local, j.add}, remote); err != nil {
+		local.Close()
+		return err
+	}
+	if err := local.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(part, dest)
+}
+
+// Run performs the transfer over an existing sftp client
+func (j *Job) Run(c *sftp.Client) error {
+	var total int64
+	for _, p := range j.Paths {
+		var size int64
+		var err error
+		if j.Direction == Send {
+			size, err = LocalSize(p.Src)
+		} else {
+			size, err = RemoteSize(c, p.Src)
+		}
+		if err != nil {
+			return err
+		}
+		total += size
+	}
+	j.total.Store(total)
+	j.started.Store(time.Now().UnixNano())
+
+	for _, p := range j.Paths {
+		var err error
+		if j.Direction == Send {
+			err = j.SendPath(c, p.Src, p.Dest)
+		} else {
+			err = j.ReceivePath(c, p.Src, p.Dest)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if GetVerifyMode() == VerifyOff {
+		return nil
+	}
+
+	j.verifying.Store(true)
+	return j.verify(c)
+}
+
+func (j *Job) connectAndRun() error {
+	client, err := Dial(j.Item)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	c, err := sftp.NewClient(client)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if GetVerifyMode() == VerifyCommand {
+		j.remoteSum = commandSum(client)
+	}
+	return j.Run(c)
+}
+
+// RunAll runs the jobs concurrently, limited by GOSSH_CONCURRENCY
+func RunAll(jobs []*Job) {
+	var wg sync.WaitGroup
+	limiter := make(chan int, runcommand.GetConcurrency())
+
+	for _, job := range jobs {
+		wg.Go(func() {
+			limiter <- 1
+			job.Err = job.connectAndRun()
+			job.finished.Store(true)
+			<-limiter
+		})
+	}
+	wg.Wait()
+}
@@ -0,0 +1,454 @@
+package transfer
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/hostkey"
+	internal_log "github.com/nicknickel/gossh/internal/log"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// startServer runs an in-process ssh server with an sftp subsystem that only
+// accepts the given password. The server key is added to a known_hosts file
+// in a temporary HOME.
+func startServer(t *testing.T, password string) string {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate host key: %v", err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("Failed to create host signer: %v", err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if string(pass) == password {
+				return nil, nil
+			}
+			return nil, io.EOF
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			nConn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(nConn, config)
+		}
+	}()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SSH_AUTH_SOCK", "")
+	os.MkdirAll(filepath.Join(home, ".ssh"), 0700)
+	line := knownhosts.Line([]string{knownhosts.Normalize(l.Addr().String())}, hostKey.PublicKey())
+	os.WriteFile(filepath.Join(home, ".ssh", "known_hosts"), []byte(line+"\n"), 0600)
+
+	return l.Addr().String()
+}
+
+func serveConn(nConn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(nConn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				if req.Type == "exec" {
+					req.Reply(true, nil)
+					serveExec(channel, string(req.Payload[4:]))
+					continue
+				}
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					server, err := sftp.NewServer(channel)
+					if err == nil {
+						server.Serve()
+					}
+					channel.Close()
+				}
+			}
+		}()
+	}
+}
+
+// serveExec runs the command locally like sshd would
+func serveExec(channel ssh.Channel, command string) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout = channel
+	cmd.Stderr = channel.Stderr()
+	status := uint32(0)
+	if err := cmd.Run(); err != nil {
+		status = 1
+	}
+	channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+	channel.Close()
+}
+
+func writeTree(t *testing.T, root string) {
+	files := map[string]string{
+		"a.txt":         "hello",
+		"sub/b.txt":     strings.Repeat("b", 100000),
+		"sub/deep/c.sh": "#!/bin/sh\n",
+	}
+	for name, contents := range files {
+		p := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte(contents), 0644); err != nil {
+			t.Fatalf("Failed to write %v: %v", p, err)
+		}
+	}
+	os.Chmod(filepath.Join(root, "sub/deep/c.sh"), 0755)
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	os.Chtimes(filepath.Join(root, "a.txt"), old, old)
+}
+
+// compareTrees checks contents, modes and mtimes, sftp only carries whole
+// seconds
+func compareTrees(t *testing.T, want string, got string) {
+	filepath.Walk(want, func(p string, info os.FileInfo, err error) error {
+		rel, _ := filepath.Rel(want, p)
+		gotInfo, err := os.Stat(filepath.Join(got, rel))
+		if err != nil {
+			t.Errorf("%v missing from copy", rel)
+			return nil
+		}
+		if gotInfo.Mode().Perm() != info.Mode().Perm() {
+			t.Errorf("%v mode = %v, want %v", rel, gotInfo.Mode().Perm(), info.Mode().Perm())
+		}
+		if info.Mode().IsRegular() {
+			if !gotInfo.ModTime().Equal(info.ModTime().Truncate(time.Second)) {
+				t.Errorf("%v mtime = %v, want %v", rel, gotInfo.ModTime(), info.ModTime())
+			}
+			a, _ := os.ReadFile(p)
+			b, _ := os.ReadFile(filepath.Join(got, rel))
+			if string(a) != string(b) {
+				t.Errorf("%v contents differ", rel)
+			}
+		}
+		return nil
+	})
+}
+
+func TestSendPaths(t *testing.T) {
+	single := SendPaths([]string{"dir/"}, "/srv")
+	if !slices.Equal(single, []Path{{Src: "dir/", Dest: "/srv"}}) {
+		t.Errorf("SendPaths() single = %v", single)
+	}
+	multi := SendPaths([]string{"a.txt", "dir/"}, "/srv")
+	if !slices.Equal(multi, []Path{{Src: "a.txt", Dest: "/srv/a.txt"}, {Src: "dir/", Dest: "/srv/dir"}}) {
+		t.Errorf("SendPaths() multi = %v", multi)
+	}
+}
+
+func TestJobs_MultiplePaths(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	addr := startServer(t, "hunter2")
+	item := connection.Item{Name: "sftp", Conn: connection.Connection{Address: addr, Password: "exec:echo hunter2"}}
+
+	local := t.TempDir()
+	writeTree(t, local)
+	srcs := []string{filepath.Join(local, "a.txt"), filepath.Join(local, "sub")}
+	remote := filepath.Join(t.TempDir(), "new", "dir")
+
+	send := NewMultiJob(item, Send, SendPaths(srcs, remote))
+	RunAll([]*Job{send})
+	if send.Err != nil || send.Verified != 3 {
+		t.Fatalf("send job err = %v, verified = %v", send.Err, send.Verified)
+	}
+	if done, total := send.Progress(); done != total || total != 100015 {
+		t.Errorf("send progress = %v/%v, want 100015/100015", done, total)
+	}
+	compareTrees(t, filepath.Join(local, "sub"), filepath.Join(remote, "sub"))
+
+	received := t.TempDir()
+	paths, _, err := ReceiveLayout([]connection.Item{item}, [][]string{{filepath.Join(remote, "a.txt"), filepath.Join(remote, "sub")}}, received, "suffix", time.Now())
+	if err != nil {
+		t.Fatalf("ReceiveLayout() err = %v", err)
+	}
+	receive := NewMultiJob(item, Receive, paths[0])
+	RunAll([]*Job{receive})
+	if receive.Err != nil {
+		t.Fatalf("receive job err = %v", receive.Err)
+	}
+	compareTrees(t, filepath.Join(local, "sub"), filepath.Join(received, "sub_sftp"))
+	if b, _ := os.ReadFile(filepath.Join(received, "a_sftp.txt")); string(b) != "hello" {
+		t.Errorf("received a.txt = %q", b)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	addr := startServer(t, "hunter2")
+	item := connection.Item{
+		Name: "sftp",
+		Conn: connection.Connection{Address: addr, User: "test", Password: "exec:echo hunter2"},
+	}
+
+	local := t.TempDir()
+	writeTree(t, local)
+	remote := t.TempDir()
+
+	send := NewJob(item, Send, local, remote)
+	RunAll([]*Job{send})
+	if send.Err != nil || !send.Finished() {
+		t.Fatalf("send job err = %v, finished = %v", send.Err, send.Finished())
+	}
+	done, total := send.Progress()
+	if done != total || total != 100015 {
+		t.Errorf("send progress = %v/%v, want 100015/100015", done, total)
+	}
+	sent := filepath.Join(remote, filepath.Base(local))
+	compareTrees(t, local, sent)
+
+	received := filepath.Join(t.TempDir(), "received_sftp")
+	receive := NewJob(item, Receive, sent, received)
+	RunAll([]*Job{receive})
+	if receive.Err != nil {
+		t.Fatalf("receive job err = %v", receive.Err)
+	}
+	compareTrees(t, local, received)
+}
+
+func TestJobs_Errors(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	addr := startServer(t, "hunter2")
+
+	wrongPassword := connection.Item{Name: "wrong", Conn: connection.Connection{Address: addr, Password: "exec:echo wrong"}}
+	noAuth := connection.Item{Name: "none", Conn: connection.Connection{Address: addr}}
+	missing := connection.Item{Name: "missing", Conn: connection.Connection{Address: addr, Password: "exec:echo hunter2"}}
+
+	jobs := []*Job{
+		NewJob(wrongPassword, Send, t.TempDir(), "/tmp"),
+		NewJob(noAuth, Send, t.TempDir(), "/tmp"),
+		NewJob(missing, Receive, "/nonexistent/file", t.TempDir()),
+	}
+	RunAll(jobs)
+	for _, job := range jobs {
+		if job.Err == nil {
+			t.Errorf("%v job expected error", job.Item.Name)
+		}
+	}
+
+	os.WriteFile(hostkey.UserFile(), []byte{}, 0600)
+	unknown := NewJob(missing, Send, t.TempDir(), "/tmp")
+	RunAll([]*Job{unknown})
+	if unknown.Err == nil || !strings.Contains(unknown.Err.Error(), "not known") {
+		t.Errorf("unknown host key err = %v, want not known", unknown.Err)
+	}
+}
+
+func TestJobs_ResumeAndVerify(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	addr := startServer(t, "hunter2")
+	item := connection.Item{Name: "sftp", Conn: connection.Connection{Address: addr, Password: "exec:echo hunter2"}}
+
+	local := t.TempDir()
+	writeTree(t, local)
+	parent := t.TempDir()
+	remote := filepath.Join(parent, filepath.Base(local))
+	os.MkdirAll(filepath.Join(remote, "sub"), 0755)
+
+	// a partial file from an interrupted transfer and an up to date file
+	big, _ := os.ReadFile(filepath.Join(local, "sub/b.txt"))
+	os.WriteFile(filepath.Join(remote, "sub/b.txt"+PartSuffix), big[:40000], 0644)
+	os.WriteFile(filepath.Join(remote, "a.txt"), []byte("hello"), 0644)
+	info, _ := os.Stat(filepath.Join(local, "a.txt"))
+	os.Chtimes(filepath.Join(remote, "a.txt"), info.ModTime(), info.ModTime())
+
+	send := NewJob(item, Send, local, parent)
+	RunAll([]*Job{send})
+	if send.Err != nil {
+		t.Fatalf("send job err = %v", send.Err)
+	}
+	if send.Resumed != 1 || send.Skipped != 1 || send.Verified != 3 {
+		t.Errorf("send resumed, skipped, verified = %v, %v, %v, want 1, 1, 3", send.Resumed, send.Skipped, send.Verified)
+	}
+	if done, total := send.Progress(); done != total {
+		t.Errorf("send progress = %v/%v, want complete", done, total)
+	}
+	if _, err := os.Stat(filepath.Join(remote, "sub/b.txt"+PartSuffix)); !os.IsNotExist(err) {
+		t.Errorf("partial file not renamed into place")
+	}
+	compareTrees(t, local, remote)
+
+	// a corrupt partial file is caught by verification
+	os.Remove(filepath.Join(remote, "sub/b.txt"))
+	os.WriteFile(filepath.Join(remote, "sub/b.txt"+PartSuffix), []byte(strings.Repeat("x", 100)), 0644)
+	corrupt := NewJob(item, Send, local, parent)
+	RunAll([]*Job{corrupt})
+	if corrupt.Err == nil || len(corrupt.Mismatches) != 1 || corrupt.Mismatches[0] != filepath.Join(remote, "sub/b.txt") {
+		t.Errorf("corrupt job err = %v, mismatches = %v", corrupt.Err, corrupt.Mismatches)
+	}
+	retry := NewJob(item, Send, local, parent)
+	RunAll([]*Job{retry})
+	if retry.Err != nil || retry.Skipped != 2 {
+		t.Errorf("retry job err = %v, skipped = %v, want 2", retry.Err, retry.Skipped)
+	}
+	compareTrees(t, local, remote)
+
+	// receiving resumes from a local partial file
+	received := filepath.Join(t.TempDir(), filepath.Base(local))
+	os.MkdirAll(filepath.Join(received, "sub"), 0755)
+	os.WriteFile(filepath.Join(received, "sub/b.txt"+PartSuffix), big[:1000], 0644)
+	receive := NewJob(item, Receive, local, received)
+	RunAll([]*Job{receive})
+	if receive.Err != nil || receive.Resumed != 1 || receive.Verified != 3 {
+		t.Errorf("receive job err = %v, resumed = %v, verified = %v", receive.Err, receive.Resumed, receive.Verified)
+	}
+	compareTrees(t, local, received)
+}
+
+func TestDial_SshConfig(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	addr := startServer(t, "hunter2")
+	host, port, _ := net.SplitHostPort(addr)
+
+	// ssh resolves the alias to the test server
+	bin := t.TempDir()
+	script := fmt.Sprintf("#!/bin/sh\nprintf 'hostname %v\\nport %v\\nuser ops\\n'\n", host, port)
+	os.WriteFile(filepath.Join(bin, "ssh"), []byte(script), 0700)
+	t.Setenv("PATH", bin+":"+os.Getenv("PATH"))
+
+	item := connection.Item{Name: "sftp-alias", Conn: connection.Connection{Password: "exec:echo hunter2"}}
+	client, err := Dial(item)
+	if err != nil {
+		t.Fatalf("Dial() err = %v", err)
+	}
+	defer client.Close()
+	if client.User() != "ops" {
+		t.Errorf("Dial() user = %v, want the user from the ssh config", client.User())
+	}
+}
+
+func TestDial_Timeout(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	t.Setenv("HOME", t.TempDir())
+	defer func(d time.Duration) { dialTimeout = d }(dialTimeout)
+	dialTimeout = 200 * time.Millisecond
+
+	// accepts the connection but never sends a banner
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+
+	bin := t.TempDir()
+	script := fmt.Sprintf("#!/bin/sh\nprintf 'hostname %v\\nport %v\\n'\n", host, port)
+	os.WriteFile(filepath.Join(bin, "ssh"), []byte(script), 0700)
+	t.Setenv("PATH", bin+":"+os.Getenv("PATH"))
+
+	item := connection.Item{Name: "silent", Conn: connection.Connection{Password: "exec:echo hunter2"}}
+	start := time.Now()
+	if client, err := Dial(item); err == nil {
+		client.Close()
+		t.Fatalf("Dial() to a silent server expected error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Dial() took %v, want it bounded by the timeout", elapsed)
+	}
+}
+
+func TestRemoteSums(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	if _, err := exec.LookPath("sha256sum"); err != nil {
+		t.Skip("sha256sum not installed")
+	}
+	addr := startServer(t, "hunter2")
+	item := connection.Item{Name: "sftp", Conn: connection.Connection{Address: addr, Password: "exec:echo hunter2"}}
+
+	p := filepath.Join(t.TempDir(), "it's a file")
+	os.WriteFile(p, []byte("hello"), 0644)
+	want := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+	client, err := Dial(item)
+	if err != nil {
+		t.Fatalf("Dial() err = %v", err)
+	}
+	defer client.Close()
+	c, err := sftp.NewClient(client)
+	if err != nil {
+		t.Fatalf("sftp.NewClient() err = %v", err)
+	}
+	defer c.Close()
+
+	if got, err := LocalSum(p); got != want || err != nil {
+		t.Errorf("LocalSum() = %v, %v, want %v", got, err, want)
+	}
+	if got, err := ReadBackSum(c, p); got != want || err != nil {
+		t.Errorf("ReadBackSum() = %v, %v, want %v", got, err, want)
+	}
+	if got, err := CommandSum(client, p); got != want || err != nil {
+		t.Errorf("CommandSum() = %v, %v, want %v", got, err, want)
+	}
+	if _, err := CommandSum(client, p+".missing"); err == nil {
+		t.Errorf("CommandSum() of missing file expected error")
+	}
+	if got, err := commandSum(client)(c, p); got != want || err != nil {
+		t.Errorf("commandSum() = %v, %v, want %v", got, err, want)
+	}
+}
+
+func TestGetVerifyMode(t *testing.T) {
+	tests := map[string]string{
+		"":         VerifyCommand,
+		"command":  VerifyCommand,
+		"READBACK": VerifyReadBack,
+		"off":      VerifyOff,
+		"bogus":    VerifyCommand,
+	}
+	for env, expected := range tests {
+		t.Setenv("GOSSH_TRANSFER_VERIFY", env)
+		if got := GetVerifyMode(); got != expected {
+			t.Errorf("GetVerifyMode() with %q = %v, want %v", env, got, expected)
+		}
+	}
+}
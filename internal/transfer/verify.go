@@ -0,0 +1,139 @@
+package transfer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/nicknickel/gossh/internal/log"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	VerifyCommand  = "command"
+	VerifyReadBack = "readback"
+	VerifyOff      = "off"
+)
+
+var sumPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// GetVerifyMode reads GOSSH_TRANSFER_VERIFY. The default runs sha256sum on
+// the remote host and falls back to reading the file back over SFTP.
+func GetVerifyMode() string {
+	switch strings.ToLower(os.Getenv("GOSSH_TRANSFER_VERIFY")) {
+	case VerifyReadBack:
+		return VerifyReadBack
+	case VerifyOff:
+		return VerifyOff
+	}
+	return VerifyCommand
+}
+
+func hashReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func LocalSum(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return hashReader(f)
+}
+
+// ReadBackSum hashes the remote file by reading it over SFTP
+func ReadBackSum(c *sftp.Client, p string) (string, error) {
+	f, err := c.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return hashReader(f)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// CommandSum hashes the remote file with sha256sum (or shasum on macOS/BSD)
+// so the contents do not have to cross the network again
+func CommandSum(client *ssh.Client, p string) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	q := shellQuote(p)
+	out, err := session.Output(fmt.Sprintf("sha256sum -- %v 2>/dev/null || shasum -a 256 -- %v", q, q))
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 || !sumPattern.MatchString(strings.ToLower(fields[0])) {
+		return "", fmt.Errorf("unexpected checksum output %q", out)
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// commandSum uses CommandSum until it fails once, then reads files back
+func commandSum(client *ssh.Client) func(c *sftp.Client, p string) (string, error) {
+	useCommand := true
+	return func(c *sftp.Client, p string) (string, error) {
+		if useCommand {
+			sum, err := CommandSum(client, p)
+			if err == nil {
+				return sum, nil
+			}
+			log.Logger.Debug("Remote checksum command failed, reading file back", "path", p, "err", err)
+			useCommand = false
+		}
+		return ReadBackSum(c, p)
+	}
+}
+
+// verify compares the sha256 of every copied file on both sides
+func (j *Job) verify(c *sftp.Client) error {
+	remoteSum := j.remoteSum
+	if remoteSum == nil {
+		remoteSum = ReadBackSum
+	}
+
+	for _, f := range j.files {
+		want, err := LocalSum(f.local)
+		if err != nil {
+			return err
+		}
+		got, err := remoteSum(c, f.remote)
+		if err != nil {
+			return err
+		}
+		if want != got {
+			log.Logger.Error("Checksum mismatch", "connection", j.Item.Name, "path", f.remote, "local", want, "remote", got)
+			j.Mismatches = append(j.Mismatches, f.remote)
+			// remove the bad copy so the quick check does not skip it next time
+			if j.Direction == Send {
+				c.Remove(f.remote)
+			} else {
+				os.Remove(f.local)
+			}
+		} else {
+			j.Verified++
+		}
+	}
+
+	if len(j.Mismatches) > 0 {
+		return fmt.Errorf("checksum mismatch for %d of %d files", len(j.Mismatches), len(j.files))
+	}
+	return nil
+}
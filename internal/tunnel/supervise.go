@@ -0,0 +1,143 @@
+package tunnel
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/log"
+	"github.com/nicknickel/gossh/internal/runcommand"
+)
+
+var (
+	// upAfter is how long ssh has to keep running for the tunnel to be up
+	upAfter = 3 * time.Second
+	// retryDelay doubles after each failed attempt up to maxRetryDelay
+	retryDelay    = time.Second
+	maxRetryDelay = time.Minute
+)
+
+type exitResult struct {
+	out string
+	err error
+}
+
+// lastLine returns the last non-empty line of the output, which is where
+// ssh explains why it exited
+func lastLine(out string) string {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// Supervise keeps the forward open until a stop signal arrives, restarting
+// ssh with a growing delay whenever it exits. Its state is kept in the
+// runtime directory for list and stop.
+func Supervise(i connection.Item, f connection.Forward, stop <-chan os.Signal) error {
+	return supervise(i, f, stop, false)
+}
+
+// SuperviseInteractive is Supervise for tunnels that live inside an
+// interactive gossh, which stop refuses to terminate
+func SuperviseInteractive(i connection.Item, f connection.Forward, stop <-chan os.Signal) error {
+	return supervise(i, f, stop, true)
+}
+
+func supervise(i connection.Item, f connection.Forward, stop <-chan os.Signal, interactive bool) error {
+	args, err := Args(f)
+	if err != nil {
+		return err
+	}
+	dir, err := RuntimeDir()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	st := State{
+		Connection:  i.Name,
+		Forward:     f.Label(),
+		Spec:        strings.Join(args, " ") + " " + i.FinalAddr(),
+		PID:         os.Getpid(),
+		Started:     now,
+		Interactive: interactive,
+	}
+	set := func(status string) {
+		st.Status = status
+		st.Since = time.Now()
+		if err := writeState(dir, st); err != nil {
+			log.Logger.Error("Could not write tunnel state", "tunnel", st.Forward, "err", err)
+		}
+	}
+	defer os.Remove(statePath(dir, st.Connection, st.Forward))
+
+	delay := retryDelay
+	for {
+		set(StatusConnecting)
+		cmd, responders, cleanup, err := Command(i, f)
+		if err != nil {
+			return err
+		}
+
+		var wait func() (string, error)
+		if len(responders) > 0 {
+			wait, err = runcommand.StartPtyCommand(cmd, responders)
+		} else {
+			var out bytes.Buffer
+			cmd.Stdout = &out
+			cmd.Stderr = &out
+			newGroup(cmd)
+			err = cmd.Start()
+			wait = func() (string, error) {
+				err := cmd.Wait()
+				return out.String(), err
+			}
+		}
+
+		if err != nil {
+			st.LastError = err.Error()
+		} else {
+			done := make(chan exitResult, 1)
+			go func() {
+				out, err := wait()
+				done <- exitResult{out: out, err: err}
+			}()
+
+			started := time.Now()
+			up := time.After(upAfter)
+		running:
+			for {
+				select {
+				case <-up:
+					set(StatusUp)
+				case r := <-done:
+					st.LastError = lastLine(r.out)
+					if st.LastError == "" && r.err != nil {
+						st.LastError = r.err.Error()
+					}
+					break running
+				case <-stop:
+					killGroup(cmd)
+					<-done
+					cleanup()
+					return nil
+				}
+			}
+			if time.Since(started) >= upAfter {
+				delay = retryDelay
+			}
+		}
+		cleanup()
+
+		st.Restarts++
+		set(StatusReconnecting)
+		log.Logger.Info("Tunnel dropped, reconnecting", "tunnel", st.Forward, "connection", st.Connection, "err", st.LastError, "in", delay)
+		select {
+		case <-time.After(delay):
+		case <-stop:
+			return nil
+		}
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
@@ -0,0 +1,462 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nicknickel/gossh/internal/config"
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/runcommand"
+)
+
+const tunnelUsage = `Usage: gossh tunnel <command> [options]
+
+Commands:
+  start <connection> [forward...]   start the connection's forwards (all of them by default) in the background
+  stop <connection> [forward...]    stop the connection's running forwards
+  stop -all                         stop every running tunnel
+  list                              list running tunnels and their status
+`
+
+const (
+	StatusConnecting   = "connecting"
+	StatusUp           = "up"
+	StatusReconnecting = "reconnecting"
+)
+
+// State is written to the runtime directory by the process supervising a
+// tunnel and removed when it stops
+type State struct {
+	Connection string    `json:"connection"`
+	Forward    string    `json:"forward"`
+	Spec       string    `json:"spec"`
+	PID        int       `json:"pid"`
+	Status     string    `json:"status"`
+	Started    time.Time `json:"started"`
+	Since      time.Time `json:"since"`
+	Restarts   int       `json:"restarts"`
+	LastError  string    `json:"last_error,omitempty"`
+	// Interactive tunnels run inside a gossh session, such as a SOCKS proxy
+	// opened from the menu, so stopping the PID would stop the session
+	Interactive bool `json:"interactive,omitempty"`
+}
+
+var unsafeIDChar = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// RuntimeDir is where tunnel state and logs are kept: GOSSH_RUNTIME_DIR,
+// otherwise $XDG_RUNTIME_DIR/gossh or a per-user temporary directory
+func RuntimeDir() (string, error) {
+	dir := os.Getenv("GOSSH_RUNTIME_DIR")
+	if dir == "" {
+		if xdg := os.Getenv("XDG_RUNTIME_DIR"); xdg != "" {
+			dir = filepath.Join(xdg, "gossh")
+		} else {
+			name := "gossh"
+			if u, err := user.Current(); err == nil {
+				name += "-" + unsafeIDChar.ReplaceAllString(u.Username, "_")
+			}
+			dir = filepath.Join(os.TempDir(), name)
+		}
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// ID names the state and log files of a connection's forward
+func ID(conn string, forward string) string {
+	return unsafeIDChar.ReplaceAllString(conn, "_") + "__" + unsafeIDChar.ReplaceAllString(forward, "_")
+}
+
+func validPort(p string) bool {
+	n, err := strconv.Atoi(p)
+	return err == nil && n > 0 && n < 65536
+}
+
+// validAddr checks [host:]port, where host may be a bracketed IPv6 address
+func validAddr(addr string, needHost bool) bool {
+	if strings.ContainsAny(addr, " \t") {
+		return false
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return !needHost && validPort(addr)
+	}
+	return validPort(port) && (host != "" || !needHost)
+}
+
+// Args returns the ssh arguments for the forward
+func Args(f connection.Forward) ([]string, error) {
+	if !validAddr(f.Listen, false) {
+		return nil, fmt.Errorf("forward %v: listen must be [address:]port, got %q", f.Label(), f.Listen)
+	}
+
+	opt := ""
+	switch f.Type {
+	case "local":
+		opt = "-L"
+	case "remote":
+		opt = "-R"
+	case "dynamic":
+		if f.Target != "" {
+			return nil, fmt.Errorf("forward %v: dynamic forwards do not have a target", f.Label())
+		}
+		return []string{"-D", f.Listen}, nil
+	default:
+		return nil, fmt.Errorf("forward %v: type must be local, remote or dynamic, got %q", f.Label(), f.Type)
+	}
+
+	if !validAddr(f.Target, true) {
+		return nil, fmt.Errorf("forward %v: target must be host:port, got %q", f.Label(), f.Target)
+	}
+	return []string{opt, f.Listen + ":" + f.Target}, nil
+}
+
+// Command returns the ssh command that holds the forward open. It runs no
+// remote command and exits when the forward can not be set up or the
+// connection stops responding.
+func Command(i connection.Item, f connection.Forward) (*exec.Cmd, []*runcommand.Responder, func(), error) {
+	args, err := Args(f)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// there is no shell to automate
+	i.Conn.Automation = nil
+
+	c := []string{"ssh", "-N", "-o", "ExitOnForwardFailure=yes", "-o", "ServerAliveInterval=15", "-o", "ServerAliveCountMax=3"}
+	c = append(c, args...)
+	c = append(c, "{{.FinalAddr}}")
+
+	cmd, responders, cleanup, err := runcommand.PrepareCommand(&i, c)
+	if err != nil {
+		cleanup()
+		return nil, nil, nil, err
+	}
+	// nobody can answer prompts in the background, sshpass answers its own
+	if len(responders) == 0 && cmd.Args[0] == "ssh" {
+		cmd.Args = slices.Insert(cmd.Args, 1, "-o", "BatchMode=yes")
+	}
+	return cmd, responders, cleanup, nil
+}
+
+// FreePort returns a local port that is not in use
+func FreePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// SocksForward is an ad-hoc SOCKS proxy on the local port
+func SocksForward(port int) connection.Forward {
+	return connection.Forward{Name: fmt.Sprintf("socks-%d", port), Type: "dynamic", Listen: fmt.Sprintf("127.0.0.1:%d", port)}
+}
+
+func statePath(dir string, conn string, forward string) string {
+	return filepath.Join(dir, ID(conn, forward)+".json")
+}
+
+func writeState(dir string, st State) error {
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	p := statePath(dir, st.Connection, st.Forward)
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// List returns the running tunnels. State left behind by supervisors that
+// no longer exist is removed.
+func List() ([]State, error) {
+	dir, err := RuntimeDir()
+	if err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var states []State
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var st State
+		if err := json.Unmarshal(b, &st); err != nil {
+			continue
+		}
+		if !alive(st.PID) {
+			os.Remove(file)
+			continue
+		}
+		states = append(states, st)
+	}
+
+	slices.SortFunc(states, func(a, b State) int {
+		return strings.Compare(ID(a.Connection, a.Forward), ID(b.Connection, b.Forward))
+	})
+	return states, nil
+}
+
+// Find returns the state of a running tunnel
+func Find(conn string, forward string) (State, bool) {
+	states, err := List()
+	if err != nil {
+		return State{}, false
+	}
+	for _, st := range states {
+		if st.Connection == conn && st.Forward == forward {
+			return st, true
+		}
+	}
+	return State{}, false
+}
+
+// waitFor polls until done returns true or the timeout passes
+func waitFor(timeout time.Duration, done func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !done() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return true
+}
+
+// Start runs the forward in the background by starting a supervising gossh
+// process, which reconnects whenever the connection drops
+func Start(i connection.Item, f connection.Forward) error {
+	if _, err := Args(f); err != nil {
+		return err
+	}
+	if st, ok := Find(i.Name, f.Label()); ok {
+		return fmt.Errorf("%v on %v is already running (pid %v)", f.Label(), i.Name, st.PID)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	dir, err := RuntimeDir()
+	if err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(filepath.Join(dir, ID(i.Name, f.Label())+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, "tunnel", "run", i.Name, f.Label())
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	pid := cmd.Process.Pid
+	// reap the supervisor if it exits while gossh is still running
+	go cmd.Wait()
+
+	if !waitFor(2*time.Second, func() bool { _, ok := Find(i.Name, f.Label()); return ok || !alive(pid) }) {
+		return fmt.Errorf("%v on %v did not start, see %v", f.Label(), i.Name, logFile.Name())
+	}
+	if _, ok := Find(i.Name, f.Label()); !ok {
+		return fmt.Errorf("%v on %v exited, see %v", f.Label(), i.Name, logFile.Name())
+	}
+	return nil
+}
+
+// Stop stops a running tunnel and waits for its supervisor to clean up.
+// Interactive tunnels are refused, they close with their gossh session.
+func Stop(st State) error {
+	if st.Interactive {
+		return fmt.Errorf("%v on %v runs inside gossh (pid %v), close it there", st.Forward, st.Connection, st.PID)
+	}
+	if err := terminate(st.PID); err != nil && alive(st.PID) {
+		return err
+	}
+	dir, err := RuntimeDir()
+	if err != nil {
+		return err
+	}
+	p := statePath(dir, st.Connection, st.Forward)
+	waitFor(2*time.Second, func() bool {
+		_, err := os.Stat(p)
+		return os.IsNotExist(err) || !alive(st.PID)
+	})
+	os.Remove(p)
+	return nil
+}
+
+// selectForwards returns the connection's forwards named in names, or all of
+// them when no names are given
+func selectForwards(i connection.Item, names []string) ([]connection.Forward, error) {
+	if len(i.Conn.Forwards) == 0 {
+		return nil, fmt.Errorf("connection %v has no forwards", i.Name)
+	}
+	if len(names) == 0 {
+		return i.Conn.Forwards, nil
+	}
+
+	var forwards []connection.Forward
+	for _, name := range names {
+		ind := slices.IndexFunc(i.Conn.Forwards, func(f connection.Forward) bool { return f.Label() == name })
+		if ind < 0 {
+			return nil, fmt.Errorf("connection %v has no forward %v", i.Name, name)
+		}
+		forwards = append(forwards, i.Conn.Forwards[ind])
+	}
+	return forwards, nil
+}
+
+// Describe summarises a tunnel's status for lists
+func Describe(st State, now time.Time) string {
+	s := fmt.Sprintf("%v for %v", st.Status, now.Sub(st.Since).Round(time.Second))
+	if st.Restarts > 0 {
+		s += fmt.Sprintf(", %v reconnects", st.Restarts)
+	}
+	if st.Interactive {
+		s += ", inside gossh"
+	}
+	if st.Status != StatusUp && st.LastError != "" {
+		s += ": " + st.LastError
+	}
+	return s
+}
+
+func tunnelStart(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: gossh tunnel start <connection> [forward...]")
+	}
+	item, ok := config.FindConnection(args[0])
+	if !ok {
+		return fmt.Errorf("connection %v not found", args[0])
+	}
+	forwards, err := selectForwards(item, args[1:])
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, f := range forwards {
+		if err := Start(item, f); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fmt.Printf("Started %v on %v\n", f.Label(), item.Name)
+	}
+	return errors.Join(errs...)
+}
+
+func tunnelStop(args []string) error {
+	fs := flag.NewFlagSet("stop", flag.ContinueOnError)
+	all := fs.Bool("all", false, "Stop every running tunnel")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !*all && fs.NArg() == 0 {
+		return errors.New("usage: gossh tunnel stop <connection> [forward...] or gossh tunnel stop -all")
+	}
+
+	states, err := List()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	stopped := 0
+	for _, st := range states {
+		if !*all && (st.Connection != fs.Arg(0) || (fs.NArg() > 1 && !slices.Contains(fs.Args()[1:], st.Forward))) {
+			continue
+		}
+		if err := Stop(st); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		stopped++
+		fmt.Printf("Stopped %v on %v\n", st.Forward, st.Connection)
+	}
+	if stopped == 0 && len(errs) == 0 {
+		fmt.Println("No matching tunnels are running")
+	}
+	return errors.Join(errs...)
+}
+
+func tunnelList() error {
+	states, err := List()
+	if err != nil {
+		return err
+	}
+	if len(states) == 0 {
+		fmt.Println("No tunnels are running")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CONNECTION\tFORWARD\tSPEC\tPID\tSTATUS")
+	now := time.Now()
+	for _, st := range states {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", st.Connection, st.Forward, st.Spec, st.PID, Describe(st, now))
+	}
+	return w.Flush()
+}
+
+func tunnelRun(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: gossh tunnel run <connection> <forward>")
+	}
+	item, ok := config.FindConnection(args[0])
+	if !ok {
+		return fmt.Errorf("connection %v not found", args[0])
+	}
+	forwards, err := selectForwards(item, args[1:])
+	if err != nil {
+		return err
+	}
+	return Supervise(item, forwards[0], stopSignals())
+}
+
+func Run(args []string) error {
+	if len(args) == 0 {
+		fmt.Print(tunnelUsage)
+		return errors.New("no tunnel command given")
+	}
+
+	switch args[0] {
+	case "start":
+		return tunnelStart(args[1:])
+	case "stop":
+		return tunnelStop(args[1:])
+	case "list":
+		return tunnelList()
+	case "run":
+		// started in the background by start
+		return tunnelRun(args[1:])
+	default:
+		fmt.Print(tunnelUsage)
+		return fmt.Errorf("unknown tunnel command %v", args[0])
+	}
+}
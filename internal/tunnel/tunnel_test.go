@@ -0,0 +1,211 @@
+package tunnel
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/nicknickel/gossh/internal/connection"
+	internal_log "github.com/nicknickel/gossh/internal/log"
+)
+
+// writeFakeSsh puts an ssh script on the PATH
+func writeFakeSsh(t *testing.T, script string) string {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ssh"), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatalf("Failed to write fake ssh: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("SSH_AUTH_SOCK", "")
+	return dir
+}
+
+func TestArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		forward  connection.Forward
+		expected []string
+	}{
+		{name: "local", forward: connection.Forward{Type: "local", Listen: "5432", Target: "db.internal:5432"}, expected: []string{"-L", "5432:db.internal:5432"}},
+		{name: "bound remote", forward: connection.Forward{Type: "remote", Listen: "127.0.0.1:8080", Target: "localhost:80"}, expected: []string{"-R", "127.0.0.1:8080:localhost:80"}},
+		{name: "ipv6 target", forward: connection.Forward{Type: "local", Listen: "2222", Target: "[fd00::1]:22"}, expected: []string{"-L", "2222:[fd00::1]:22"}},
+		{name: "dynamic", forward: connection.Forward{Type: "dynamic", Listen: "1080"}, expected: []string{"-D", "1080"}},
+		{name: "bad type", forward: connection.Forward{Type: "sideways", Listen: "1080"}},
+		{name: "bad port", forward: connection.Forward{Type: "dynamic", Listen: "70000"}},
+		{name: "missing target", forward: connection.Forward{Type: "local", Listen: "5432"}},
+		{name: "target without host", forward: connection.Forward{Type: "local", Listen: "5432", Target: "5432"}},
+		{name: "dynamic with target", forward: connection.Forward{Type: "dynamic", Listen: "1080", Target: "a:1"}},
+		{name: "space", forward: connection.Forward{Type: "local", Listen: "5432", Target: "db internal:5432"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Args(tt.forward)
+			if (err != nil) != (tt.expected == nil) || !slices.Equal(got, tt.expected) {
+				t.Errorf("Args() = %v, %v, want %v", got, err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCommand(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	writeFakeSsh(t, "exit 0")
+	item := connection.Item{Name: "bastion", Conn: connection.Connection{
+		User:       "ops",
+		Automation: []connection.AutomationStep{{Expect: "\\$", Send: "sudo -i"}},
+	}}
+
+	cmd, responders, cleanup, err := Command(item, connection.Forward{Type: "local", Listen: "5432", Target: "db:5432"})
+	if err != nil {
+		t.Fatalf("Command() err = %v", err)
+	}
+	defer cleanup()
+	if len(responders) != 0 {
+		t.Errorf("automation should not run for tunnels")
+	}
+	args := strings.Join(cmd.Args, " ")
+	for _, expected := range []string{"-o BatchMode=yes", "-N", "ExitOnForwardFailure=yes", "-L 5432:db:5432 ops@bastion"} {
+		if !strings.Contains(args, expected) {
+			t.Errorf("ssh args %q missing %q", args, expected)
+		}
+	}
+}
+
+func TestSocksForward(t *testing.T) {
+	port, err := FreePort()
+	if err != nil || port == 0 {
+		t.Fatalf("FreePort() = %v, %v", port, err)
+	}
+	args, err := Args(SocksForward(port))
+	if err != nil || !slices.Equal(args, []string{"-D", "127.0.0.1:" + strconv.Itoa(port)}) {
+		t.Errorf("Args(SocksForward()) = %v, %v", args, err)
+	}
+}
+
+func TestSelectForwards(t *testing.T) {
+	item := connection.Item{Name: "bastion", Conn: connection.Connection{Forwards: []connection.Forward{
+		{Name: "db", Type: "local", Listen: "5432", Target: "db:5432"},
+		{Type: "dynamic", Listen: "1080"},
+	}}}
+
+	if got, err := selectForwards(item, nil); err != nil || len(got) != 2 {
+		t.Errorf("selectForwards() all = %v, %v", got, err)
+	}
+	if got, err := selectForwards(item, []string{"dynamic-1080"}); err != nil || len(got) != 1 || got[0].Listen != "1080" {
+		t.Errorf("selectForwards() by label = %v, %v", got, err)
+	}
+	if _, err := selectForwards(item, []string{"web"}); err == nil {
+		t.Errorf("expected an error for an unknown forward")
+	}
+	if _, err := selectForwards(connection.Item{Name: "none"}, nil); err == nil {
+		t.Errorf("expected an error for a connection without forwards")
+	}
+}
+
+func TestSupervise(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	t.Setenv("GOSSH_RUNTIME_DIR", t.TempDir())
+	upAfter, retryDelay = 200*time.Millisecond, 10*time.Millisecond
+	t.Cleanup(func() { upAfter, retryDelay = 3*time.Second, time.Second })
+
+	// the first attempt fails to bind, the second stays up
+	dir := writeFakeSsh(t, `
+if [ ! -f "$(dirname "$0")/tried" ]; then
+	touch "$(dirname "$0")/tried"
+	echo "bind [127.0.0.1]:5432: Address already in use" >&2
+	exit 255
+fi
+exec sleep 30`)
+
+	item := connection.Item{Name: "bastion"}
+	f := connection.Forward{Name: "db", Type: "local", Listen: "5432", Target: "db:5432"}
+	stop := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	go func() { done <- Supervise(item, f, stop) }()
+
+	var st State
+	up := waitFor(5*time.Second, func() bool {
+		var ok bool
+		st, ok = Find("bastion", "db")
+		return ok && st.Status == StatusUp
+	})
+	if !up {
+		t.Fatalf("tunnel did not come up, state = %+v", st)
+	}
+	if st.Restarts != 1 || !strings.Contains(st.LastError, "Address already in use") || st.PID != os.Getpid() {
+		t.Errorf("state = %+v", st)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "tried")); err != nil {
+		t.Errorf("fake ssh was not run twice")
+	}
+	if !strings.Contains(Describe(st, time.Now()), "up for") {
+		t.Errorf("Describe() = %v", Describe(st, time.Now()))
+	}
+
+	stop <- os.Interrupt
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Supervise() err = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Supervise() did not stop")
+	}
+	if _, ok := Find("bastion", "db"); ok {
+		t.Errorf("state not removed after stopping")
+	}
+}
+
+func TestList_Stale(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("GOSSH_RUNTIME_DIR", dir)
+
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Skipf("cannot run true: %v", err)
+	}
+	writeState(dir, State{Connection: "gone", Forward: "db", PID: exited.Process.Pid})
+	writeState(dir, State{Connection: "bastion", Forward: "db", PID: os.Getpid(), Status: StatusUp})
+
+	states, err := List()
+	if err != nil || len(states) != 1 || states[0].Connection != "bastion" {
+		t.Errorf("List() = %+v, %v", states, err)
+	}
+	if _, err := os.Stat(statePath(dir, "gone", "db")); !os.IsNotExist(err) {
+		t.Errorf("stale state was not removed")
+	}
+}
+
+func TestStop_Interactive(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("GOSSH_RUNTIME_DIR", dir)
+	st := State{Connection: "bastion", Forward: "socks", PID: os.Getpid(), Status: StatusUp, Interactive: true}
+	writeState(dir, st)
+
+	if err := Stop(st); err == nil || !strings.Contains(err.Error(), "inside gossh") {
+		t.Errorf("Stop() err = %v", err)
+	}
+	if states, _ := List(); len(states) != 1 || !states[0].Interactive {
+		t.Errorf("interactive state was removed: %+v", states)
+	}
+}
+
+func TestRuntimeDir(t *testing.T) {
+	xdg := t.TempDir()
+	t.Setenv("GOSSH_RUNTIME_DIR", "")
+	t.Setenv("XDG_RUNTIME_DIR", xdg)
+	if dir, err := RuntimeDir(); err != nil || dir != filepath.Join(xdg, "gossh") {
+		t.Errorf("RuntimeDir() = %v, %v", dir, err)
+	}
+	if ID("my host", "db/1") != "my_host__db_1" {
+		t.Errorf("ID() = %v", ID("my host", "db/1"))
+	}
+}
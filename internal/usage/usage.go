@@ -0,0 +1,270 @@
+package usage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/nicknickel/gossh/internal/config"
+	"github.com/nicknickel/gossh/internal/connection"
+	"github.com/nicknickel/gossh/internal/log"
+)
+
+const (
+	SortAlpha    = "alpha"
+	SortRecent   = "recent"
+	SortFrequent = "frequent"
+	SortFrecency = "frecency"
+
+	// older events are dropped
+	maxEvents = 1000
+)
+
+// SortModes in the order they are cycled through
+var SortModes = []string{SortAlpha, SortRecent, SortFrequent, SortFrecency}
+
+// Event is one use of a connection
+type Event struct {
+	Connection string    `json:"connection"`
+	Action     string    `json:"action"`
+	Time       time.Time `json:"time"`
+}
+
+// State is what gossh remembers between runs
+type State struct {
+	Events    []Event  `json:"events"`
+	Favorites []string `json:"favorites"`
+	Sort      string   `json:"sort"`
+}
+
+// StateFile is where the usage is kept, in GOSSH_STATE_DIR or
+// $XDG_STATE_HOME/gossh, defaulting to ~/.local/state/gossh
+func StateFile() (string, error) {
+	dir := os.Getenv("GOSSH_STATE_DIR")
+	if dir == "" {
+		state := os.Getenv("XDG_STATE_HOME")
+		if state == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", err
+			}
+			state = filepath.Join(home, ".local", "state")
+		}
+		dir = filepath.Join(state, "gossh")
+	}
+	return filepath.Join(dir, "usage.json"), nil
+}
+
+func Load() (State, error) {
+	var st State
+	file, err := StateFile()
+	if err != nil {
+		return st, err
+	}
+	f, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	if err := json.Unmarshal(f, &st); err != nil {
+		return State{}, fmt.Errorf("could not read %v: %w", file, err)
+	}
+	return st, nil
+}
+
+func Save(st State) error {
+	file, err := StateFile()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// update loads the state, changes it and saves it again
+func update(change func(*State)) error {
+	st, err := Load()
+	if err != nil {
+		log.Logger.Error("Replacing unreadable usage state", "err", err)
+	}
+	change(&st)
+	return Save(st)
+}
+
+// Record adds a use of each connection with the action
+func Record(items []connection.Item, action string, now time.Time) error {
+	return update(func(st *State) {
+		for _, item := range items {
+			st.Events = append(st.Events, Event{Connection: item.Name, Action: action, Time: now})
+		}
+		if len(st.Events) > maxEvents {
+			st.Events = st.Events[len(st.Events)-maxEvents:]
+		}
+	})
+}
+
+// SaveSort remembers the sort mode
+func SaveSort(mode string) error {
+	return update(func(st *State) { st.Sort = mode })
+}
+
+// ToggleFavorite adds or removes the connection from the favorites and
+// returns whether it is now a favorite
+func ToggleFavorite(name string) (bool, error) {
+	favorite := false
+	err := update(func(st *State) {
+		if ind := slices.Index(st.Favorites, name); ind >= 0 {
+			st.Favorites = slices.Delete(st.Favorites, ind, ind+1)
+		} else {
+			st.Favorites = append(st.Favorites, name)
+			favorite = true
+		}
+	})
+	return favorite, err
+}
+
+// Rename moves the history and favorite of a renamed connection
+func Rename(oldName string, name string) error {
+	return update(func(st *State) {
+		for ind, e := range st.Events {
+			if e.Connection == oldName {
+				st.Events[ind].Connection = name
+			}
+		}
+		if ind := slices.Index(st.Favorites, oldName); ind >= 0 {
+			st.Favorites[ind] = name
+		}
+	})
+}
+
+// LastUsed is the latest use of the connection
+func (st State) LastUsed(name string) (Event, bool) {
+	var last Event
+	found := false
+	for _, e := range st.Events {
+		if e.Connection == name && (!found || e.Time.After(last.Time)) {
+			last, found = e, true
+		}
+	}
+	return last, found
+}
+
+// NextSort is the sort mode after mode
+func NextSort(mode string) string {
+	ind := slices.Index(SortModes, mode)
+	return SortModes[(ind+1)%len(SortModes)]
+}
+
+// ValidSort returns mode, or alpha when it is not a sort mode
+func ValidSort(mode string) string {
+	if slices.Contains(SortModes, mode) {
+		return mode
+	}
+	return SortAlpha
+}
+
+// frecencyWeight scores a use by its age, like browsers rank history
+func frecencyWeight(age time.Duration) float64 {
+	days := age.Hours() / 24
+	switch {
+	case days < 4:
+		return 100
+	case days < 14:
+		return 70
+	case days < 31:
+		return 50
+	case days < 90:
+		return 30
+	default:
+		return 10
+	}
+}
+
+type stats struct {
+	last     time.Time
+	count    int
+	frecency float64
+}
+
+func (st State) stats(now time.Time) map[string]stats {
+	s := map[string]stats{}
+	for _, e := range st.Events {
+		cur := s[e.Connection]
+		if e.Time.After(cur.last) {
+			cur.last = e.Time
+		}
+		cur.count++
+		cur.frecency += frecencyWeight(now.Sub(e.Time))
+		s[e.Connection] = cur
+	}
+	return s
+}
+
+// Sort orders the items with favorites first, then by the mode, and
+// alphabetically when equal. Item indexes are renumbered to the new order.
+func Sort(items []list.Item, mode string, st State, now time.Time) []list.Item {
+	s := st.stats(now)
+	sorted := slices.Clone(items)
+	slices.SortStableFunc(sorted, func(x, y list.Item) int {
+		a, b := x.(connection.Item), y.(connection.Item)
+		if a.Favorite != b.Favorite {
+			if a.Favorite {
+				return -1
+			}
+			return 1
+		}
+		sa, sb := s[a.Name], s[b.Name]
+		switch mode {
+		case SortRecent:
+			if c := sb.last.Compare(sa.last); c != 0 {
+				return c
+			}
+		case SortFrequent:
+			if sa.count != sb.count {
+				return sb.count - sa.count
+			}
+		case SortFrecency:
+			if sa.frecency != sb.frecency {
+				if sa.frecency > sb.frecency {
+					return -1
+				}
+				return 1
+			}
+		}
+		return strings.Compare(config.NormalizeString(a.Name), config.NormalizeString(b.Name))
+	})
+
+	for ind, val := range sorted {
+		item := val.(connection.Item)
+		item.Index = ind
+		sorted[ind] = item
+	}
+	return sorted
+}
+
+// Apply marks the favorites and sorts the items by the saved mode
+func Apply(items []list.Item, st State, now time.Time) []list.Item {
+	for ind, val := range items {
+		item := val.(connection.Item)
+		item.Favorite = slices.Contains(st.Favorites, item.Name)
+		items[ind] = item
+	}
+	return Sort(items, ValidSort(st.Sort), st, now)
+}
@@ -0,0 +1,123 @@
+package usage
+
+import (
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/log"
+	"github.com/nicknickel/gossh/internal/connection"
+	internal_log "github.com/nicknickel/gossh/internal/log"
+)
+
+func TestSort(t *testing.T) {
+	now := time.Date(2026, 1, 31, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	st := State{Events: []Event{
+		// old but frequent
+		{Connection: "db1", Time: now.Add(-100 * day)},
+		{Connection: "db1", Time: now.Add(-100 * day)},
+		{Connection: "db1", Time: now.Add(-100 * day)},
+		{Connection: "db1", Time: now.Add(-100 * day)},
+		// recent
+		{Connection: "web2", Time: now.Add(-time.Hour)},
+		{Connection: "web2", Time: now.Add(-2 * day)},
+		{Connection: "web1", Time: now.Add(-10 * day)},
+	}}
+
+	tests := []struct {
+		mode      string
+		favorites []string
+		expected  []string
+	}{
+		{mode: SortAlpha, expected: []string{"backup", "db1", "web1", "web2"}},
+		{mode: SortRecent, expected: []string{"web2", "web1", "db1", "backup"}},
+		{mode: SortFrequent, expected: []string{"db1", "web2", "web1", "backup"}},
+		{mode: SortFrecency, expected: []string{"web2", "web1", "db1", "backup"}},
+		{mode: SortRecent, favorites: []string{"backup", "db1"}, expected: []string{"db1", "backup", "web2", "web1"}},
+		{mode: "unknown", expected: []string{"backup", "db1", "web1", "web2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			var items []list.Item
+			for ind, name := range []string{"web1", "db1", "web2", "backup"} {
+				items = append(items, connection.Item{Name: name, Index: ind})
+			}
+			st.Sort, st.Favorites = tt.mode, tt.favorites
+			var got []string
+			for ind, val := range Apply(items, st, now) {
+				item := val.(connection.Item)
+				if item.Index != ind {
+					t.Errorf("%v has index %v at %v", item.Name, item.Index, ind)
+				}
+				if item.Favorite != slices.Contains(tt.favorites, item.Name) {
+					t.Errorf("%v Favorite = %v", item.Name, item.Favorite)
+				}
+				got = append(got, item.Name)
+			}
+			if !slices.Equal(got, tt.expected) {
+				t.Errorf("Apply() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestState(t *testing.T) {
+	internal_log.Logger = log.New(io.Discard)
+	t.Setenv("GOSSH_STATE_DIR", t.TempDir())
+	now := time.Now().Truncate(time.Second)
+
+	if st, err := Load(); err != nil || len(st.Events) != 0 {
+		t.Fatalf("Load() without a state file = %+v, %v", st, err)
+	}
+	items := []connection.Item{{Name: "web1"}, {Name: "web2"}}
+	if err := Record(items, "RunCommand", now); err != nil {
+		t.Fatalf("Record() err = %v", err)
+	}
+	if fav, err := ToggleFavorite("web2"); !fav || err != nil {
+		t.Errorf("ToggleFavorite() = %v, %v", fav, err)
+	}
+	if fav, _ := ToggleFavorite("web1"); !fav {
+		t.Errorf("ToggleFavorite() did not add web1")
+	}
+	if fav, _ := ToggleFavorite("web2"); fav {
+		t.Errorf("ToggleFavorite() did not remove web2")
+	}
+	SaveSort(SortFrecency)
+	Rename("web1", "web3")
+
+	st, err := Load()
+	if err != nil {
+		t.Fatalf("Load() err = %v", err)
+	}
+	if len(st.Events) != 2 || st.Events[0].Connection != "web3" || st.Events[1].Connection != "web2" || st.Events[1].Action != "RunCommand" || !st.Events[1].Time.Equal(now) {
+		t.Errorf("Events = %+v", st.Events)
+	}
+	if !slices.Equal(st.Favorites, []string{"web3"}) || st.Sort != SortFrecency {
+		t.Errorf("State = %+v", st)
+	}
+
+	many := make([]connection.Item, maxEvents+5)
+	Record(many, "Connect", now)
+	if st, _ := Load(); len(st.Events) != maxEvents || st.Events[0].Action != "Connect" {
+		t.Errorf("events were not capped: %v", len(st.Events))
+	}
+}
+
+func TestNextSort(t *testing.T) {
+	mode := SortAlpha
+	var got []string
+	for range SortModes {
+		mode = NextSort(mode)
+		got = append(got, mode)
+	}
+	if !slices.Equal(got, []string{SortRecent, SortFrequent, SortFrecency, SortAlpha}) {
+		t.Errorf("NextSort() cycle = %v", got)
+	}
+	if NextSort("bogus") != SortAlpha {
+		t.Errorf("NextSort() of an unknown mode should start over")
+	}
+}